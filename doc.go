@@ -7,7 +7,7 @@ RCL January 2020
 
 Introduction
 
-This programme attempts to create annotated A4 PDF files from reMarkable
+This programme attempts to create annotated PDF files from reMarkable
 tablet file groups (RM bundles), including .rm files recording marks.
 
 Normally these files will be in a local directory, such as an xochitl
@@ -21,10 +21,31 @@ The programme takes as input either:
 filename extension, together with a PDF template to use for the
 background (a blank A4 template is provided in templates/A4.pdf).
 
+Output pages take the size of their background PDF pages, with the
+marks fitted to the width of each page and centred vertically as on
+the tablet.
+
+The template used by each notebook page on the tablet is read from the
+.pagedata or .content file. If no template is given, pages which used
+the stock lined, grid or dotted templates are drawn with vector
+re-creations of them.
+
+With the --template-dir option, the template of each page is instead
+found by name in a directory of templates, such as a copy of the
+tablet's /usr/share/remarkable/templates, as a pdf, svg, png or jpeg file,
+falling back to the -t template and then the embedded A4 template.
+The --template-pages option cycles through, or matches, the pages of
+multi-page templates, and --template-page-map chooses the template
+page used for given pages.
+
+PNG and JPEG templates, such as the tablet's png templates or a scan of
+paper, are drawn in the Background layer, fitted to the tablet display
+with their aspect kept and centred.
+
 The resulting PDF is layered with the background and .rm file layers
 each in a separated PDF layer. The .rm file marks are stroked using the
-fpdf PDF library, although .rm tilt and pressure characteristics are not
-represented in the PDF output.
+fpdf PDF library. Pens such as the pencils and paintbrush are drawn with
+widths varying with the .rm pressure characteristics.
 
 PDF files from sources such as Microsoft Word do not always work
 well. It can help to rewrite them using the pdftk tool, e.g. by doing
@@ -49,9 +70,75 @@ Example of processing an rm bundle with a pdf, and per-layer colours:
 
 General options:
 
-	rm2pdf [-v] [-c red] [-c green] [-c ...] [-t template] InputPath OutputFile
+	rm2pdf [-v] [-n] [-c red] [-c green] [-c ...] [-t template] InputPath OutputFile
+
+Warning: the OutputFile will be overwritten if it exists, unless the -n
+or --no-clobber option is used. Use '-' as the OutputFile to write the
+PDF to stdout.
+
+To write a layered SVG image of the marks on each page in place of a
+PDF, use the -g or --svg option. The images are named OutputFile-01.svg,
+OutputFile-02.svg and so on, with each reMarkable layer in an Inkscape
+layer. The background PDF is not drawn, but sets the size of the images.
+
+To write PNG images of each page, or JPEG images if the OutputFile ends
+in .jpg or .jpeg, use the -r or --raster option. The --dpi option sets
+the resolution, and --background a PNG or JPEG image, such as a
+reMarkable png template, to draw behind the marks.
+
+To convert only some pages use the -p or --pages option with pages and
+page ranges such as 1-3,7,10-, the --annotated option for only the
+pages with marks, or the --inserted option for only the pages inserted
+on the tablet. The output pages are labelled with their page numbers in
+the original document.
+
+To write the marks as pdf ink annotations, which can be listed, hidden
+or removed in the comment panes of pdf readers, in place of layers, use
+the --annotations option. If every page of an annotated pdf is
+converted, the annotations are added to the original pdf as an
+incremental update, leaving its content unchanged.
+
+To convert every document in a library, such as a copy of a tablet's
+xochitl directory, use the -l or --library option with the library as
+the InputPath and an output directory as the OutputFile. Each document
+is written to the folder hierarchy shown on the tablet, such as
+output/Work/Projects/Meeting notes.pdf:
+
+	rm2pdf -l xochitl output
 
-Warning: the OutputFile will be overwritten if it exists.
+With the --cache option the state of each converted document is
+recorded in a cache file, and only changed documents are converted on
+later runs, while the PDFs of removed and trashed documents are deleted.
+
+To keep the OutputFile up to date while a bundle is being annotated,
+use the -w or --watch option. The bundle's files are polled every
+--interval and the PDF rebuilt once changes stop for --debounce, until
+the programme is interrupted.
+
+To show the metadata and the size, rotation and label of each page of a
+PDF, use the -i or --info option:
+
+	rm2pdf -i testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf
+
+Library use
+
+The rmpdf package provides a Converter, configured with functional
+options, which may be used to convert many bundles, including from
+several goroutines at once:
+
+	c, err := rmpdf.NewConverter(
+		rmpdf.WithTemplate("templates/A4.pdf"),
+		rmpdf.WithPenConfigFile("config_example.yaml"),
+		rmpdf.WithLogger(log.New(os.Stderr, "", 0)),
+	)
+	err = c.Convert("testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7", "/tmp/output.pdf")
+
+ConvertTo writes the PDF to an io.Writer, such as an http.ResponseWriter.
+ConvertLibrary converts each document found by files.ScanLibrary.
+
+The .rm files of the pages of a PDF are parsed and placed on the page
+by a pool of workers, one for each CPU unless set with WithWorkers,
+while the pages are added to the PDF in order.
 
 
 ReMarkable .rm file parser
@@ -67,7 +154,13 @@ are from rm2svg.
 RMParser provides a python-like iterator based on bufio.Scan, which
 iterates over the referenced reMarkable .rm file returning a data
 structure consisting of each path with its associated layer and path
-segments.
+segments. Version 3 .rm files from early tablet software are also
+parsed by rmparse.
+
+Version 6 .rm files, made by reMarkable software version 3, are parsed
+by rmparsev6, which provides the same iterator. The rmlines package
+detects the version of an .rm file from its header and returns a
+version-neutral page of layers and strokes, which is used for drawing.
 
 Usage example:
 
@@ -81,6 +174,9 @@ Usage example:
 			// do something with path and/or segment
 		}
 	}
+	if err := rm.Err(); err != nil {
+		// the .rm file is corrupt
+	}
 
 
 PDF paths, strokes and colours
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"strconv"
@@ -27,23 +28,53 @@ type RMFileInfo struct {
 	Version            int    // version from metadata
 	VisibleName        string // visibleName from metadata (used in reMarkable interface)
 	LastModified       time.Time
+	Type               string // DocumentType or CollectionType (a folder)
+	Parent             string // uuid of the parent folder, "trash" or empty
+	Deleted            bool
+	Pinned             bool // pinned, or marked as a favourite
+	Synced             bool
+	Modified           bool
+	MetadataModified   bool
+	CreatedTime        time.Time // zero if not recorded
+	LastOpened         time.Time // zero if not recorded
+	LastOpenedPage     int       // 0-indexed
 	OriginalPageCount  int
 	PageCount          int
 	Pages              []RMPage
 	Orientation        string
 	RedirectionPageMap []int // page insertion info
+	TemplatePages      TemplatePageMode
+	TemplatePageMap    map[int]int // 0-indexed template pages by 0-indexed page
 	// show inserted pages
 	insertedPages
 	// page number used for processing
 	thisPageNo int
 	Debugging  bool
+	Logger     *log.Logger // debugging output, or stdout if nil
 }
 
-// Debug prints a message if the debugging switch is on
+// IsFolder reports if the metadata describes a folder rather than a
+// document
+func (r *RMFileInfo) IsFolder() bool {
+	return r.Type == CollectionType
+}
+
+// IsTrashed reports if the document is deleted or in the tablet's trash
+func (r *RMFileInfo) IsTrashed() bool {
+	return r.Deleted || r.Parent == TrashParent
+}
+
+// Debug prints a message to the logger, or to stdout if there is no
+// logger, if the debugging switch is on
 func (r *RMFileInfo) Debug(d string) {
-	if r.Debugging {
-		fmt.Println(d)
+	if !r.Debugging {
+		return
 	}
+	if r.Logger != nil {
+		r.Logger.Println(d)
+		return
+	}
+	fmt.Println(d)
 }
 
 // InsertedPages is a public export of the embedded insertedPages human
@@ -78,6 +109,16 @@ func (ip insertedPages) insertedPageNumbers() string {
 	return o
 }
 
+// index returns the index of pageNo among the inserted pages
+func (ip insertedPages) index(pageNo int) int {
+	for i, v := range ip {
+		if v == pageNo {
+			return i
+		}
+	}
+	return 0
+}
+
 // register inserted pages
 func (r *RMFileInfo) registerInsertedPages() {
 	for i, v := range r.RedirectionPageMap {
@@ -99,7 +140,9 @@ func (r *RMFileInfo) registerInsertedPages() {
 // 1      | 0       | yes      | template.pdf  |
 // 2      | 1       | no       | annotated.pdf |
 //
-// This function returns 0-indexed pdf pages
+// This function returns 0-indexed pdf pages. The page of the template
+// used for each template page is chosen by TemplatePageMap and
+// TemplatePages, as described by templatePage.
 //
 // Returning an io.ReadSeeker from an fs.File is described by Ian Lance
 // Taylor at https://github.com/golang/go/issues/44175#issuecomment-775545730
@@ -107,11 +150,11 @@ func (r *RMFileInfo) PageIterate() (pageNo, pdfPageNo int, inserted, isTemplate
 	pageNo = r.thisPageNo
 	r.thisPageNo++
 
-	// if there is only a template, always return the first page
+	// if there is only a template, every page is a template page
 	if r.pdfPath == "" {
-		pdfPageNo = 0
+		pdfPageNo = r.templatePage(pageNo, pageNo)
 		isTemplate = true
-		reader = &r.templateReader
+		reader = &r.templateFor(pageNo).reader
 		return
 	}
 
@@ -120,10 +163,10 @@ func (r *RMFileInfo) PageIterate() (pageNo, pdfPageNo int, inserted, isTemplate
 
 	// return the template if this is an inserted page
 	if hasRedir && r.RedirectionPageMap[pageNo] == -1 {
-		pdfPageNo = 0
+		pdfPageNo = r.templatePage(pageNo, r.insertedPages.index(pageNo))
 		inserted = true
 		isTemplate = true
-		reader = &r.templateReader
+		reader = &r.templateFor(pageNo).reader
 		return
 	}
 
@@ -148,25 +191,65 @@ func (r *RMFileInfo) PageIterate() (pageNo, pdfPageNo int, inserted, isTemplate
 
 }
 
+// templateFor returns the template for the 0-indexed page pageNo,
+// being the template from the template directory named by the page's
+// tablet template, if any, or else the user provided or embedded
+// template
+func (r *RMFileInfo) templateFor(pageNo int) *templateFile {
+	if pageNo < len(r.Pages) {
+		if tf, ok := r.templates[r.Pages[pageNo].Template]; ok {
+			return tf
+		}
+	}
+	return r.template
+}
+
+// TemplatePath returns the path of the template used for the 0-indexed
+// page pageNo, if it is a template page, reporting if the template was
+// found in the template directory by the name of the page's tablet
+// template
+func (r *RMFileInfo) TemplatePath(pageNo int) (string, bool) {
+	tf := r.templateFor(pageNo)
+	return tf.path, tf != r.template
+}
+
+// TemplateImage returns the image template used for the 0-indexed page
+// pageNo, if it is a template page and its template is a png or jpeg
+// image rather than a pdf
+func (r *RMFileInfo) TemplateImage(pageNo int) (*TemplateImage, bool) {
+	tf := r.templateFor(pageNo)
+	return tf.image, tf.image != nil
+}
+
 // RMPage is a struct defining metadata about each .rm file associated
 // with the PDF described in an RMFileInfo. Note that while the .content
 // file records page UUIDs for each page of the original PDF, .rm and
-// the related file are only made for those pages which have marks
+// the related file are only made for those pages which have marks.
+// Pages without an .rm file are recorded with Exists set to false.
 type RMPage struct {
 	*rmFileDesc // the rm file descriptor
 	PageNo      int
 	Identifier  string   // the uuid used to identify the RM file
 	Exists      bool     // file exists on disk
 	LayerNames  []string // layer names by implicit index
+	Template    string   // the name of the tablet template, such as "P Lines medium"
 }
 
-// RMFile returns the fs.File pointing to the .rm file
+// RMFile returns the fs.File pointing to the .rm file, or nil if the
+// page has no .rm file
 func (r *RMPage) RMFile() fs.File {
+	if r.rmFileDesc == nil {
+		return nil
+	}
 	return r.rm
 }
 
-// RMFilePath returns the .rm file path
+// RMFilePath returns the .rm file path, or an empty string if the page
+// has no .rm file
 func (r *RMPage) RMFilePath() string {
+	if r.rmFileDesc == nil {
+		return ""
+	}
 	return r.rmPath
 }
 
@@ -188,29 +271,64 @@ type content struct {
 	FormatVersion int    `json:"formatVersion"` // version 3 remarkable software introduced FormatVersion 2
 	Pages         []string
 	CPages        struct { // added in version 3
+		Original struct {
+			Value int `json:"value"`
+		} `json:"original"`
 		Pages []struct {
-			ID string `json:"id"`
+			ID    string `json:"id"`
+			Redir *struct { // absent for inserted pages
+				Value int `json:"value"`
+			} `json:"redir"`
+			Template *struct {
+				Value string `json:"value"`
+			} `json:"template"`
 		} `json:"pages"`
 	} `json:"cPages,omitempty"`
 	RedirectionPageMap []int `json:"redirectionPageMap"`
 	OriginalPageCount  int   `json:"originalPageCount"`
 }
 
+// Metadata types of documents and folders
+const (
+	DocumentType   = "DocumentType"
+	CollectionType = "CollectionType"
+)
+
+// TrashParent is the parent of documents and folders in the tablet's
+// trash
+const TrashParent = "trash"
+
 // Per-pdf file .metadata json file decoding: epoch time property
 type epochTime time.Time
 
-// Per-pdf file .metadata json file decoding: general metadata
+// Per-pdf file .metadata json file decoding: general metadata, also
+// used for folders
 type pdfMetadata struct {
-	LastModified epochTime `json:"lastmodified"`
-	Type         string    `json:"type"`
-	Version      int       `json:"version"`
-	VisibleName  string    `json:"visibleName"`
+	CreatedTime      epochTime `json:"createdTime"`
+	Deleted          bool      `json:"deleted"`
+	LastModified     epochTime `json:"lastmodified"`
+	LastOpened       epochTime `json:"lastOpened"`
+	LastOpenedPage   int       `json:"lastOpenedPage"`
+	MetadataModified bool      `json:"metadatamodified"`
+	Modified         bool      `json:"modified"`
+	Parent           string    `json:"parent"`
+	Pinned           bool      `json:"pinned"`
+	Synced           bool      `json:"synced"`
+	Type             string    `json:"type"`
+	Version          int       `json:"version"`
+	VisibleName      string    `json:"visibleName"`
 }
 
 // Custom json decoder for unix epochs, with reference to
 // https://gist.github.com/alexmcroberts/219127816e7a16c7bd70
+// Empty and zero times, used by the tablet for times not recorded, are
+// decoded as the zero time.
 func (t *epochTime) UnmarshalJSON(s []byte) (err error) {
 	r := strings.Replace(string(s), `"`, ``, -1)
+	if r == "" || r == "0" || r == "null" {
+		*(*time.Time)(t) = time.Time{}
+		return nil
+	}
 	q, err := strconv.ParseInt(r, 10, 64)
 	if err != nil {
 		return err
@@ -231,6 +349,16 @@ func (t epochTime) Format(str string) string {
 	return time.Time(t).Format(str)
 }
 
+// parsePagedata parses a .pagedata file, which records the template
+// name of each page on a separate line
+func parsePagedata(body []byte) []string {
+	templates := strings.Split(strings.TrimRight(string(body), "\r\n"), "\n")
+	for i, t := range templates {
+		templates[i] = strings.TrimSpace(t)
+	}
+	return templates
+}
+
 // Check if a file exists
 func checkFileExists(f string) error {
 	if _, err := os.Stat(f); os.IsNotExist(err) {
@@ -251,9 +379,18 @@ func checkFileExists(f string) error {
 // layer information for each associated .rm file in a directory named
 // by the uuid of the pdf.
 func RMFiler(inputpath string, template string) (RMFileInfo, error) {
+	return RMFilerWithTemplateDir(inputpath, template, "", nil)
+}
+
+// RMFilerWithTemplateDir collects information from the reMarkable files
+// associated with the uuid of interest, as for RMFiler, also loading
+// the template of each page from templateDir, if given, by the name of
+// the template used on the tablet. Pages whose template is not in
+// templateDir use the provided or embedded template. If debug is not
+// nil, debugging information about the bundle is written to it.
+func RMFilerWithTemplateDir(inputpath, template, templateDir string, debug *log.Logger) (RMFileInfo, error) {
 
-	rm := RMFileInfo{}
-	// rm.Debugging = true
+	rm := RMFileInfo{Debugging: debug != nil, Logger: debug}
 	var err error
 
 	// make a remarkable file system of files and scan the file system
@@ -300,6 +437,16 @@ func RMFiler(inputpath string, template string) (RMFileInfo, error) {
 		rm.Version = p.Version
 		rm.VisibleName = p.VisibleName
 		rm.LastModified = time.Time(p.LastModified)
+		rm.Type = p.Type
+		rm.Parent = p.Parent
+		rm.Deleted = p.Deleted
+		rm.Pinned = p.Pinned
+		rm.Synced = p.Synced
+		rm.Modified = p.Modified
+		rm.MetadataModified = p.MetadataModified
+		rm.CreatedTime = time.Time(p.CreatedTime)
+		rm.LastOpened = time.Time(p.LastOpened)
+		rm.LastOpenedPage = p.LastOpenedPage
 	}
 
 	// content
@@ -323,10 +470,30 @@ func RMFiler(inputpath string, template string) (RMFileInfo, error) {
 
 	// reMarkable software 3.0x introduced content file version 2, with
 	// a different page structure off a cPages structure.
-	// range over cPage.Page and add ID to c.Pages
+	// range over cPage.Page and add ID to c.Pages. Pages inserted into
+	// an annotated pdf have no redirection, which is recorded as -1 in
+	// the RedirectionPageMap as for earlier versions.
+	templates := []string{}
 	if c.FormatVersion > 0 {
+		redirs := []int{}
 		for _, p := range c.CPages.Pages {
 			c.Pages = append(c.Pages, p.ID)
+			template := ""
+			if p.Template != nil {
+				template = p.Template.Value
+			}
+			templates = append(templates, template)
+			if p.Redir == nil {
+				redirs = append(redirs, -1)
+			} else {
+				redirs = append(redirs, p.Redir.Value)
+			}
+		}
+		if len(c.RedirectionPageMap) == 0 && c.FileType == "pdf" {
+			c.RedirectionPageMap = redirs
+		}
+		if c.OriginalPageCount == 0 && c.CPages.Original.Value > 0 {
+			c.OriginalPageCount = c.CPages.Original.Value
 		}
 	}
 	rm.Debug(fmt.Sprintf("content : %+v\n", c))
@@ -347,6 +514,22 @@ func RMFiler(inputpath string, template string) (RMFileInfo, error) {
 
 	// note that template switching is done in fs.go
 
+	// the template of each page is recorded in the .content file by
+	// version 3 software, and otherwise in the .pagedata file
+	if strings.Join(templates, "") == "" && rm.pagedata != nil {
+		body, err = io.ReadAll(rm.pagedata)
+		if err != nil {
+			return rm, fmt.Errorf("could not read pagedata file %s : %s", rm.pagedataPath, err)
+		}
+		templates = parsePagedata(body)
+	}
+	if templateDir != "" {
+		err = rm.loadTemplateDir(templateDir, templates)
+		if err != nil {
+			return rm, err
+		}
+	}
+
 	// extract each rm json page and construct the path to the .rm file
 	// itself
 	for i, rmj := range c.Pages {
@@ -356,10 +539,14 @@ func RMFiler(inputpath string, template string) (RMFileInfo, error) {
 			Identifier: rmj,
 			Exists:     true,
 		}
+		if i < len(templates) {
+			rmP.Template = templates[i]
+		}
 
 		// some rm files described in the content json file don't
 		// necessarily get written to disk. If there is no file, set the
-		// page.Exists flag to false and continue processing.
+		// page.Exists flag to false and continue processing, so that
+		// rm.Pages is indexed by page number.
 		//
 		// rmfs.rmFiles map needs a path/uuid to extract the rmFileDesc
 		// note, however, that some older pre-2021 rmapi zip files use
@@ -377,14 +564,19 @@ func RMFiler(inputpath string, template string) (RMFileInfo, error) {
 		if !ok {
 			rmP.Exists = false
 			rm.Debug(fmt.Sprintf("rm file for pageno %d not found", i))
+			rm.Pages = append(rm.Pages, rmP)
 			continue
 		}
 
 		rmP.rmFileDesc = &rmfd
 
-		// todo: move this
+		// reMarkable software version 3 does not write a metadata file
+		// for each .rm file; the layer names are instead recorded in the
+		// version 6 .rm file itself
 		if rmfd.metadata == nil {
-			return rm, errors.New("software version 3 not supported -- no rm metadata file found")
+			rm.Debug(fmt.Sprintf("no rm metadata file for pageno %d", i))
+			rm.Pages = append(rm.Pages, rmP)
+			continue
 		}
 
 		// open and read json from rm .json file
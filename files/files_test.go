@@ -7,9 +7,9 @@ RCL January 2020
 package files
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"os"
-	"strings"
 	"testing"
 	"time"
 
@@ -339,6 +339,14 @@ func TestHorizontal(t *testing.T) {
 	if rmf.Orientation != "landscape" {
 		t.Errorf("Expected landscape orientation, got %s", rmf.Orientation)
 	}
+
+	// the second page has no .rm file
+	if len(rmf.Pages) != 2 || !rmf.Pages[0].Exists || rmf.Pages[1].Exists {
+		t.Fatalf("Expected 2 pages, the second without an rm file, got %+v", rmf.Pages)
+	}
+	if rmf.Pages[1].PageNo != 1 || rmf.Pages[1].RMFile() != nil || rmf.Pages[1].RMFilePath() != "" {
+		t.Errorf("Unexpected page without an rm file %+v", rmf.Pages[1])
+	}
 }
 
 // TestExtensionIgnored checks that when providing an input with an extension
@@ -398,10 +406,27 @@ func TestZipV3(t *testing.T) {
 
 	template := ""
 	rmf, err := RMFiler("../testfiles/version3.zip", template)
+	if err != nil {
+		t.Fatalf("v3 file should not error: %v", err)
+	}
 
-	expected := "software version 3 not supported -- no rm metadata file found"
-	if !strings.Contains(err.Error(), expected) {
-		t.Errorf("v3 file should error with %s, not %v", expected, err)
+	// layer names are recorded in the version 6 .rm files
+	if len(rmf.Pages) != 2 {
+		t.Fatalf("rm pages %d != 2", len(rmf.Pages))
+	}
+	for _, p := range rmf.Pages {
+		if len(p.LayerNames) != 0 {
+			t.Errorf("page %d should have no metadata layer names, got %v", p.PageNo, p.LayerNames)
+		}
+	}
+
+	// the second page is inserted, recorded by the lack of a cPages
+	// redirection
+	if rmf.OriginalPageCount != 1 {
+		t.Errorf("original page count %d != 1", rmf.OriginalPageCount)
+	}
+	if rmf.InsertedPages() != "2" {
+		t.Errorf("inserted pages %s != 2", rmf.InsertedPages())
 	}
 
 	pages := 0
@@ -417,3 +442,123 @@ func TestZipV3(t *testing.T) {
 		t.Errorf("page no %d != 2", pages)
 	}
 }
+
+// TestMetadata tests the decoding of the full .metadata schema
+func TestMetadata(t *testing.T) {
+
+	tests := []struct {
+		path     string
+		expected RMFileInfo
+	}{
+		{
+			path: "../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf",
+			expected: RMFileInfo{
+				Version:      17,
+				VisibleName:  "tpl",
+				LastModified: ptime("2019-12-28 23:17:19 +0000 GMT"),
+				Type:         DocumentType,
+				Synced:       true,
+			},
+		},
+		{
+			path: "../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c",
+			expected: RMFileInfo{
+				VisibleName:      "insert-pages",
+				LastModified:     ptime("2022-09-09 13:13:39 +0000 GMT"),
+				Type:             DocumentType,
+				Synced:           true,
+				Modified:         true,
+				MetadataModified: true,
+				LastOpened:       ptime("2022-09-09 13:11:01 +0000 GMT"),
+				LastOpenedPage:   1,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expected.VisibleName, func(t *testing.T) {
+			rmf, err := RMFiler(tt.path, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := tt.expected
+			if rmf.Version != e.Version || rmf.VisibleName != e.VisibleName || rmf.Type != e.Type {
+				t.Errorf("got %d %s %s wanted %d %s %s",
+					rmf.Version, rmf.VisibleName, rmf.Type, e.Version, e.VisibleName, e.Type)
+			}
+			if !rmf.LastModified.Equal(e.LastModified) || !rmf.LastOpened.Equal(e.LastOpened) || !rmf.CreatedTime.IsZero() {
+				t.Errorf("got times %s %s %s", rmf.LastModified, rmf.LastOpened, rmf.CreatedTime)
+			}
+			if rmf.Parent != e.Parent || rmf.Deleted != e.Deleted || rmf.Pinned != e.Pinned ||
+				rmf.Synced != e.Synced || rmf.Modified != e.Modified ||
+				rmf.MetadataModified != e.MetadataModified || rmf.LastOpenedPage != e.LastOpenedPage {
+				t.Errorf("got %+v", rmf)
+			}
+			if rmf.IsFolder() || rmf.IsTrashed() {
+				t.Errorf("document should not be a folder or trashed")
+			}
+		})
+	}
+}
+
+// TestMetadataFolderAndTrash tests the folder and trash states
+func TestMetadataFolderAndTrash(t *testing.T) {
+
+	tests := []struct {
+		json    string
+		folder  bool
+		trashed bool
+	}{
+		{`{"type": "CollectionType", "parent": "", "createdTime": "0"}`, true, false},
+		{`{"type": "CollectionType", "parent": "trash", "lastOpened": ""}`, true, true},
+		{`{"type": "DocumentType", "parent": "trash"}`, false, true},
+		{`{"type": "DocumentType", "deleted": true, "pinned": true}`, false, true},
+	}
+
+	for i, tt := range tests {
+		var p pdfMetadata
+		if err := json.Unmarshal([]byte(tt.json), &p); err != nil {
+			t.Fatal(err)
+		}
+		r := RMFileInfo{Type: p.Type, Parent: p.Parent, Deleted: p.Deleted}
+		if r.IsFolder() != tt.folder || r.IsTrashed() != tt.trashed {
+			t.Errorf("%d folder %t trashed %t", i, r.IsFolder(), r.IsTrashed())
+		}
+		if !time.Time(p.CreatedTime).IsZero() || !time.Time(p.LastOpened).IsZero() {
+			t.Errorf("%d unrecorded times should be zero", i)
+		}
+	}
+}
+
+// TestPageTemplates tests reading the template of each page from the
+// .pagedata file or, for version 3 software, the .content file
+func TestPageTemplates(t *testing.T) {
+
+	tests := []struct {
+		path      string
+		templates []string
+	}{
+		{"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf", []string{"Blank", "Blank"}},
+		{"../testfiles/horizontal_rmapi.zip", []string{"Blank", ""}},
+		{"../testfiles/version3.zip", []string{"Blank", "Blank"}},
+	}
+	for _, tt := range tests {
+		rmf, err := RMFiler(tt.path, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rmf.Pages) != len(tt.templates) {
+			t.Fatalf("%s pages %d not %d", tt.path, len(rmf.Pages), len(tt.templates))
+		}
+		for i, p := range rmf.Pages {
+			if p.Template != tt.templates[i] {
+				t.Errorf("%s page %d template %q not %q", tt.path, i+1, p.Template, tt.templates[i])
+			}
+		}
+	}
+
+	got := parsePagedata([]byte("P Lines small\nP Grid medium \r\nBlank\n\n"))
+	if !cmp.Equal(got, []string{"P Lines small", "P Grid medium", "Blank"}) {
+		t.Errorf("parsed pagedata %q", got)
+	}
+}
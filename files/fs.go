@@ -33,7 +33,7 @@ var rmAllExtensions = append(
 	[]string{
 		".content",
 		".metadata",
-		// ".pagedata", // only needed for rmapi zip files?
+		".pagedata", // page template names
 		".pdf",
 	}...,
 )
@@ -81,6 +81,8 @@ type RmFS struct {
 	content      fs.File // content
 	metadataPath string
 	metadata     fs.File // metadata
+	pagedataPath string
+	pagedata     fs.File // page template names, if any
 
 	// pdf, if any
 	pdfPath   string
@@ -88,11 +90,9 @@ type RmFS struct {
 	pdfBytes  []byte  // needed to readseek a zip file
 	pdfReader io.ReadSeeker
 
-	// template
-	templatePath   string
-	template       fs.File // either the user provided or embedded template
-	templateBytes  []byte  // needed to readseek a zip file
-	templateReader io.ReadSeeker
+	// templates
+	template  *templateFile            // either the user provided or embedded template
+	templates map[string]*templateFile // templates from a template directory by name
 
 	// per-page rm file metadata and stroke .rm file
 	rmFiles map[string]rmFileDesc // base path to rmFileDesc mapping
@@ -116,26 +116,9 @@ func NewZipRmFS(zipPath, tplPath string) (*RmFS, error) {
 		return &rm, err
 	}
 
-	if tplPath != "" {
-		rm.templatePath = tplPath
-		rm.template, err = os.Open(tplPath)
-		if err != nil {
-			return &rm, err
-		}
-		err = rm.templateReadSeeker()
-		if err != nil {
-			return &rm, fmt.Errorf("could not make template readseeker: %w", err)
-		}
-	} else {
-		rm.templatePath = "embedded A4.pdf"
-		rm.template, err = embeddedA4File.Open("A4.pdf")
-		if err != nil {
-			return &rm, fmt.Errorf("could not open embedded template file %s", err)
-		}
-		err = rm.templateReadSeeker()
-		if err != nil {
-			return &rm, fmt.Errorf("could not make template readseeker: %w", err)
-		}
+	rm.template, err = openTemplate(tplPath)
+	if err != nil {
+		return &rm, err
 	}
 	return &rm, nil
 }
@@ -154,27 +137,9 @@ func NewDirRmFS(path, basename, tplPath string) (*RmFS, error) {
 	rm.fs = os.DirFS(path)
 
 	var err error
-
-	if tplPath != "" {
-		rm.templatePath = tplPath
-		rm.template, err = os.Open(tplPath)
-		if err != nil {
-			return &rm, err
-		}
-		err = rm.templateReadSeeker()
-		if err != nil {
-			return &rm, fmt.Errorf("could not make template readseeker: %w", err)
-		}
-	} else {
-		rm.templatePath = "embedded A4.pdf"
-		rm.template, err = embeddedA4File.Open("A4.pdf")
-		if err != nil {
-			return &rm, fmt.Errorf("could not open embedded template file %s", err)
-		}
-		err = rm.templateReadSeeker()
-		if err != nil {
-			return &rm, fmt.Errorf("could not make template readseeker: %w", err)
-		}
+	rm.template, err = openTemplate(tplPath)
+	if err != nil {
+		return &rm, err
 	}
 
 	return &rm, nil
@@ -183,7 +148,7 @@ func NewDirRmFS(path, basename, tplPath string) (*RmFS, error) {
 // IdentifyPDF shows the pdf path in use
 func (rf *RmFS) IdentifyPDF(isTpl bool) string {
 	if isTpl {
-		return rf.templatePath
+		return rf.template.path
 	}
 	return rf.pdfPath
 }
@@ -235,32 +200,6 @@ func (rf *RmFS) pdfReadSeeker() error {
 	return nil
 }
 
-// templateReadSeeker makes the Template an io.ReadSeeker. Underlying
-// the fs.File, an os.File supports seeking, but files from a zip do
-// not, so detect that and return a bytes.NewReader if necessary
-func (rf *RmFS) templateReadSeeker() error {
-	var (
-		err error
-		ok  bool
-	)
-	if rf.template == nil {
-		return errors.New("template file has no content, cannot make readseeker")
-	}
-	if rf.templateReader, ok = rf.template.(io.ReadSeeker); ok {
-		return nil
-	}
-	if rf.templateReader != nil {
-		return nil
-	}
-
-	rf.templateBytes, err = io.ReadAll(rf.template)
-	if err != nil {
-		return fmt.Errorf("error reading template file, cannot make readseeker bytes: %w", err)
-	}
-	rf.templateReader = bytes.NewReader(rf.templateBytes)
-	return nil
-}
-
 // func identify extracts a uuid identifier from a filesystem
 func (rf *RmFS) identify(s string) error {
 	if _, err := uuid.Parse(s); err != nil {
@@ -312,6 +251,37 @@ func (rf *RmFS) String() string {
 	return s
 }
 
+// Close closes the files opened by Scan and, for zip files, the zip
+// file itself
+func (rf *RmFS) Close() error {
+	var err error
+	closeFile := func(f io.Closer) {
+		if f == nil {
+			return
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, f := range []fs.File{rf.content, rf.metadata, rf.pagedata, rf.pdf} {
+		closeFile(f)
+	}
+	if rf.template != nil {
+		closeFile(rf.template.file)
+	}
+	for _, tf := range rf.templates {
+		closeFile(tf.file)
+	}
+	for _, rfd := range rf.rmFiles {
+		closeFile(rfd.rm)
+		closeFile(rfd.metadata)
+	}
+	if c, ok := rf.fs.(io.Closer); ok {
+		closeFile(c)
+	}
+	return err
+}
+
 // Scan scans for the files of interest and stores the metadata and rm
 // lines information in the RmFS struct
 func (rf *RmFS) Scan() error {
@@ -369,6 +339,10 @@ func (rf *RmFS) Scan() error {
 			return err
 		}
 		if d.IsDir() {
+			// skip the directories of other bundles
+			if path != "." && !filtered(path) {
+				return fs.SkipDir
+			}
 			return nil
 		}
 		if !filtered(path) {
@@ -407,6 +381,12 @@ func (rf *RmFS) Scan() error {
 					if err != nil {
 						return fmt.Errorf("could not open metadata file: %w", err)
 					}
+				case ".pagedata":
+					rf.pagedataPath = path
+					rf.pagedata, err = rf.fs.Open(path)
+					if err != nil {
+						return fmt.Errorf("could not open pagedata file: %w", err)
+					}
 				case ".pdf":
 					rf.pdfPath = path
 					rf.pdf, err = rf.fs.Open(path)
@@ -75,7 +75,7 @@ func TestRmFSZipFile(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = testReadSeek(rmFS.templateReader)
+	err = testReadSeek(rmFS.template.reader)
 	if err != nil {
 		t.Error(err)
 	}
@@ -107,7 +107,7 @@ func TestRmFSZipFile2(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = testReadSeek(rmFS.templateReader)
+	err = testReadSeek(rmFS.template.reader)
 	if err != nil {
 		t.Error(err)
 	}
@@ -140,7 +140,7 @@ func TestRmFSDirectory(t *testing.T) {
 	if err == nil {
 		t.Error("should error, as there is no pdf")
 	}
-	err = testReadSeek(rmFS.templateReader)
+	err = testReadSeek(rmFS.template.reader)
 	if err != nil {
 		t.Error(err)
 	}
@@ -170,7 +170,7 @@ func TestRmFSDirectory2(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	err = testReadSeek(rmFS.templateReader)
+	err = testReadSeek(rmFS.template.reader)
 	if err != nil {
 		t.Error(err)
 	}
@@ -0,0 +1,216 @@
+/*
+Scan a library of reMarkable documents, such as a copy of a tablet's
+xochitl directory.
+
+Each document and folder in a library has a .metadata file recording
+its visible name and the uuid of its parent folder, from which the
+folder hierarchy of the tablet interface is rebuilt.
+
+MIT licensed, please see LICENCE
+RCL December 2019
+*/
+
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Document describes a document in a library
+type Document struct {
+	UUID         string
+	VisibleName  string
+	Version      int
+	LastModified time.Time
+	Parent       string // the uuid of the parent folder, if any
+	Pinned       bool
+	Folder       string // the path of the parent folder, by visible name
+	Name         string // the file name, made from the visible name
+}
+
+// Path returns the path of the document in the library's folder
+// hierarchy, without an extension
+func (d Document) Path() string {
+	return filepath.Join(d.Folder, d.Name)
+}
+
+// Library describes the documents in a library directory, sorted by
+// path. Deleted and trashed documents are not included, nor are those
+// whose .metadata files could not be read, which are recorded in
+// Unreadable by uuid.
+type Library struct {
+	Dir        string
+	Documents  []Document
+	Unreadable map[string]error
+}
+
+// BundlePath returns the path of the bundle of a document, as used by
+// RMFiler
+func (l *Library) BundlePath(d Document) string {
+	return filepath.Join(l.Dir, d.UUID)
+}
+
+// InputHash returns a sha256 hash of the files from which a document is
+// converted, being its .content, .pdf and .pagedata files, if present,
+// and the .rm and other files in its uuid directory. The .metadata file
+// is not included, as it changes when a document is opened.
+func (l *Library) InputHash(d Document) (string, error) {
+
+	paths := []string{}
+	for _, ext := range []string{".content", ".pdf", ".pagedata"} {
+		paths = append(paths, l.BundlePath(d)+ext)
+	}
+	pageFiles, err := filepath.Glob(filepath.Join(l.BundlePath(d), "*"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(pageFiles)
+	paths = append(paths, pageFiles...)
+
+	h := sha256.New()
+	for _, p := range paths {
+		f, err := os.Open(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if info, err := f.Stat(); err != nil || info.IsDir() {
+			f.Close()
+			continue
+		}
+		fmt.Fprintf(h, "%s\x00", filepath.Base(p))
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("could not hash %s: %w", p, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ScanLibrary scans the .metadata files named by uuid in dir,
+// rebuilding the folder hierarchy of each document from the visible
+// names of its parent folders. Documents in folders which cannot be
+// found are put at the top of the hierarchy.
+//
+// Visible names are made safe for use as file names, and documents with
+// the same name in the same folder are distinguished by the first part
+// of their uuids.
+func ScanLibrary(dir string) (*Library, error) {
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.metadata"))
+	if err != nil {
+		return nil, err
+	}
+
+	l := Library{Dir: dir, Unreadable: map[string]error{}}
+	items := map[string]pdfMetadata{}
+	for _, m := range matches {
+		id := strings.TrimSuffix(filepath.Base(m), ".metadata")
+		if _, err := uuid.Parse(id); err != nil {
+			continue
+		}
+		body, err := os.ReadFile(m)
+		if err != nil {
+			l.Unreadable[id] = fmt.Errorf("could not read metadata file %s: %w", m, err)
+			continue
+		}
+		var md pdfMetadata
+		err = json.Unmarshal(body, &md)
+		if err != nil {
+			l.Unreadable[id] = fmt.Errorf("could not unmarshal %s: %w", m, err)
+			continue
+		}
+		items[id] = md
+	}
+
+	// folderPath returns the path of a folder by visible name, and
+	// reports if the folder is in the trash
+	var folderPath func(id string, depth int) (string, bool)
+	folderPath = func(id string, depth int) (string, bool) {
+		if id == "" {
+			return "", false
+		}
+		if id == TrashParent {
+			return "", true
+		}
+		folder, ok := items[id]
+		if !ok || folder.Type != CollectionType || depth > len(items) {
+			// missing folders, or a loop of folders
+			return "", false
+		}
+		if folder.Deleted {
+			return "", true
+		}
+		parent, trashed := folderPath(folder.Parent, depth+1)
+		return filepath.Join(parent, safeName(folder.VisibleName, id)), trashed
+	}
+
+	for id, md := range items {
+		if md.Type != DocumentType || md.Deleted {
+			continue
+		}
+		folder, trashed := folderPath(md.Parent, 0)
+		if trashed {
+			continue
+		}
+		l.Documents = append(l.Documents, Document{
+			UUID:         id,
+			VisibleName:  md.VisibleName,
+			Version:      md.Version,
+			LastModified: time.Time(md.LastModified),
+			Parent:       md.Parent,
+			Pinned:       md.Pinned,
+			Folder:       folder,
+			Name:         safeName(md.VisibleName, id),
+		})
+	}
+
+	// distinguish documents with the same path
+	paths := map[string]int{}
+	for _, d := range l.Documents {
+		paths[strings.ToLower(d.Path())]++
+	}
+	for i, d := range l.Documents {
+		if paths[strings.ToLower(d.Path())] > 1 {
+			l.Documents[i].Name = fmt.Sprintf("%s (%.8s)", d.Name, d.UUID)
+		}
+	}
+
+	sort.Slice(l.Documents, func(i, j int) bool {
+		pi, pj := l.Documents[i].Path(), l.Documents[j].Path()
+		if pi == pj {
+			return l.Documents[i].UUID < l.Documents[j].UUID
+		}
+		return pi < pj
+	})
+	return &l, nil
+}
+
+// safeName makes a visible name safe to use as a file name, using the
+// uuid if the name is empty
+func safeName(name, id string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r < ' ' {
+			return '-'
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" || name == "." || name == ".." {
+		return id
+	}
+	return name
+}
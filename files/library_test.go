@@ -0,0 +1,148 @@
+package files
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeMetadata writes a library .metadata file
+func writeMetadata(t *testing.T, dir, id, itemType, parent, name string, deleted bool) {
+	t.Helper()
+	body := fmt.Sprintf(
+		`{"deleted": %t, "lastModified": "1577575039277", "parent": %q, "type": %q, "visibleName": %q}`,
+		deleted, parent, itemType, name,
+	)
+	err := os.WriteFile(filepath.Join(dir, id+".metadata"), []byte(body), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanLibrary(t *testing.T) {
+
+	const (
+		work     = "00000000-0000-0000-0000-000000000001"
+		projects = "00000000-0000-0000-0000-000000000002"
+		deleted  = "00000000-0000-0000-0000-000000000003"
+		notes    = "10000000-0000-0000-0000-000000000001"
+		sketch   = "10000000-0000-0000-0000-000000000002"
+		trashed  = "10000000-0000-0000-0000-000000000003"
+		inFolder = "10000000-0000-0000-0000-000000000004"
+		lost     = "10000000-0000-0000-0000-000000000005"
+		notes2   = "20000000-0000-0000-0000-000000000006"
+	)
+
+	dir := t.TempDir()
+	writeMetadata(t, dir, work, CollectionType, "", "Work", false)
+	writeMetadata(t, dir, projects, CollectionType, work, "Projects", false)
+	writeMetadata(t, dir, deleted, CollectionType, "", "Old", true)
+	writeMetadata(t, dir, notes, DocumentType, projects, "Meeting notes", false)
+	writeMetadata(t, dir, notes2, DocumentType, projects, "Meeting notes", false)
+	writeMetadata(t, dir, sketch, DocumentType, "", "Sketch 1/2", false)
+	writeMetadata(t, dir, trashed, DocumentType, "trash", "Rubbish", false)
+	writeMetadata(t, dir, inFolder, DocumentType, deleted, "Old notes", false)
+	writeMetadata(t, dir, lost, DocumentType, "missing-folder", "", false)
+	// files not named by uuid are ignored
+	writeMetadata(t, dir, "not-a-uuid", DocumentType, "", "Other", false)
+	// files which cannot be unmarshalled are recorded and skipped
+	const corrupt = "30000000-0000-0000-0000-000000000007"
+	err := os.WriteFile(filepath.Join(dir, corrupt+".metadata"), []byte(`{"visibleName": `), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib, err := ScanLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		uuid string
+		path string
+	}{
+		{lost, lost},
+		{sketch, "Sketch 1-2"},
+		{notes, filepath.Join("Work", "Projects", "Meeting notes (10000000)")},
+		{notes2, filepath.Join("Work", "Projects", "Meeting notes (20000000)")},
+	}
+	if len(lib.Documents) != len(expected) {
+		t.Fatalf("documents %d not %d: %+v", len(lib.Documents), len(expected), lib.Documents)
+	}
+	for i, e := range expected {
+		d := lib.Documents[i]
+		if d.UUID != e.uuid || d.Path() != e.path {
+			t.Errorf("document %d %s %q not %s %q", i, d.UUID, d.Path(), e.uuid, e.path)
+		}
+	}
+	if len(lib.Unreadable) != 1 || lib.Unreadable[corrupt] == nil {
+		t.Errorf("unreadable documents %v should only include %s", lib.Unreadable, corrupt)
+	}
+
+	d := lib.Documents[2]
+	if d.VisibleName != "Meeting notes" || d.Parent != projects || d.LastModified.Year() != 2019 {
+		t.Errorf("unexpected document details %+v", d)
+	}
+	if lib.BundlePath(d) != filepath.Join(dir, notes) {
+		t.Errorf("unexpected bundle path %s", lib.BundlePath(d))
+	}
+}
+
+func TestScanLibraryTestfiles(t *testing.T) {
+
+	lib, err := ScanLibrary("../testfiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lib.Documents) != 4 {
+		t.Errorf("documents %d not 4", len(lib.Documents))
+	}
+	for _, d := range lib.Documents {
+		_, err := RMFiler(lib.BundlePath(d), "")
+		if err != nil {
+			t.Errorf("%s could not be read: %v", d.Path(), err)
+		}
+	}
+}
+
+func TestLibraryInputHash(t *testing.T) {
+
+	const id = "10000000-0000-0000-0000-000000000001"
+	dir := t.TempDir()
+	writeMetadata(t, dir, id, DocumentType, "", "Notes", false)
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Join(dir, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(id+".content", "{}")
+	write(filepath.Join(id, "page.rm"), "marks")
+
+	lib, err := ScanLibrary(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := func() string {
+		t.Helper()
+		h, err := lib.InputHash(lib.Documents[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	first := hash()
+	writeMetadata(t, dir, id, DocumentType, "", "Renamed", false)
+	if hash() != first {
+		t.Error("metadata changes should not change the hash")
+	}
+	write(filepath.Join(id, "page.rm"), "more marks")
+	if hash() == first {
+		t.Error("changed .rm file should change the hash")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Record the state of the files of a reMarkable bundle, so that changes
+to a bundle can be found by polling.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package files
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stamp records the size and modification time of a file
+type stamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// Snapshot records the size and modification time of each file in a
+// bundle, by path
+type Snapshot map[string]stamp
+
+// NewSnapshot records the files of the bundle at inputpath, given as for
+// RMFiler. For zip files the zip file itself is recorded; otherwise the
+// .content, .metadata, .pagedata and .pdf files of the bundle and the
+// .rm and -metadata.json files in its uuid directory are recorded.
+func NewSnapshot(inputpath string) (Snapshot, error) {
+
+	s := Snapshot{}
+	add := func(path string, info fs.FileInfo) {
+		s[path] = stamp{size: info.Size(), modTime: info.ModTime()}
+	}
+
+	if filepath.Ext(strings.ToLower(inputpath)) == ".zip" {
+		info, err := os.Stat(inputpath)
+		if err != nil {
+			return nil, err
+		}
+		add(inputpath, info)
+		return s, nil
+	}
+
+	inputpath = strings.TrimSuffix(inputpath, filepath.Ext(inputpath))
+	for _, ext := range rmAllExtensions {
+		info, err := os.Stat(inputpath + ext)
+		if err == nil {
+			add(inputpath+ext, info)
+		}
+	}
+	err := filepath.WalkDir(inputpath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == inputpath && os.IsNotExist(err) {
+				return nil // no pages
+			}
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		for _, ext := range rmExtensions {
+			if strings.HasSuffix(path, ext) {
+				info, err := d.Info()
+				if err != nil {
+					return err
+				}
+				add(path, info)
+			}
+		}
+		return nil
+	})
+	return s, err
+}
+
+// Equal reports if two snapshots record the same files, sizes and
+// modification times
+func (s Snapshot) Equal(o Snapshot) bool {
+	if len(s) != len(o) {
+		return false
+	}
+	for path, st := range s {
+		ot, ok := o[path]
+		if !ok || ot.size != st.size || !ot.modTime.Equal(st.modTime) {
+			return false
+		}
+	}
+	return true
+}
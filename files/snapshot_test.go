@@ -0,0 +1,52 @@
+package files
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+
+	tests := []struct {
+		path  string
+		files int
+	}{
+		{"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf", 8},
+		{"../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7", 5},
+		{"../testfiles/version3.zip", 1},
+	}
+	for _, tt := range tests {
+		s, err := NewSnapshot(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != tt.files {
+			t.Errorf("%s files %d not %d: %v", tt.path, len(s), tt.files, s)
+		}
+	}
+
+	// changes to files are found
+	dir := t.TempDir()
+	content := filepath.Join(dir, "10000000-0000-0000-0000-000000000001.content")
+	if err := os.WriteFile(content, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewSnapshot(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _ := NewSnapshot(content)
+	if !first.Equal(second) {
+		t.Error("unchanged snapshots should be equal")
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(content, future, future); err != nil {
+		t.Fatal(err)
+	}
+	third, _ := NewSnapshot(content)
+	if first.Equal(third) {
+		t.Error("changed snapshots should not be equal")
+	}
+}
@@ -0,0 +1,410 @@
+/*
+Backgrounds for the template pages of a bundle.
+
+Notebook pages, and pages inserted into an annotated pdf, are drawn on
+a template: the user provided template or else the embedded A4
+template. A directory of templates may also be given, such as a copy
+of the tablet's /usr/share/remarkable/templates, in which the template
+of each page is found by the name recorded in the .pagedata or
+.content file, such as "P Lines medium", as a pdf, svg, png or jpeg
+file. Pages whose template is not in the directory use the user
+provided or embedded template.
+
+The first page of a template is used unless the pages of multi-page
+templates, such as a left and right page spread or a numbered planner,
+are cycled through or matched to the template pages in turn, or
+mapped to chosen pages of the bundle.
+
+Png and jpeg templates, such as the tablet's raster templates or
+scanned paper, are kept as images to be drawn in place of a pdf page.
+Svg templates are made into single page pdfs 297mm high, the height of
+reMarkable output pdfs, drawn in black from the outlines of their
+paths, lines, rectangles, polylines and polygons; fills, text and
+transforms are not drawn.
+
+MIT licensed, please see LICENCE
+RCL December 2019
+*/
+
+package files
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register image decoders for image templates
+	_ "image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// templateExtensions are the extensions of the files in a template
+// directory, in order of preference
+var templateExtensions = []string{".pdf", ".svg", ".png", ".jpg", ".jpeg"}
+
+// templateHeight is the height in points of the pdfs made from svg
+// templates
+const templateHeight = 297 * 72 / 25.4
+
+// TemplatePageMode sets the page of a multi-page template used for
+// each template page
+type TemplatePageMode int
+
+const (
+	// TemplateFirstPage uses the first page of the template for every
+	// template page
+	TemplateFirstPage TemplatePageMode = iota
+	// TemplateCyclePages uses the pages of the template in turn,
+	// starting again from the first page after the last
+	TemplateCyclePages
+	// TemplateMatchPages uses the n-th page of the template for the n-th
+	// template page, or the last page of shorter templates
+	TemplateMatchPages
+)
+
+// TemplateImage is a png or jpeg image template, drawn in place of a
+// pdf template
+type TemplateImage struct {
+	Path          string
+	Type          string // "PNG" or "JPG", the gofpdf image type
+	Data          []byte
+	Width, Height int // in pixels
+}
+
+// templateFile is a template used as the background of template pages
+type templateFile struct {
+	path      string
+	file      fs.File // the template file, unless made from an svg or image
+	bytes     []byte  // needed to readseek a zip file or a made pdf
+	reader    io.ReadSeeker
+	image     *TemplateImage // set in place of reader for image templates
+	pageCount int            // the number of pages, once counted
+}
+
+// pages returns the number of pages in the template, or 1 if they
+// cannot be counted
+func (tf *templateFile) pages() int {
+	if tf.pageCount > 0 {
+		return tf.pageCount
+	}
+	tf.pageCount = 1
+	if tf.image != nil {
+		return tf.pageCount
+	}
+	dims, err := pdfutil.PageDimensions(tf.reader)
+	if err == nil && len(dims) > 0 {
+		tf.pageCount = len(dims)
+	}
+	_, _ = tf.reader.Seek(0, io.SeekStart)
+	return tf.pageCount
+}
+
+// templatePage returns the 0-indexed page of the template to use for
+// the 0-indexed page pageNo, being the n-th template page of the
+// bundle, counting notebook pages or the pages inserted into an
+// annotated pdf from 0. Pages in TemplatePageMap use the mapped page,
+// or the last page of the template if it is too short; other pages use
+// the page chosen by TemplatePages.
+func (r *RMFileInfo) templatePage(pageNo, n int) int {
+
+	p, mapped := r.TemplatePageMap[pageNo]
+	if !mapped && r.TemplatePages == TemplateFirstPage {
+		return 0
+	}
+
+	count := r.templateFor(pageNo).pages()
+	switch {
+	case mapped:
+	case r.TemplatePages == TemplateCyclePages:
+		p = n % count
+	default:
+		p = n
+	}
+	if p >= count {
+		p = count - 1
+	}
+	if p < 0 {
+		p = 0
+	}
+	return p
+}
+
+// readSeeker makes the template an io.ReadSeeker. Underlying the
+// fs.File, an os.File supports seeking, but files from a zip do not, so
+// detect that and return a bytes.NewReader if necessary
+func (tf *templateFile) readSeeker() error {
+	var (
+		err error
+		ok  bool
+	)
+	if tf.file == nil {
+		return errors.New("template file has no content, cannot make readseeker")
+	}
+	if tf.reader, ok = tf.file.(io.ReadSeeker); ok {
+		return nil
+	}
+	tf.bytes, err = io.ReadAll(tf.file)
+	if err != nil {
+		return fmt.Errorf("error reading template file, cannot make readseeker bytes: %w", err)
+	}
+	tf.reader = bytes.NewReader(tf.bytes)
+	return nil
+}
+
+// openTemplate opens the user provided template at tplPath, which may
+// be a pdf, svg, png or jpeg file, or the embedded A4 template if
+// tplPath is empty
+func openTemplate(tplPath string) (*templateFile, error) {
+
+	if tplPath != "" {
+		return readTemplate(tplPath)
+	}
+
+	var err error
+	tf := &templateFile{path: "embedded A4.pdf"}
+	tf.file, err = embeddedA4File.Open("A4.pdf")
+	if err != nil {
+		return tf, fmt.Errorf("could not open embedded template file %s", err)
+	}
+	err = tf.readSeeker()
+	if err != nil {
+		return tf, fmt.Errorf("could not make template readseeker: %w", err)
+	}
+	return tf, nil
+}
+
+// readTemplate reads a pdf or image template, or makes a pdf template
+// from an svg image, by the extension of path
+func readTemplate(path string) (*templateFile, error) {
+
+	var err error
+	tf := &templateFile{path: path}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png", ".jpg", ".jpeg":
+		tf.image, err = readImage(path)
+		if err != nil {
+			return tf, fmt.Errorf("could not read image template %s: %w", path, err)
+		}
+		return tf, nil
+	case ".svg":
+		tf.bytes, err = svgTemplate(path)
+	default:
+		tf.file, err = os.Open(path)
+		if err != nil {
+			return tf, err
+		}
+		err = tf.readSeeker()
+		if err != nil {
+			return tf, fmt.Errorf("could not make template readseeker: %w", err)
+		}
+		return tf, nil
+	}
+	if err != nil {
+		return tf, fmt.Errorf("could not make template from %s: %w", path, err)
+	}
+	tf.reader = bytes.NewReader(tf.bytes)
+	return tf, nil
+}
+
+// loadTemplateDir loads the templates in dir with the given names,
+// such as "P Lines medium", as recorded for the pages of the bundle.
+// Names without a file in dir are skipped.
+func (rf *RmFS) loadTemplateDir(dir string, names []string) error {
+
+	rf.templates = map[string]*templateFile{}
+	for _, name := range names {
+		if _, ok := rf.templates[name]; ok || name == "" || name != filepath.Base(name) {
+			continue
+		}
+		for _, ext := range templateExtensions {
+			path := filepath.Join(dir, name+ext)
+			if _, err := os.Stat(path); err != nil {
+				continue
+			}
+			tf, err := readTemplate(path)
+			if err != nil {
+				return fmt.Errorf("could not load template %s: %w", name, err)
+			}
+			rf.templates[name] = tf
+			break
+		}
+	}
+	return nil
+}
+
+// templatePDF makes a single page pdf of the given size in points,
+// drawn by draw
+func templatePDF(width, height float64, draw func(pdf *gofpdf.Fpdf)) ([]byte, error) {
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "pt",
+		Size:    gofpdf.SizeType{Wd: width, Ht: height},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+	draw(pdf)
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	return buf.Bytes(), err
+}
+
+// readImage reads a png or jpeg image template
+func readImage(path string) (*TemplateImage, error) {
+
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width == 0 || cfg.Height == 0 {
+		return nil, errors.New("empty image")
+	}
+	img := TemplateImage{
+		Path:   path,
+		Type:   "PNG",
+		Data:   body,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+	if format == "jpeg" {
+		img.Type = "JPG"
+	}
+	return &img, nil
+}
+
+// svgOutline is the outline of an svg element as svg path data
+type svgOutline struct {
+	d     string
+	width float64 // the stroke width
+}
+
+// svgTemplate makes a pdf template of an svg image, filling the page
+func svgTemplate(path string) ([]byte, error) {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	box, outlines, err := svgOutlines(f)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := templateHeight / box[3]
+	paths := make([]gofpdf.SVGBasicType, len(outlines))
+	for i, o := range outlines {
+		var d bytes.Buffer
+		_ = xml.EscapeText(&d, []byte(o.d))
+		paths[i], err = gofpdf.SVGBasicParse([]byte(fmt.Sprintf(
+			`<svg width="%f" height="%f"><path d="%s"/></svg>`, box[2], box[3], d.String(),
+		)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return templatePDF(box[2]*scale, templateHeight, func(pdf *gofpdf.Fpdf) {
+		pdf.SetDrawColor(0, 0, 0)
+		pdf.TransformBegin()
+		pdf.TransformTranslate(-box[0]*scale, -box[1]*scale)
+		for i, o := range outlines {
+			pdf.SetLineWidth(o.width * scale)
+			pdf.SetXY(0, 0)
+			pdf.SVGBasicWrite(&paths[i], scale)
+		}
+		pdf.TransformEnd()
+	})
+}
+
+// svgOutlines reads the viewBox of an svg image, or its width and
+// height, and the outlines of its paths, lines, rectangles, polylines
+// and polygons
+func svgOutlines(r io.Reader) ([4]float64, []svgOutline, error) {
+
+	var box [4]float64
+	outlines := []svgOutline{}
+
+	// number reads a number, ignoring any units
+	number := func(s string) float64 {
+		f, _ := strconv.ParseFloat(strings.TrimRight(strings.TrimSpace(s), "abcdefghijklmnopqrstuvwxyz%"), 64)
+		return f
+	}
+
+	d := xml.NewDecoder(r)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return box, nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attr := map[string]string{}
+		for _, a := range se.Attr {
+			attr[a.Name.Local] = a.Value
+		}
+
+		o := svgOutline{width: 1}
+		if w, ok := attr["stroke-width"]; ok {
+			o.width = number(w)
+		}
+		switch se.Name.Local {
+		case "svg":
+			if box[3] > 0 {
+				continue // nested svg
+			}
+			vb := strings.Fields(strings.ReplaceAll(attr["viewBox"], ",", " "))
+			if len(vb) == 4 {
+				for i, v := range vb {
+					box[i] = number(v)
+				}
+			} else {
+				box[2], box[3] = number(attr["width"]), number(attr["height"])
+			}
+		case "path":
+			o.d = attr["d"]
+		case "line":
+			o.d = fmt.Sprintf("M %f %f L %f %f",
+				number(attr["x1"]), number(attr["y1"]), number(attr["x2"]), number(attr["y2"]))
+		case "rect":
+			x, y := number(attr["x"]), number(attr["y"])
+			w, h := number(attr["width"]), number(attr["height"])
+			o.d = fmt.Sprintf("M %f %f L %f %f L %f %f L %f %f Z", x, y, x+w, y, x+w, y+h, x, y+h)
+		case "polyline", "polygon":
+			points := strings.Fields(strings.ReplaceAll(attr["points"], ",", " "))
+			if len(points) >= 2 {
+				o.d = "M " + strings.Join(points[:2], " ")
+				if len(points) > 2 {
+					o.d += " L " + strings.Join(points[2:], " ")
+				}
+				if se.Name.Local == "polygon" {
+					o.d += " Z"
+				}
+			}
+		}
+		if strings.TrimSpace(o.d) != "" {
+			outlines = append(outlines, o)
+		}
+	}
+
+	if box[2] <= 0 || box[3] <= 0 {
+		return box, nil, errors.New("svg has no viewBox or size")
+	}
+	return box, outlines, nil
+}
@@ -0,0 +1,215 @@
+package files
+
+import (
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+const testSVG = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="1404px" height="1872px" viewBox="0 0 1404 1872">
+  <g stroke="black">
+    <line x1="0" y1="100" x2="1404" y2="100" stroke-width="2"/>
+    <rect x="50" y="150" width="1304" height="400"/>
+    <path d="M 100 700 L 1300 700 Z"/>
+    <polyline points="100,800 700,900 1300,800"/>
+    <text x="10" y="10">Agenda</text>
+  </g>
+</svg>
+`
+
+// writeTemplates writes a pdf, svg, png and jpeg template to dir
+func writeTemplates(t *testing.T, dir string) {
+	t.Helper()
+
+	a4, err := os.ReadFile("../templates/A4.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Meeting.pdf"), a4, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "Agenda.svg"), []byte(testSVG), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeImage := func(name string, encode func(w io.Writer, img image.Image) error, width, height int) {
+		f, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := encode(f, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeImage("Cornell.png", png.Encode, 300, 400)
+	writeImage("Scan.jpg", func(w io.Writer, img image.Image) error {
+		return jpeg.Encode(w, img, nil)
+	}, 400, 300)
+}
+
+func TestSVGOutlines(t *testing.T) {
+
+	box, outlines, err := svgOutlines(strings.NewReader(testSVG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box != [4]float64{0, 0, 1404, 1872} {
+		t.Errorf("box %v", box)
+	}
+	if len(outlines) != 4 {
+		t.Fatalf("outlines %d not 4: %v", len(outlines), outlines)
+	}
+	if outlines[0].width != 2 || outlines[1].width != 1 {
+		t.Errorf("unexpected widths %v", outlines)
+	}
+
+	_, _, err = svgOutlines(strings.NewReader(`<svg><path d="M 0 0 L 1 1"/></svg>`))
+	if err == nil {
+		t.Error("expected an error for an svg without a size")
+	}
+}
+
+func TestLoadTemplateDir(t *testing.T) {
+
+	dir := t.TempDir()
+	writeTemplates(t, dir)
+
+	rmf := RMFileInfo{RmFS: &RmFS{}}
+	var err error
+	rmf.template, err = openTemplate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"Meeting", "Agenda", "Cornell", "Scan", "P Lines small", "../Meeting", "Agenda"}
+	for _, n := range names {
+		rmf.Pages = append(rmf.Pages, RMPage{Template: n})
+	}
+	rmf.PageCount = len(rmf.Pages)
+	if err := rmf.loadTemplateDir(dir, names); err != nil {
+		t.Fatal(err)
+	}
+
+	// image templates are sized in pixels
+	expected := []struct {
+		file          string
+		imageType     string
+		width, height float64
+	}{
+		{"Meeting.pdf", "", 595, 842},
+		{"Agenda.svg", "", 631, 842},
+		{"Cornell.png", "PNG", 300, 400},
+		{"Scan.jpg", "JPG", 400, 300},
+		{"", "", 595, 842},
+		{"", "", 595, 842},
+		{"Agenda.svg", "", 631, 842},
+	}
+	for i, e := range expected {
+		pageNo, _, _, isTemplate, reader := rmf.PageIterate()
+		if pageNo != i || !isTemplate {
+			t.Fatalf("page %d template %t", pageNo, isTemplate)
+		}
+		path, found := rmf.TemplatePath(i)
+		if found != (e.file != "") || (found && path != filepath.Join(dir, e.file)) {
+			t.Errorf("page %d template %s found %t", i+1, path, found)
+		}
+		if img, ok := rmf.TemplateImage(i); ok || e.imageType != "" {
+			if img == nil || img.Type != e.imageType || float64(img.Width) != e.width || float64(img.Height) != e.height {
+				t.Errorf("page %d image %+v", i+1, img)
+			}
+			continue
+		}
+		dims, err := pdfutil.PageDimensions(*reader)
+		if err != nil {
+			t.Fatalf("page %d: %v", i+1, err)
+		}
+		if math.Round(dims[0].Width) != e.width || math.Round(dims[0].Height) != e.height {
+			t.Errorf("page %d size %f x %f", i+1, dims[0].Width, dims[0].Height)
+		}
+	}
+
+	// templates which cannot be read are reported
+	if err := os.WriteFile(filepath.Join(dir, "Broken.png"), []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := rmf.loadTemplateDir(dir, []string{"Broken"}); err == nil {
+		t.Error("expected an error for a broken template")
+	}
+}
+
+// writeMultiPageTemplate writes a template of pageCount pages to path
+func writeMultiPageTemplate(t *testing.T, path string, pageCount int) {
+	t.Helper()
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	for i := 0; i < pageCount; i++ {
+		pdf.AddPage()
+	}
+	if err := pdf.OutputFileAndClose(path); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTemplatePage(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "planner.pdf")
+	writeMultiPageTemplate(t, path, 3)
+	tf, err := openTemplate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a notebook of 5 pages
+	notebook := RMFileInfo{RmFS: &RmFS{template: tf}, PageCount: 5}
+	// an annotated pdf with pages 2, 4 and 5 inserted
+	annotated := RMFileInfo{
+		RmFS:               &RmFS{template: tf, pdfPath: "annotated.pdf"},
+		PageCount:          5,
+		OriginalPageCount:  2,
+		RedirectionPageMap: []int{0, -1, 1, -1, -1},
+	}
+	annotated.registerInsertedPages()
+
+	tests := []struct {
+		name     string
+		mode     TemplatePageMode
+		pageMap  map[int]int
+		notebook []int
+		inserted []int
+	}{
+		{"first", TemplateFirstPage, nil, []int{0, 0, 0, 0, 0}, []int{0, 0, 0}},
+		{"cycle", TemplateCyclePages, nil, []int{0, 1, 2, 0, 1}, []int{0, 1, 2}},
+		{"match", TemplateMatchPages, nil, []int{0, 1, 2, 2, 2}, []int{0, 1, 2}},
+		{"mapped", TemplateCyclePages, map[int]int{0: 2, 3: 9}, []int{2, 1, 2, 2, 1}, []int{0, 2, 2}},
+	}
+	for _, tt := range tests {
+		for _, r := range []*RMFileInfo{&notebook, &annotated} {
+			r.thisPageNo = 0
+			r.TemplatePages = tt.mode
+			r.TemplatePageMap = tt.pageMap
+			got := []int{}
+			for i := 0; i < r.PageCount; i++ {
+				_, pdfPageNo, _, isTemplate, _ := r.PageIterate()
+				if isTemplate {
+					got = append(got, pdfPageNo)
+				}
+			}
+			expected := tt.notebook
+			if r == &annotated {
+				expected = tt.inserted
+			}
+			if !cmp.Equal(got, expected) {
+				t.Errorf("%s %s template pages %v not %v", tt.name, r.pdfPath, got, expected)
+			}
+		}
+	}
+}
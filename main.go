@@ -8,10 +8,16 @@ RCL 28 March 2021
 package main
 
 import (
+	"context"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
+	"github.com/rorycl/rm2pdf/pdfutil"
 	rmpdf "github.com/rorycl/rm2pdf/rmpdf"
 )
 
@@ -24,8 +30,8 @@ rm2pdf version %s
 Render layered PDF files from reMarkable tablet file bundles with
 customisable pen widths and colours.
 
-rm2pdf does not currently support remarkable software version 3 .rm
-'lines' files.
+rm2pdf supports version 3, 5 and 6 .rm 'lines' files, the latter made
+by remarkable software version 3.
 
 Note that PDF files from sources such as Microsoft Word do not always
 work well. It can help to rewrite them using the pdftk tool, e.g. by
@@ -37,20 +43,128 @@ doing
 
 For notebooks without a backing pdf file a template can be specified, of
 which only the first page is used. If no template is provided the
-embedded A4 template is used.
-
-rm2pdf [-v] [-s pens.yaml] [-t A4red.pdf] [-c red]  `
+embedded A4 template is used, and notebook pages which used the stock
+lined, grid or dotted templates on the tablet have those templates
+drawn on them.
+
+Use the --template-dir option to give a directory of templates, such as
+a copy of the tablet's /usr/share/remarkable/templates or your own
+templates, in which the template used for each page on the tablet is
+found by name, such as 'P Lines medium', as a pdf, svg, png or jpeg
+file. PNG and JPEG templates, such as the tablet's png templates or a
+scanned page, are fitted to the tablet display in the Background layer.
+Pages whose template is not in the directory fall back to the -t
+template, and then to the embedded A4 template.
+
+The first page of a template is used for every notebook page and
+inserted page. For multi-page templates, such as a left and right page
+spread or a numbered planner, use '--template-pages cycle' to use the
+template pages in turn, or '--template-pages match' to use the n-th
+template page for the n-th notebook or inserted page. The
+--template-page-map option chooses the template page for given pages,
+such as '--template-page-map 2:3,5:1'.
+
+Use '-' as the OutputFile to write the pdf to stdout. An existing
+OutputFile is overwritten unless the -n/--no-clobber option is used.
+
+Use the -g/--svg option to write a layered SVG image of the marks on
+each page, named OutputFile-01.svg, OutputFile-02.svg and so on, in
+place of a pdf.
+
+Use the -r/--raster option to write a PNG image of each page, or a JPEG
+image if the OutputFile ends in '.jpg' or '.jpeg', named as for svg
+images. The resolution is set with --dpi and a PNG or JPEG image, such
+as a reMarkable png template, can be drawn behind the marks with
+--background.
+
+Use the -p/--pages option to convert only some pages, such as
+'-p 1-3,7,10-', the --annotated option to convert only pages with marks
+and the --inserted option to convert only pages inserted into the pdf on
+the tablet. The pages of the pdf are labelled with their page numbers in
+the original document.
+
+Use the --annotations option to write the marks as pdf ink annotations,
+which can be listed, hidden or removed in the comment panes of pdf
+readers, in place of drawing them in layers. If every page of an
+annotated pdf is converted, the annotations are added to the original
+pdf as an incremental update, leaving its content unchanged.
+
+Use the -l/--library option to convert every document in a library of
+reMarkable files, such as a copy of a tablet's xochitl directory, given
+as the InputPath. Each document is written as a pdf to the OutputFile
+directory in the folder hierarchy shown on the tablet, such as
+OutputFile/Work/Projects/Meeting notes.pdf. With --cache, only documents
+changed since the last run are converted, and the pdfs of removed or
+trashed documents are deleted.
+
+Use the -w/--watch option to keep converting InputPath to OutputFile each
+time its files change, such as while annotating a pdf on a tablet
+mounted over sshfs. The files are checked every --interval, and the
+pdf rebuilt once changes have stopped for --debounce. Use Ctrl-C to
+stop watching.
+
+Use the -i/--info option to show the metadata and the size, rotation
+and label of each page of the InputPath pdf, with no OutputFile.
+
+rm2pdf [-v] [-n] [-s pens.yaml] [-t A4red.pdf] [-c red]  `
 
 // Options are flag options
 type Options struct {
-	Verbose  bool                `short:"v" long:"verbose"  description:"show verbose output\nthis presently does not do much"`
-	Settings string              `short:"s" long:"settings" description:"path to customised pen settings file\nsee config_example.yaml for an example"`
-	Template string              `short:"t" long:"template" description:"path to a single page A4 template to use when no UUID.pdf exists\nuseful for processing sketches without a backing PDF"`
-	Colours  []rmpdf.LocalColour `short:"c" long:"colours"  description:"colour by layer\nuse several -c flags in series to select different colours\ne.g. -c red -c blue -c green for layers 1, 2 and 3.\nSee golang.org/x/image/colornames for the colours that can be used"`
-	Args     struct {
-		InputPath  string `description:"input path and uuid, optionally ending in '.pdf'"`
-		OutputFile string `description:"output pdf file to write to"`
-	} `positional-args:"yes" required:"yes"`
+	Verbose    bool                `short:"v" long:"verbose"  description:"show verbose output\nthis presently does not do much"`
+	Settings   string              `short:"s" long:"settings" description:"path to customised pen settings file\nsee config_example.yaml for an example"`
+	Template   string              `short:"t" long:"template" description:"path to a single page template to use when no UUID.pdf exists\nuseful for processing sketches without a backing PDF"`
+	Templates  string              `long:"template-dir" description:"path to a directory of templates, such as a copy of /usr/share/remarkable/templates\nthe template used for each page on the tablet is found by name as a pdf, svg, png or jpeg file"`
+	TplPages   string              `long:"template-pages" default:"first" choice:"first" choice:"cycle" choice:"match" description:"pages of a multi-page template to use: the first page for every page,\ncycle through the pages, or match the n-th page to the n-th template page"`
+	TplPageMap string              `long:"template-page-map" description:"template pages for chosen pages, such as 2:3,5:1 to use template page 3\nfor page 2 and template page 1 for page 5"`
+	Colours    []rmpdf.LocalColour `short:"c" long:"colours"  description:"colour by layer\nuse several -c flags in series to select different colours\ne.g. -c red -c blue -c green for layers 1, 2 and 3.\nSee golang.org/x/image/colornames for the colours that can be used"`
+	NoClobber  bool                `short:"n" long:"no-clobber" description:"do not overwrite an existing output file"`
+	SVG        bool                `short:"g" long:"svg" description:"write an svg image of each page in place of a pdf"`
+	Raster     bool                `short:"r" long:"raster" description:"write a png or jpeg image of each page in place of a pdf"`
+	DPI        float64             `long:"dpi" default:"100" description:"resolution of raster images"`
+	Background string              `long:"background" description:"path to a png or jpeg image to draw behind the marks in raster images"`
+	Pages      string              `short:"p" long:"pages" description:"pages to convert, such as 1-3,7,10-"`
+	Annotated  bool                `long:"annotated" description:"only convert pages with marks"`
+	Inserted   bool                `long:"inserted" description:"only convert pages inserted on the tablet"`
+	Annots     bool                `long:"annotations" description:"write the marks as pdf ink annotations in place of drawing them in layers"`
+	Library    bool                `short:"l" long:"library" description:"convert each document in the InputPath library directory to a pdf in the OutputFile directory"`
+	Cache      string              `long:"cache" description:"with -l, path to a cache file recording converted documents\nso that only changed documents are converted again"`
+	Watch      bool                `short:"w" long:"watch" description:"convert the input again each time it changes, until interrupted"`
+	Interval   time.Duration       `long:"interval" default:"1s" description:"with -w, how often to check the input for changes"`
+	Debounce   time.Duration       `long:"debounce" default:"2s" description:"with -w, how long changes must stop before converting"`
+	Info       bool                `short:"i" long:"info" description:"show information about the input pdf file and exit"`
+	Args       struct {
+		InputPath  string `description:"input path and uuid, optionally ending in '.pdf'" required:"yes"`
+		OutputFile string `description:"output pdf file to write to, or '-' for stdout"`
+	} `positional-args:"yes"`
+}
+
+// info prints information about the pdf at inputPath, which may omit
+// the '.pdf' suffix
+func info(inputPath string) error {
+	if !strings.HasSuffix(inputPath, ".pdf") {
+		inputPath += ".pdf"
+	}
+	p, err := pdfutil.NewPDFFile(inputPath)
+	if err != nil {
+		return err
+	}
+	fmt.Print(p)
+	return nil
+}
+
+// watch converts inputPath to outputFile each time it changes, until
+// interrupted, reporting each conversion
+func watch(converter *rmpdf.Converter, inputPath, outputFile string, interval, debounce time.Duration) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	fmt.Printf("watching %s, press Ctrl-C to stop\n", inputPath)
+	return converter.Watch(ctx, inputPath, outputFile, interval, debounce, func(e rmpdf.WatchEvent) {
+		if e.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s rebuild failed: %v\n", e.Time.Format("15:04:05"), e.Err)
+			return
+		}
+		fmt.Printf("%s rebuilt %s in %s\n", e.Time.Format("15:04:05"), outputFile, e.Duration.Round(time.Millisecond))
+	})
 }
 
 func main() {
@@ -63,9 +177,76 @@ func main() {
 		os.Exit(1)
 	}
 
-	err := rmpdf.RM2PDF(options.Args.InputPath, options.Args.OutputFile, options.Template, options.Settings, options.Verbose, options.Colours)
+	if options.Info {
+		if err := info(options.Args.InputPath); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if options.Args.OutputFile == "" {
+		fmt.Fprintln(os.Stderr, "the required argument `OutputFile` was not provided")
+		os.Exit(1)
+	}
+
+	converterOptions := []rmpdf.Option{
+		rmpdf.WithTemplate(options.Template),
+		rmpdf.WithTemplateDir(options.Templates),
+		rmpdf.WithTemplatePages(options.TplPages),
+		rmpdf.WithLayerColours(options.Colours),
+		rmpdf.WithVerbose(options.Verbose),
+		rmpdf.WithNoClobber(options.NoClobber),
+		rmpdf.WithDPI(options.DPI),
+		rmpdf.WithAnnotatedOnly(options.Annotated),
+		rmpdf.WithInsertedOnly(options.Inserted),
+		rmpdf.WithAnnotations(options.Annots),
+	}
+	if options.Pages != "" {
+		converterOptions = append(converterOptions, rmpdf.WithPageRanges(options.Pages))
+	}
+	if options.TplPageMap != "" {
+		converterOptions = append(converterOptions, rmpdf.WithTemplatePageMap(options.TplPageMap))
+	}
+	if options.Background != "" {
+		converterOptions = append(converterOptions, rmpdf.WithRasterBackground(options.Background))
+	}
+	if options.Cache != "" {
+		converterOptions = append(converterOptions, rmpdf.WithLibraryCache(options.Cache))
+	}
+	if options.Settings != "" {
+		converterOptions = append(converterOptions, rmpdf.WithPenConfigFile(options.Settings))
+	}
+	// keep stdout for the pdf
+	toStdout := options.Args.OutputFile == "-"
+	if toStdout {
+		converterOptions = append(converterOptions, rmpdf.WithLogger(log.New(os.Stderr, "", 0)))
+	}
+
+	converter, err := rmpdf.NewConverter(converterOptions...)
+	if err == nil {
+		switch {
+		case (options.SVG || options.Raster) && toStdout:
+			err = fmt.Errorf("images cannot be written to stdout")
+		case options.Watch && toStdout:
+			err = fmt.Errorf("a watched input cannot be written to stdout")
+		case options.Watch:
+			err = watch(converter, options.Args.InputPath, options.Args.OutputFile, options.Interval, options.Debounce)
+		case options.Library && toStdout:
+			err = fmt.Errorf("a library cannot be written to stdout")
+		case options.Library:
+			_, err = converter.ConvertLibrary(options.Args.InputPath, options.Args.OutputFile)
+		case options.SVG:
+			_, err = converter.ConvertSVG(options.Args.InputPath, options.Args.OutputFile)
+		case options.Raster:
+			_, err = converter.ConvertImages(options.Args.InputPath, options.Args.OutputFile)
+		case toStdout:
+			err = converter.ConvertTo(options.Args.InputPath, os.Stdout)
+		default:
+			err = converter.Convert(options.Args.InputPath, options.Args.OutputFile)
+		}
+	}
 	if err != nil {
-		fmt.Printf("error: %v\n", err)
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
 		os.Exit(1)
 	}
 }
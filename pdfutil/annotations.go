@@ -0,0 +1,353 @@
+package pdfutil
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	pdfcolor "github.com/pdfcpu/pdfcpu/pkg/pdfcpu/color"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Point is a point on a pdf page in points from the top left corner of
+// the page as it is displayed
+type Point struct {
+	X, Y float64
+}
+
+// InkPath is a path of an ink annotation. Paths drawn with a pen that
+// varies in width have a filled Outline, which is drawn in place of the
+// line through Points. Erased areas are cut out of the path as it is
+// drawn, but the path itself is kept whole.
+type InkPath struct {
+	Points  []Point
+	Outline []Point
+	Erased  [][]Point
+}
+
+// Ink is an ink annotation of one or more paths of the same width,
+// colour and opacity. Title is shown as the author of the annotation
+// and Subject as its subject. Hidden annotations are not shown or
+// printed.
+type Ink struct {
+	Paths   []InkPath
+	Width   float64
+	Colour  color.RGBA
+	Opacity float64
+	Title   string
+	Subject string
+	Hidden  bool
+}
+
+// inkTolerance is the furthest in points that the paths of an ink
+// annotation may stray from the points given. The paths are drawn in
+// full in the appearance stream, so the many points recorded by the
+// tablet are simplified in the paths to keep annotations small.
+const inkTolerance = 0.25
+
+// simplify returns the points of a line through points, less those
+// within tolerance of the line through the points kept, using the
+// Ramer-Douglas-Peucker algorithm
+func simplify(points []Point, tolerance float64) []Point {
+
+	if len(points) < 3 {
+		return points
+	}
+	first, last := points[0], points[len(points)-1]
+	dx, dy := last.X-first.X, last.Y-first.Y
+	length := math.Hypot(dx, dy)
+
+	furthest, distance := 0, 0.0
+	for i := 1; i < len(points)-1; i++ {
+		p := points[i]
+		d := math.Hypot(p.X-first.X, p.Y-first.Y)
+		if length > 0 {
+			d = math.Abs(dy*(p.X-first.X)-dx*(p.Y-first.Y)) / length
+		}
+		if d > distance {
+			furthest, distance = i, d
+		}
+	}
+	if distance <= tolerance {
+		return []Point{first, last}
+	}
+	head := simplify(points[:furthest+1], tolerance)
+	return append(head[:len(head)-1:len(head)-1], simplify(points[furthest:], tolerance)...)
+}
+
+// inkAnnotation renders a prepared ink annotation dictionary for
+// pdfcpu
+type inkAnnotation struct {
+	model.Annotation
+	dict types.Dict
+}
+
+// RenderDict returns the annotation dictionary for the page
+func (a inkAnnotation) RenderDict(_ *model.XRefTable, pageIndRef types.IndirectRef) (types.Dict, error) {
+	a.dict["P"] = pageIndRef
+	return a.dict, nil
+}
+
+// userSpace converts points on a page as it is displayed to the pdf
+// user space of the page, allowing for the page CropBox and rotation
+type userSpace struct {
+	crop     *types.Rectangle
+	rotation int
+}
+
+// point converts a displayed point to user space
+func (u userSpace) point(p Point) (float64, float64) {
+	w, h := u.crop.Width(), u.crop.Height()
+	var dx, dy float64
+	switch (u.rotation%360 + 360) % 360 {
+	case 90:
+		dx, dy = p.Y, p.X
+	case 180:
+		dx, dy = w-p.X, p.Y
+	case 270:
+		dx, dy = w-p.Y, h-p.X
+	default:
+		dx, dy = p.X, h-p.Y
+	}
+	return u.crop.LL.X + dx, u.crop.LL.Y + dy
+}
+
+// path writes the operators moving to and making lines through points
+// to s, closing the path if close is set
+func (u userSpace) path(s *strings.Builder, points []Point, close bool) {
+	for i, p := range points {
+		x, y := u.point(p)
+		op := "l"
+		if i == 0 {
+			op = "m"
+		}
+		fmt.Fprintf(s, "%.3f %.3f %s ", x, y, op)
+	}
+	if len(points) == 1 {
+		x, y := u.point(points[0])
+		fmt.Fprintf(s, "%.3f %.3f l ", x, y)
+	}
+	if close {
+		s.WriteString("h ")
+	}
+}
+
+// rect returns the user space rectangle bounding points, expanded by
+// margin
+func (u userSpace) rect(points []Point, margin float64) *types.Rectangle {
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range points {
+		x, y := u.point(p)
+		minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+		minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+	}
+	return types.NewRectangle(minX-margin, minY-margin, maxX+margin, maxY+margin)
+}
+
+// appearance returns the content of the appearance stream of ink,
+// drawing each path in user space, less its erased areas
+func (u userSpace) appearance(ink Ink, bbox *types.Rectangle) string {
+
+	r, g, b := float64(ink.Colour.R)/255, float64(ink.Colour.G)/255, float64(ink.Colour.B)/255
+	var s strings.Builder
+	fmt.Fprintf(&s, "%.3f %.3f %.3f RG %.3f %.3f %.3f rg %.3f w 1 J 1 j\n", r, g, b, r, g, b, ink.Width)
+	for _, p := range ink.Paths {
+		s.WriteString("q ")
+		// clip to the annotation less each erased area, using the
+		// even-odd rule; successive clipping paths intersect
+		for _, area := range p.Erased {
+			fmt.Fprintf(&s, "%.3f %.3f %.3f %.3f re ", bbox.LL.X, bbox.LL.Y, bbox.Width(), bbox.Height())
+			u.path(&s, area, true)
+			s.WriteString("W* n ")
+		}
+		if len(p.Outline) > 0 {
+			u.path(&s, p.Outline, true)
+			s.WriteString("f ")
+		} else {
+			u.path(&s, p.Points, false)
+			s.WriteString("S ")
+		}
+		s.WriteString("Q\n")
+	}
+	return s.String()
+}
+
+// WriteInkAnnotations copies the pdf read from rs to w, adding the ink
+// annotations for each page, keyed by 1-indexed page number, as an
+// incremental update. The original pdf is copied unchanged, followed by
+// the annotations, each with an appearance stream drawing its paths,
+// and the updated pages. If there are no annotations the pdf is copied
+// unchanged.
+func WriteInkAnnotations(rs io.ReadSeeker, w io.Writer, inks map[int][]Ink) error {
+
+	if len(inks) == 0 {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := io.Copy(w, rs)
+		return err
+	}
+
+	ctx, err := pdfapi.ReadContext(rs, nil)
+	if err != nil {
+		return err
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		return err
+	}
+	if ctx.Encrypt != nil {
+		return errors.New("cannot annotate an encrypted pdf")
+	}
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return fmt.Errorf("pagesize error: %s", err)
+	}
+
+	// write the update in the form of the original cross-reference
+	ctx.Write.Increment = true
+	ctx.Write.Offset = ctx.Read.FileSize
+	ctx.WriteXRefStream = ctx.Read.UsingXRefStreams
+	ctx.WriteObjectStream = false
+
+	// transparency and annotation names need pdf 1.4, which is set in
+	// the document catalog as the header cannot be updated
+	if ctx.Version() < model.V14 {
+		root, err := ctx.Catalog()
+		if err != nil {
+			return err
+		}
+		root["Version"] = types.Name(model.V14.String())
+		ctx.Write.IncrementWithObjNr(ctx.Root.ObjectNumber.Value())
+	}
+
+	pageNos := []int{}
+	for pageNo := range inks {
+		if pageNo < 1 || pageNo > ctx.PageCount {
+			return fmt.Errorf("no page %d in pdf of %d pages", pageNo, ctx.PageCount)
+		}
+		pageNos = append(pageNos, pageNo)
+	}
+	sort.Ints(pageNos)
+
+	for _, pageNo := range pageNos {
+		pageIndRef, err := ctx.PageDictIndRef(pageNo)
+		if err != nil {
+			return err
+		}
+		pageDict, err := ctx.DereferenceDict(*pageIndRef)
+		if err != nil {
+			return err
+		}
+		pb := boundaries[pageNo-1]
+		u := userSpace{crop: pb.CropBox(), rotation: pb.Rot}
+
+		for i, ink := range inks[pageNo] {
+			ann, err := u.inkAnnotation(ctx, ink, fmt.Sprintf("rm2pdf-%d-%d", pageNo, i+1))
+			if err != nil {
+				return err
+			}
+			if _, err := pdfcpu.AddAnnotation(ctx, pageIndRef, pageDict, pageNo, ann, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err = rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, rs); err != nil {
+		return err
+	}
+	return pdfapi.WriteIncrement(ctx, w)
+}
+
+// inkAnnotation makes the annotation for ink named id, adding its
+// appearance stream to the update
+func (u userSpace) inkAnnotation(ctx *model.Context, ink Ink, id string) (inkAnnotation, error) {
+
+	points := []Point{}
+	inkList := types.Array{}
+	for _, p := range ink.Paths {
+		points = append(points, p.Points...)
+		points = append(points, p.Outline...)
+		coords := []float64{}
+		for _, pt := range simplify(p.Points, inkTolerance) {
+			x, y := u.point(pt)
+			coords = append(coords, x, y)
+		}
+		inkList = append(inkList, types.NewNumberArray(coords...))
+	}
+	rect := u.rect(points, ink.Width/2+1)
+
+	sd, err := ctx.NewStreamDictForBuf([]byte(u.appearance(ink, rect)))
+	if err != nil {
+		return inkAnnotation{}, err
+	}
+	sd.InsertName("Type", "XObject")
+	sd.InsertName("Subtype", "Form")
+	sd.InsertInt("FormType", 1)
+	sd.Insert("BBox", rect.Array())
+	sd.Insert("Matrix", types.NewIntegerArray(1, 0, 0, 1, 0, 0))
+	if err = sd.Encode(); err != nil {
+		return inkAnnotation{}, err
+	}
+	apIndRef, err := ctx.IndRefForNewObject(*sd)
+	if err != nil {
+		return inkAnnotation{}, err
+	}
+	ctx.Write.IncrementWithObjNr(apIndRef.ObjectNumber.Value())
+
+	flags := model.AnnPrint
+	if ink.Hidden {
+		flags = model.AnnHidden
+	}
+	colour := pdfcolor.SimpleColor{
+		R: float32(ink.Colour.R) / 255, G: float32(ink.Colour.G) / 255, B: float32(ink.Colour.B) / 255,
+	}
+	dict := types.Dict{
+		"Type":    types.Name("Annot"),
+		"Subtype": types.Name("Ink"),
+		"Rect":    rect.Array(),
+		"F":       types.Integer(flags),
+		"NM":      textString(id),
+		"C":       colour.Array(),
+		"CA":      types.Float(ink.Opacity),
+		"BS":      types.Dict{"W": types.Float(ink.Width), "S": types.Name("S")},
+		"InkList": inkList,
+		"AP":      types.Dict{"N": *apIndRef},
+	}
+	if ink.Title != "" {
+		dict["T"] = textString(ink.Title)
+	}
+	if ink.Subject != "" {
+		dict["Subj"] = textString(ink.Subject)
+	}
+
+	return inkAnnotation{
+		Annotation: model.NewAnnotation(model.AnnInk, *rect, "", nil, id, flags, &colour),
+		dict:       dict,
+	}, nil
+}
+
+// textString returns s as a pdf text string, escaped and encoded as
+// UTF-16 if it is not ASCII
+func textString(s string) types.StringLiteral {
+	for _, r := range s {
+		if r > 127 {
+			s = types.EncodeUTF16String(s)
+			break
+		}
+	}
+	e, err := types.Escape(s)
+	if err != nil {
+		return types.StringLiteral("")
+	}
+	return types.StringLiteral(*e)
+}
@@ -0,0 +1,314 @@
+package pdfutil
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Box is a pdf page boundary in points
+type Box struct {
+	LLX, LLY, URX, URY float64
+}
+
+// Width returns the width of a Box
+func (b Box) Width() float64 {
+	return b.URX - b.LLX
+}
+
+// Height returns the height of a Box
+func (b Box) Height() float64 {
+	return b.URY - b.LLY
+}
+
+// String returns a string representation of a Box
+func (b Box) String() string {
+	return fmt.Sprintf("[%0.2f %0.2f %0.2f %0.2f]", b.LLX, b.LLY, b.URX, b.URY)
+}
+
+func newBox(r *types.Rectangle) Box {
+	return Box{r.LL.X, r.LL.Y, r.UR.X, r.UR.Y}
+}
+
+// PageInfo describes a pdf page. Width, Height and Orientation are
+// those of the page as displayed, being the CropBox (which defaults to
+// the MediaBox) turned by the page rotation.
+type PageInfo struct {
+	Number      int // 1-indexed
+	Label       string
+	MediaBox    Box
+	CropBox     Box
+	Rotation    int
+	Width       float64
+	Height      float64
+	Orientation Orientation
+}
+
+// String returns a string representation of a PageInfo
+func (p PageInfo) String() string {
+	label := ""
+	if p.Label != "" {
+		label = fmt.Sprintf(" (%s)", p.Label)
+	}
+	return fmt.Sprintf(
+		"page %d%s : %0.2f (w) %0.2f (h) %s, rotation %d, mediabox %s, cropbox %s",
+		p.Number, label, p.Width, p.Height, p.Orientation, p.Rotation, p.MediaBox, p.CropBox,
+	)
+}
+
+// orientationOf returns the orientation of a page of the given size
+func orientationOf(width, height float64) Orientation {
+	if width > height {
+		return landscape
+	}
+	return portrait
+}
+
+// readInfo reads the metadata and page information of a pdf into p
+func (p *PDFFile) readInfo(rs io.ReadSeeker) error {
+
+	ctx, err := pdfapi.ReadContext(rs, nil)
+	if err != nil {
+		return fmt.Errorf("read error: %s", err)
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		return fmt.Errorf("pagecount error: %s", err)
+	}
+	p.Pages = ctx.PageCount
+
+	p.Title, p.Author, p.Producer = "", "", ""
+	if ctx.Info != nil {
+		info, err := ctx.DereferenceDict(*ctx.Info)
+		if err != nil {
+			return fmt.Errorf("info error: %s", err)
+		}
+		p.Title = infoText(ctx, info, "Title")
+		p.Author = infoText(ctx, info, "Author")
+		p.Producer = infoText(ctx, info, "Producer")
+	}
+
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return fmt.Errorf("pagesize error: %s", err)
+	}
+	labels, err := pageLabels(ctx)
+	if err != nil {
+		return fmt.Errorf("page label error: %s", err)
+	}
+
+	p.PageInfo = make([]PageInfo, len(boundaries))
+	for i, pb := range boundaries {
+		pi := PageInfo{
+			Number:   i + 1,
+			MediaBox: newBox(pb.MediaBox()),
+			CropBox:  newBox(pb.CropBox()),
+			Rotation: pb.Rot,
+		}
+		pi.Width, pi.Height = pi.CropBox.Width(), pi.CropBox.Height()
+		if pi.Rotation%180 != 0 {
+			pi.Width, pi.Height = pi.Height, pi.Width
+		}
+		pi.Orientation = orientationOf(pi.Width, pi.Height)
+		if labels != nil {
+			pi.Label = labels.label(i)
+		}
+		p.PageInfo[i] = pi
+	}
+	return nil
+}
+
+// infoText returns the text of a document information dictionary
+// entry, or an empty string if it is missing or unreadable
+func infoText(ctx *model.Context, info types.Dict, key string) string {
+	o, ok := info[key]
+	if !ok {
+		return ""
+	}
+	s, err := ctx.DereferenceText(o)
+	if err != nil {
+		return ""
+	}
+	return s
+}
+
+// labelRange is a page label range starting at a 0-indexed page
+type labelRange struct {
+	start  int
+	style  string
+	prefix string
+	first  int
+}
+
+// labelRanges are the page label ranges of a document, in page order
+type labelRanges []labelRange
+
+// pageLabels reads the page label number tree from the document
+// catalog, returning nil if the document has no page labels
+func pageLabels(ctx *model.Context) (labelRanges, error) {
+
+	root, err := ctx.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	o, ok := root["PageLabels"]
+	if !ok {
+		return nil, nil
+	}
+	var ranges labelRanges
+	err = ranges.collect(ctx, o)
+	if err != nil {
+		return nil, err
+	}
+	return ranges, nil
+}
+
+// collect adds the ranges in a number tree node and its kids
+func (lr *labelRanges) collect(ctx *model.Context, o types.Object) error {
+
+	node, err := ctx.DereferenceDict(o)
+	if err != nil {
+		return err
+	}
+
+	if kids, ok := node["Kids"]; ok {
+		a, err := ctx.DereferenceArray(kids)
+		if err != nil {
+			return err
+		}
+		for _, kid := range a {
+			if err = lr.collect(ctx, kid); err != nil {
+				return err
+			}
+		}
+	}
+
+	nums, ok := node["Nums"]
+	if !ok {
+		return nil
+	}
+	a, err := ctx.DereferenceArray(nums)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+1 < len(a); i += 2 {
+		start, err := ctx.DereferenceInteger(a[i])
+		if err != nil || start == nil {
+			return fmt.Errorf("invalid page label index %v", a[i])
+		}
+		d, err := ctx.DereferenceDict(a[i+1])
+		if err != nil {
+			return err
+		}
+		r := labelRange{start: start.Value(), first: 1}
+		if s, ok := d["S"].(types.Name); ok {
+			r.style = s.Value()
+		}
+		if pfx, ok := d["P"]; ok {
+			r.prefix, _ = ctx.DereferenceText(pfx)
+		}
+		if st, err := ctx.DereferenceInteger(d["St"]); err == nil && st != nil {
+			r.first = st.Value()
+		}
+		*lr = append(*lr, r)
+	}
+	return nil
+}
+
+// label returns the label of the 0-indexed page
+func (lr labelRanges) label(page int) string {
+
+	var r *labelRange
+	for i := range lr {
+		if lr[i].start <= page && (r == nil || lr[i].start >= r.start) {
+			r = &lr[i]
+		}
+	}
+	if r == nil {
+		return ""
+	}
+
+	n := r.first + page - r.start
+	switch r.style {
+	case "D":
+		return fmt.Sprintf("%s%d", r.prefix, n)
+	case "R":
+		return r.prefix + roman(n)
+	case "r":
+		return r.prefix + strings.ToLower(roman(n))
+	case "A":
+		return r.prefix + letters(n)
+	case "a":
+		return r.prefix + strings.ToLower(letters(n))
+	}
+	// ranges without a style have only a prefix
+	return r.prefix
+}
+
+// roman returns n as an upper case roman numeral
+func roman(n int) string {
+	numerals := []struct {
+		value  int
+		symbol string
+	}{
+		{1000, "M"}, {900, "CM"}, {500, "D"}, {400, "CD"},
+		{100, "C"}, {90, "XC"}, {50, "L"}, {40, "XL"},
+		{10, "X"}, {9, "IX"}, {5, "V"}, {4, "IV"}, {1, "I"},
+	}
+	var s strings.Builder
+	for _, r := range numerals {
+		for n >= r.value {
+			s.WriteString(r.symbol)
+			n -= r.value
+		}
+	}
+	return s.String()
+}
+
+// letters returns n as upper case letters in the pdf page label style,
+// A to Z, then AA to ZZ and so on
+func letters(n int) string {
+	if n < 1 {
+		return ""
+	}
+	return strings.Repeat(string(rune('A'+(n-1)%26)), (n-1)/26+1)
+}
+
+// WritePageLabels copies the pdf read from rs to w, labelling each page
+// with a decimal page number, such as the number of the page in the
+// document from which the pdf was made. numbers holds the number of
+// each page in order; runs of consecutive numbers share a label range.
+func WritePageLabels(rs io.ReadSeeker, w io.Writer, numbers []int) error {
+
+	ctx, err := pdfapi.ReadContext(rs, nil)
+	if err != nil {
+		return err
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		return err
+	}
+	if len(numbers) != ctx.PageCount {
+		return fmt.Errorf("%d page numbers for %d pages", len(numbers), ctx.PageCount)
+	}
+
+	nums := types.Array{}
+	for i, n := range numbers {
+		if i > 0 && n == numbers[i-1]+1 {
+			continue
+		}
+		nums = append(nums,
+			types.Integer(i),
+			types.Dict{"S": types.Name("D"), "St": types.Integer(n)},
+		)
+	}
+	root, err := ctx.Catalog()
+	if err != nil {
+		return err
+	}
+	root["PageLabels"] = types.Dict{"Nums": nums}
+
+	return pdfapi.WriteContext(ctx, w)
+}
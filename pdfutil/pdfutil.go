@@ -1,14 +1,21 @@
-// Package pdfutil provides info on and rotates pdf files. The page
-// sizing information is simplified to only report on the first page.
+// Package pdfutil provides info on, rotates and annotates pdf files. PDFFile
+// reports the document metadata and the boxes, rotation, orientation and
+// label of each page, with the dimensions of the first page also
+// reported for the document as a whole. WriteInkAnnotations adds ink
+// annotations to a pdf as an incremental update, and SplitPages splits
+// a pdf into single page pdfs.
 package pdfutil
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 
 	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
 )
 
 // Orientation determines the orientation of a pdf file
@@ -30,13 +37,96 @@ func (o Orientation) String() string {
 	return ""
 }
 
-// PDFFile represents a pdf file
+// Dimensions are the width and height of a pdf page in points
+type Dimensions struct {
+	Width  float64
+	Height float64
+}
+
+// PageDimensions returns the dimensions of each page of a pdf, using the
+// page CropBox, or MediaBox if there is no CropBox, with the width and
+// height swapped for pages rotated by 90 or 270 degrees
+func PageDimensions(rs io.ReadSeeker) ([]Dimensions, error) {
+
+	pageSizes, err := pdfapi.PageDims(rs, nil)
+	if err != nil {
+		return nil, fmt.Errorf("pagesize error: %s", err)
+	}
+	dims := make([]Dimensions, len(pageSizes))
+	for i, ps := range pageSizes {
+		dims[i] = Dimensions{Width: ps.Width, Height: ps.Height}
+	}
+	return dims, nil
+}
+
+// HasCropBox reports if the first page of a pdf has a CropBox, either
+// its own or one inherited from the page tree
+func HasCropBox(rs io.ReadSeeker) (bool, error) {
+
+	ctx, err := pdfapi.ReadContext(rs, nil)
+	if err != nil {
+		return false, fmt.Errorf("read error: %s", err)
+	}
+	page, _, inherited, err := ctx.PageDict(1, false)
+	if err != nil {
+		return false, fmt.Errorf("page error: %s", err)
+	}
+	if page == nil {
+		return false, errors.New("page error: no first page")
+	}
+	return page["CropBox"] != nil || inherited.CropBox != nil, nil
+}
+
+// SplitPages splits a pdf into single page pdfs, one for each page.
+// Each page is given its CropBox, which defaults to the MediaBox, and
+// is written without object or xref streams, which gofpdi cannot read.
+func SplitPages(rs io.ReadSeeker) ([]io.ReadSeeker, error) {
+
+	ctx, err := pdfapi.ReadContext(rs, nil)
+	if err != nil {
+		return nil, fmt.Errorf("split error: %s", err)
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		return nil, fmt.Errorf("split error: %s", err)
+	}
+	boundaries, err := ctx.PageBoundaries(nil)
+	if err != nil {
+		return nil, fmt.Errorf("split error: %s", err)
+	}
+	pages := make([]io.ReadSeeker, ctx.PageCount)
+	for i := range pages {
+		page, err := pdfcpu.ExtractPage(ctx, i+1)
+		if err != nil {
+			return nil, fmt.Errorf("split error: page %d: %s", i+1, err)
+		}
+		pageDict, _, _, err := page.PageDict(1, false)
+		if err != nil {
+			return nil, fmt.Errorf("split error: page %d: %s", i+1, err)
+		}
+		pageDict["CropBox"] = boundaries[i].CropBox().Array()
+		page.WriteObjectStream = false
+		page.WriteXRefStream = false
+		var buf bytes.Buffer
+		if err = pdfapi.WriteContext(page, &buf); err != nil {
+			return nil, fmt.Errorf("split error: page %d: %s", i+1, err)
+		}
+		pages[i] = bytes.NewReader(buf.Bytes())
+	}
+	return pages, nil
+}
+
+// PDFFile represents a pdf file. Width, Height and Orientation are those
+// of the first page; PageInfo describes every page.
 type PDFFile struct {
 	FilePath    string
 	Pages       int
 	Width       float64
 	Height      float64
 	Orientation Orientation
+	Title       string
+	Author      string
+	Producer    string
+	PageInfo    []PageInfo
 }
 
 // NewPDFFile returns a new PDFFile
@@ -51,12 +141,7 @@ func NewPDFFile(path string) (*PDFFile, error) {
 	}
 	defer f.Close()
 
-	p.Pages, err = pdfapi.PageCount(f, nil)
-	if err != nil {
-		return &p, fmt.Errorf("pagecount error: %s", err)
-	}
-
-	// get page sizes
+	// get page sizes and metadata
 	err = p.dimensions(f)
 	if err != nil {
 		return &p, err
@@ -77,23 +162,27 @@ func (p *PDFFile) dimensions(f io.ReadSeeker) error {
 		f = io.ReadSeeker(g)
 	}
 
-	pageSizes, err := pdfapi.PageDims(f, nil)
+	err = p.readInfo(f)
 	if err != nil {
-		return fmt.Errorf("pagesize error: %s", err)
+		return err
 	}
-	// use first page only as simplification
-	if len(pageSizes) < 1 {
+	if len(p.PageInfo) < 1 {
 		return errors.New("could not retrieve first page size")
 	}
-	p.Width = pageSizes[0].Width
-	p.Height = pageSizes[0].Height
+	p.Width = p.PageInfo[0].Width
+	p.Height = p.PageInfo[0].Height
+	p.Orientation = p.PageInfo[0].Orientation
+	return nil
+}
 
-	if p.Width > p.Height {
-		p.Orientation = landscape
-	} else {
-		p.Orientation = portrait
+// MixedSizes reports if the pages of the pdf are not all the same size
+func (p *PDFFile) MixedSizes() bool {
+	for _, pi := range p.PageInfo {
+		if pi.Width != p.Width || pi.Height != p.Height {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
 // String returns a string representation of a PDFFile
@@ -103,8 +192,16 @@ Filepath    : %s
 Pages       : %d
 Dimensions  : %0.7f (w) %0.7f (h)
 Orientation : %s
-`
-	return fmt.Sprintf(tpl, p.FilePath, p.Pages, p.Width, p.Height, p.Orientation)
+Title       : %s
+Author      : %s
+Producer    : %s
+%s`
+	var pages strings.Builder
+	for _, pi := range p.PageInfo {
+		pages.WriteString(pi.String() + "\n")
+	}
+	return fmt.Sprintf(tpl, p.FilePath, p.Pages, p.Width, p.Height, p.Orientation,
+		p.Title, p.Author, p.Producer, pages.String())
 }
 
 func (p *PDFFile) rotate(rotation int, copyFile string) error {
@@ -1,11 +1,20 @@
 package pdfutil
 
 import (
+	"bytes"
 	"fmt"
+	"image/color"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	pdfapi "github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
 )
 
 func TestInfo(t *testing.T) {
@@ -107,3 +116,365 @@ func TestRotateCopy(t *testing.T) {
 	os.Remove(tName) // remove file
 
 }
+
+// TestPageDimensions tests reading the size of each page
+func TestPageDimensions(t *testing.T) {
+
+	f, err := os.Open("../testfiles/e724bba2-266f-434d-aaf2-935d2b405aee.pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dims, err := PageDimensions(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dims) != 2 {
+		t.Fatalf("pages should equal 2, got %d", len(dims))
+	}
+	for i, d := range dims {
+		if d.Width <= d.Height {
+			t.Errorf("page %d should be landscape, got %f x %f", i, d.Width, d.Height)
+		}
+	}
+}
+
+// TestSplitPages tests that splitting a pdf with pages of mixed sizes
+// keeps the size of each page, and gives each page a CropBox
+func TestSplitPages(t *testing.T) {
+
+	f, err := os.Open(labelledPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	want, err := PageDimensions(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.Seek(0, io.SeekStart)
+	cropped, err := HasCropBox(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cropped {
+		t.Error("the first page should have no CropBox")
+	}
+	_, _ = f.Seek(0, io.SeekStart)
+	pages, err := SplitPages(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != len(want) {
+		t.Fatalf("pages should equal %d, got %d", len(want), len(pages))
+	}
+	for i, p := range pages {
+		dims, err := PageDimensions(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(dims) != 1 || dims[0] != want[i] {
+			t.Errorf("page %d should be %v, got %v", i+1, want[i], dims)
+		}
+		_, _ = p.Seek(0, io.SeekStart)
+		if cropped, err := HasCropBox(p); err != nil || !cropped {
+			t.Errorf("page %d should have a CropBox, got %t %v", i+1, cropped, err)
+		}
+	}
+}
+
+// labelledPDF writes a pdf with metadata, page labels and pages of
+// mixed sizes, one rotated and one cropped
+func labelledPDF(t *testing.T) string {
+	t.Helper()
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	pdf.SetTitle("Test Title", true)
+	pdf.SetAuthor("Test Author", true)
+	pdf.AddPage()
+	pdf.AddPage()
+	pdf.AddPageFormat("L", gofpdf.SizeType{Wd: 612, Ht: 792})
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := pdfapi.ReadContext(bytes.NewReader(buf.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		t.Fatal(err)
+	}
+	page2, _, _, err := ctx.PageDict(2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page2["Rotate"] = types.Integer(90)
+	page3, _, _, err := ctx.PageDict(3, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	page3["CropBox"] = types.NewRectangle(10, 10, 410, 310).Array()
+	root, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root["PageLabels"] = types.Dict{
+		"Nums": types.Array{
+			types.Integer(0), types.Dict{"S": types.Name("r")},
+			types.Integer(1), types.Dict{"S": types.Name("D"), "P": types.StringLiteral("A-"), "St": types.Integer(4)},
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "labelled.pdf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = pdfapi.WriteContext(ctx, f); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestPageInfo tests reading metadata and the details of each page
+func TestPageInfo(t *testing.T) {
+
+	p, err := NewPDFFile(labelledPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Title != "Test Title" || p.Author != "Test Author" {
+		t.Errorf("title/author %q/%q unexpected", p.Title, p.Author)
+	}
+	if !strings.Contains(p.Producer, "pdfcpu") {
+		t.Errorf("producer %q unexpected", p.Producer)
+	}
+	if p.Pages != 3 || len(p.PageInfo) != 3 {
+		t.Fatalf("pages %d/%d should equal 3", p.Pages, len(p.PageInfo))
+	}
+	if !p.MixedSizes() {
+		t.Error("pages should be of mixed sizes")
+	}
+
+	for i, test := range []struct {
+		label       string
+		rotation    int
+		width       float64
+		height      float64
+		orientation Orientation
+		cropBox     Box
+	}{
+		{"i", 0, 595.28, 841.89, portrait, Box{0, 0, 595.28, 841.89}},
+		{"A-4", 90, 841.89, 595.28, landscape, Box{0, 0, 595.28, 841.89}},
+		{"A-5", 0, 400, 300, landscape, Box{10, 10, 410, 310}},
+	} {
+		pi := p.PageInfo[i]
+		if pi.Number != i+1 || pi.Label != test.label || pi.Rotation != test.rotation {
+			t.Errorf("page %d number/label/rotation %d/%s/%d unexpected", i, pi.Number, pi.Label, pi.Rotation)
+		}
+		if math.Round(pi.Width) != math.Round(test.width) || math.Round(pi.Height) != math.Round(test.height) {
+			t.Errorf("page %d size %f x %f not %f x %f", i, pi.Width, pi.Height, test.width, test.height)
+		}
+		if pi.Orientation != test.orientation {
+			t.Errorf("page %d orientation %s not %s", i, pi.Orientation, test.orientation)
+		}
+		if math.Round(pi.CropBox.URX) != math.Round(test.cropBox.URX) || pi.CropBox.LLX != test.cropBox.LLX {
+			t.Errorf("page %d cropbox %s not %s", i, pi.CropBox, test.cropBox)
+		}
+	}
+	if p.PageInfo[2].MediaBox.Width() != 792 {
+		t.Errorf("third page mediabox %s unexpected", p.PageInfo[2].MediaBox)
+	}
+	if !strings.Contains(p.String(), "page 2 (A-4)") {
+		t.Errorf("String should show page labels, got %s", p)
+	}
+}
+
+// TestPageLabels tests formatting page label styles
+func TestPageLabels(t *testing.T) {
+
+	labels := labelRanges{
+		{start: 0, style: "R", first: 1},
+		{start: 4, style: "a", first: 26},
+		{start: 6, prefix: "index"},
+	}
+	expected := []string{"I", "II", "III", "IV", "z", "aa", "index", "index"}
+	for i, e := range expected {
+		if l := labels.label(i); l != e {
+			t.Errorf("page %d label %q not %q", i, l, e)
+		}
+	}
+	if roman(1994) != "MCMXCIV" {
+		t.Errorf("roman 1994 %s not MCMXCIV", roman(1994))
+	}
+}
+
+// TestWritePageLabels tests labelling pages with their numbers
+func TestWritePageLabels(t *testing.T) {
+
+	pdf := gofpdf.New("P", "pt", "A4", "")
+	for i := 0; i < 4; i++ {
+		pdf.AddPage()
+	}
+	var in bytes.Buffer
+	if err := pdf.Output(&in); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "numbered.pdf")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WritePageLabels(bytes.NewReader(in.Bytes()), f, []int{2, 3, 7, 10})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPDFFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, label := range []string{"2", "3", "7", "10"} {
+		if p.PageInfo[i].Label != label {
+			t.Errorf("page %d label %q not %q", i+1, p.PageInfo[i].Label, label)
+		}
+	}
+
+	err = WritePageLabels(bytes.NewReader(in.Bytes()), io.Discard, []int{1})
+	if err == nil {
+		t.Error("expected an error for too few page numbers")
+	}
+}
+
+// TestUserSpace tests converting displayed points to the user space of
+// rotated and cropped pages
+func TestUserSpace(t *testing.T) {
+
+	crop := types.NewRectangle(10, 20, 110, 220) // 100 x 200
+	tests := []struct {
+		rotation int
+		x, y     float64
+	}{
+		{0, 15, 200},
+		{90, 30, 25},
+		{180, 105, 40},
+		{270, 90, 215},
+		{-90, 90, 215},
+	}
+	for _, tt := range tests {
+		x, y := userSpace{crop, tt.rotation}.point(Point{5, 20})
+		if math.Abs(x-tt.x) > 1e-9 || math.Abs(y-tt.y) > 1e-9 {
+			t.Errorf("rotation %d point %f, %f not %f, %f", tt.rotation, x, y, tt.x, tt.y)
+		}
+	}
+}
+
+// TestSimplify tests simplifying the paths of ink annotations
+func TestSimplify(t *testing.T) {
+
+	line := []Point{}
+	for i := 0; i <= 100; i++ {
+		line = append(line, Point{float64(i), 0.1 * float64(i%2)})
+	}
+	if got := simplify(line, 0.25); len(got) != 2 || got[0] != line[0] || got[1] != line[100] {
+		t.Errorf("straight line simplified to %v", got)
+	}
+	corner := []Point{{0, 0}, {5, 0.1}, {10, 0}, {10, 5}, {10, 10}}
+	if got := simplify(corner, 0.25); fmt.Sprint(got) != "[{0 0} {10 0} {10 10}]" {
+		t.Errorf("corner simplified to %v", got)
+	}
+}
+
+// TestWriteInkAnnotations tests adding ink annotations to rotated and
+// cropped pages as an incremental update
+func TestWriteInkAnnotations(t *testing.T) {
+
+	in, err := os.ReadFile(labelledPDF(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ink := Ink{
+		Paths: []InkPath{
+			{Points: []Point{{10, 20}, {30, 20}}, Erased: [][]Point{{{15, 15}, {20, 15}, {20, 25}}}},
+			{Outline: []Point{{40, 40}, {50, 40}, {45, 50}}, Points: []Point{{45, 45}}},
+		},
+		Width:   2,
+		Colour:  color.RGBA{255, 0, 0, 255},
+		Opacity: 0.5,
+		Title:   "Layer (1)",
+		Subject: "highlighter",
+	}
+	var out bytes.Buffer
+	err = WriteInkAnnotations(bytes.NewReader(in), &out, map[int][]Ink{1: {ink}, 2: {ink, ink}, 3: {ink}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out.Bytes(), in) {
+		t.Error("the original pdf should be unchanged")
+	}
+	if err := pdfapi.Validate(bytes.NewReader(out.Bytes()), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, err := pdfapi.ReadContext(bytes.NewReader(out.Bytes()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ctx.EnsurePageCount(); err != nil {
+		t.Fatal(err)
+	}
+	// rects of the annotations, allowing for half the line width and a
+	// margin of 1pt, on an A4 page, a rotated A4 page and a page cropped
+	// to 400 x 300 from 10, 10
+	rects := []string{
+		"[8.00 789.89 52.00 823.89]",
+		"[18.00 8.00 52.00 52.00]",
+		"[18.00 258.00 62.00 292.00]",
+	}
+	for i, rect := range rects {
+		d, _, _, err := ctx.PageDict(i+1, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		annots, err := ctx.DereferenceArray(d["Annots"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(annots) != 1+i%2 {
+			t.Errorf("page %d has %d annotations", i+1, len(annots))
+			continue
+		}
+		a, err := ctx.DereferenceDict(annots[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.NameEntry("Subtype") == nil || *a.NameEntry("Subtype") != "Ink" {
+			t.Errorf("page %d annotation is not an ink annotation: %v", i+1, a)
+		}
+		if r := a.ArrayEntry("Rect"); r.String() != rect {
+			t.Errorf("page %d annotation rect %s not %s", i+1, r, rect)
+		}
+		if title, err := ctx.DereferenceText(a["T"]); err != nil || title != "Layer (1)" {
+			t.Errorf("page %d annotation title %q", i+1, title)
+		}
+	}
+
+	var copied bytes.Buffer
+	if err := WriteInkAnnotations(bytes.NewReader(in), &copied, nil); err != nil || !bytes.Equal(copied.Bytes(), in) {
+		t.Errorf("a pdf without annotations should be copied unchanged: %v", err)
+	}
+	if err := WriteInkAnnotations(bytes.NewReader(in), io.Discard, map[int][]Ink{4: {ink}}); err == nil {
+		t.Error("expected an error for a page out of range")
+	}
+}
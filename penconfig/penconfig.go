@@ -15,6 +15,21 @@
 //       color:   blue
 //       opacity: 0.8
 //
+// The optional pressure and tilt keys determine if the width of the
+// pen's strokes varies with the pressure of the pen, or with the width
+// recorded by the tablet at each point, which follows the tilt of the
+// pencils and paintbrush, for example
+//
+//   all:
+//     - pen:      pencil
+//       weight:   standard
+//       width:    1.9
+//       color:    black
+//       opacity:  1
+//       pressure: true
+//       tilt:     false
+//
+// If these keys are omitted the pen's default settings are used.
 package penconfig
 
 import (
@@ -48,13 +63,16 @@ var penTypes = []string{
 // penWeights are the currently understood pen weights
 var penWeights = []string{"narrow", "standard", "broad"}
 
-// PenConfig allows the configuration of a s
+// PenConfig allows the configuration of a s. Pressure and Tilt are nil
+// if they are not set.
 type PenConfig struct {
-	Pen     string         `yaml:"pen"`
-	Weight  string         `yaml:"weight"`
-	Width   float64        `yaml:"width"`
-	Colour  LocalPenColour `yaml:"color"`
-	Opacity float64        `yaml:"opacity"`
+	Pen      string         `yaml:"pen"`
+	Weight   string         `yaml:"weight"`
+	Width    float64        `yaml:"width"`
+	Colour   LocalPenColour `yaml:"color"`
+	Opacity  float64        `yaml:"opacity"`
+	Pressure *bool          `yaml:"pressure"`
+	Tilt     *bool          `yaml:"tilt"`
 }
 
 // LayerPenConfigs defines StrokeSettings by layer
@@ -95,8 +113,6 @@ func (lpc LayerPenConfigs) GetPen(layerNo int, penName, penWidth string) (*PenCo
 		if pen.Pen == penName && pen.Weight == "standard" {
 			copiedPen := pen
 			copiedPen.GetWidth(penWidth)
-			// add pen to configuration to avoid lookup
-			layerPens = append(layerPens, copiedPen)
 			return &copiedPen, true
 		}
 	}
@@ -109,11 +125,13 @@ func (pc *PenConfig) UnmarshalYAML(value *yaml.Node) (err error) {
 
 	// auxiliary unmarshal struct
 	type AuxPenConfig struct {
-		Pen     string  `yaml:"pen"`
-		Weight  string  `yaml:"weight"`
-		Width   float64 `yaml:"width"`
-		Colour  string  `yaml:"color"`
-		Opacity float64 `yaml:"opacity"`
+		Pen      string  `yaml:"pen"`
+		Weight   string  `yaml:"weight"`
+		Width    float64 `yaml:"width"`
+		Colour   string  `yaml:"color"`
+		Opacity  float64 `yaml:"opacity"`
+		Pressure *bool   `yaml:"pressure"`
+		Tilt     *bool   `yaml:"tilt"`
 	}
 
 	var apc AuxPenConfig
@@ -129,11 +147,13 @@ func (pc *PenConfig) UnmarshalYAML(value *yaml.Node) (err error) {
 	}
 
 	*pc = PenConfig{
-		Pen:     apc.Pen,
-		Weight:  apc.Weight,
-		Width:   apc.Width,
-		Colour:  lpc,
-		Opacity: apc.Opacity,
+		Pen:      apc.Pen,
+		Weight:   apc.Weight,
+		Width:    apc.Width,
+		Colour:   lpc,
+		Opacity:  apc.Opacity,
+		Pressure: apc.Pressure,
+		Tilt:     apc.Tilt,
 	}
 
 	return nil
@@ -124,6 +124,51 @@ func TestPenConfigParseOK2(t *testing.T) {
 	t.Log(lpc)
 }
 
+// TestPenConfigPressureTilt tests parsing the optional pressure and
+// tilt keys
+func TestPenConfigPressureTilt(t *testing.T) {
+
+	y := []byte(`
+all:
+  - pen:      pencil
+    weight:   standard
+    width:    1.9
+    color:    black
+    opacity:  1
+    pressure: true
+    tilt:     false
+
+  - pen:      fineliner
+    weight:   standard
+    width:    0.8
+    color:    blue
+    opacity:  1`)
+
+	lpc, err := LoadYaml(y)
+	if err != nil {
+		t.Fatalf("load config error %s", err)
+	}
+
+	p, ok := lpc.GetPen(0, "pencil", "standard")
+	if !ok {
+		t.Fatal("could not get pen 0/pencil/standard")
+	}
+	if p.Pressure == nil || *p.Pressure != true {
+		t.Errorf("pencil pressure %v not true", p.Pressure)
+	}
+	if p.Tilt == nil || *p.Tilt != false {
+		t.Errorf("pencil tilt %v not false", p.Tilt)
+	}
+
+	p, ok = lpc.GetPen(0, "fineliner", "standard")
+	if !ok {
+		t.Fatal("could not get pen 0/fineliner/standard")
+	}
+	if p.Pressure != nil || p.Tilt != nil {
+		t.Errorf("fineliner pressure and tilt %v %v should not be set", p.Pressure, p.Tilt)
+	}
+}
+
 // TestNewConfigFromFile tests loading a pen configuration from a yaml
 // file
 func TestNewConfigFromFile(t *testing.T) {
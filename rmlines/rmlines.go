@@ -0,0 +1,190 @@
+/*
+Package rmlines provides a single entry point for parsing reMarkable
+tablet "lines" or ".rm" files of any supported version.
+
+The 43 byte header of each file is inspected to determine the file
+version, and the file is then decoded by the parser for that version.
+The result is a format-neutral Page of Layers of Strokes, so that
+renderers do not need to know which version of file they are drawing.
+
+Usage example:
+
+	page, err := rmlines.Parse(f)
+	for _, layer := range page.Layers {
+		for _, stroke := range layer.Strokes {
+			for _, point := range stroke.Points {
+				// do something with point
+			}
+		}
+	}
+
+MIT licensed, please see LICENCE
+RCL February 2022
+*/
+
+package rmlines
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/rorycl/rm2pdf/rmparse"
+	"github.com/rorycl/rm2pdf/rmparsev6"
+)
+
+// HeaderLength is the length of an .rm file header
+const HeaderLength = 43
+
+// headerPrefix is the start of all .rm file headers, followed by the
+// version number
+const headerPrefix = "reMarkable .lines file, version="
+
+// ErrUnsupportedVersion is returned for .rm files of a version without
+// a parser
+var ErrUnsupportedVersion = errors.New("rm file version not supported")
+
+// Page is the format-neutral content of an .rm file
+type Page struct {
+	Version int
+	Layers  []Layer
+}
+
+// Layer is a layer of strokes. The layer name is only recorded in
+// version 6 files; earlier versions record layer names in a separate
+// metadata file.
+type Layer struct {
+	Name    string
+	Visible bool
+	Strokes []Stroke
+}
+
+// Stroke is a pen stroke, or path, made up of points. Width is the pen
+// width recorded on the tablet, such as 1.875, 2.0 or 2.125.
+type Stroke struct {
+	Pen    int
+	Colour int
+	Width  float32
+	Points []Point
+}
+
+// Point is a point in a stroke in tablet coordinates, with the origin
+// at the top left of the page. Pressure is the pressure of the pen from
+// 0 to 1. Width is the width of the stroke at the point as drawn by the
+// tablet, which varies with the pressure, speed and tilt of the pencil
+// and paintbrush pens; its units differ between file versions.
+type Point struct {
+	X        float32
+	Y        float32
+	Pressure float32
+	Width    float32
+}
+
+// Version reports the version of an .rm file from its header
+func Version(header []byte) (int, error) {
+	h := string(header)
+	if !strings.HasPrefix(h, headerPrefix) {
+		return 0, fmt.Errorf("header %q is not an rm file header", strings.TrimRight(h, " \x00"))
+	}
+	v := strings.TrimRight(strings.TrimPrefix(h, headerPrefix), " \x00")
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("header version %q invalid", v)
+	}
+	return version, nil
+}
+
+// Parse reads an .rm file, determines its version from its header and
+// decodes it into a Page
+func Parse(r io.Reader) (*Page, error) {
+
+	header := make([]byte, HeaderLength)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, fmt.Errorf("could not read rm file header: %w", err)
+	}
+	version, err := Version(header)
+	if err != nil {
+		return nil, err
+	}
+
+	// replace the header for the version parser
+	mr := io.MultiReader(bytes.NewReader(header), r)
+
+	switch version {
+	case 3, 5:
+		return parseV5(mr)
+	case 6:
+		return parseV6(mr)
+	}
+	return nil, fmt.Errorf("version %d: %w", version, ErrUnsupportedVersion)
+}
+
+// fromSegments converts rmparse segments to points
+func fromSegments(segments []rmparse.Segment) []Point {
+	points := make([]Point, len(segments))
+	for i, s := range segments {
+		points[i] = Point{X: s.X, Y: s.Y, Pressure: s.Pressure, Width: s.Width}
+	}
+	return points
+}
+
+// fromRMPath converts an rmparse path to a stroke
+func fromRMPath(p rmparse.RMPath) Stroke {
+	return Stroke{
+		Pen:    int(p.Path.Pen),
+		Colour: int(p.Path.Colour),
+		Width:  p.Path.Width,
+		Points: fromSegments(p.Segments),
+	}
+}
+
+// parseV5 parses version 5 files and the similar version 3 files made
+// by early tablet software
+func parseV5(r io.Reader) (*Page, error) {
+
+	rm, err := rmparse.RMParse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Version: rm.Version, Layers: make([]Layer, rm.LayerNo)}
+	for i := range page.Layers {
+		page.Layers[i].Visible = true
+	}
+	for rm.Parse() {
+		// empty layers report a path with no layer
+		if rm.Path.Layer == 0 {
+			continue
+		}
+		l := &page.Layers[rm.Path.Layer-1]
+		l.Strokes = append(l.Strokes, fromRMPath(rm.Path))
+	}
+	if err := rm.Err(); err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// parseV6 parses version 6 files
+func parseV6(r io.Reader) (*Page, error) {
+
+	rm, err := rmparsev6.RMParse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &Page{Version: 6, Layers: make([]Layer, rm.LayerNo)}
+	for i, l := range rm.Layers {
+		page.Layers[i].Name = l.Name
+		page.Layers[i].Visible = l.Visible
+	}
+	for rm.Parse() {
+		l := &page.Layers[rm.Path.Layer-1]
+		l.Strokes = append(l.Strokes, fromRMPath(rm.Path))
+	}
+	return page, nil
+}
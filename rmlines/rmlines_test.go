@@ -0,0 +1,168 @@
+/*
+rmlines_test.go
+MIT licenced, please see LICENCE
+RCL February 2022
+*/
+
+package rmlines
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+// TestParseVersions tests parsing .rm files of different versions
+func TestParseVersions(t *testing.T) {
+
+	for _, test := range []struct {
+		file       string
+		version    int
+		layers     int
+		strokes    []int
+		layerNames []string
+	}{
+		{
+			file:       "../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm",
+			version:    5,
+			layers:     2,
+			layerNames: []string{"", ""},
+		},
+		{
+			file:       "../testfiles/54abf601-2e54-44d3-85d6-17c8c1472ef0.rm",
+			version:    5,
+			layers:     2,
+			layerNames: []string{"", ""},
+		},
+		{
+			file:       "../testfiles/7cbc50c9-8d68-48cf-8f77-e70f2e87b732.rm",
+			version:    5,
+			layers:     2,
+			layerNames: []string{"", ""},
+		},
+		{
+			file:       "../testfiles/version3.rm",
+			version:    3,
+			layers:     2,
+			layerNames: []string{"", ""},
+		},
+		{
+			file:       "../testfiles/version6.rm",
+			version:    6,
+			layers:     2,
+			strokes:    []int{11, 15},
+			layerNames: []string{"Layer 1", "Layer 2"},
+		},
+	} {
+		f, err := os.Open(test.file)
+		if err != nil {
+			t.Fatalf("Could not open file %v", err)
+		}
+		defer f.Close()
+
+		page, err := Parse(f)
+		if err != nil {
+			t.Fatalf("%s parse error %v", test.file, err)
+		}
+		if page.Version != test.version {
+			t.Errorf("%s version %d not %d", test.file, page.Version, test.version)
+		}
+		if len(page.Layers) != test.layers {
+			t.Fatalf("%s layers %d not %d", test.file, len(page.Layers), test.layers)
+		}
+		for i, l := range page.Layers {
+			if l.Name != test.layerNames[i] {
+				t.Errorf("%s layer %d name %q not %q", test.file, i, l.Name, test.layerNames[i])
+			}
+			if !l.Visible {
+				t.Errorf("%s layer %d not visible", test.file, i)
+			}
+			for _, s := range l.Strokes {
+				if len(s.Points) == 0 {
+					t.Errorf("%s layer %d has a stroke with no points", test.file, i)
+				}
+				for _, p := range s.Points {
+					if p.Pressure < 0 || p.Pressure > 1 {
+						t.Fatalf("%s layer %d point %+v pressure out of range", test.file, i, p)
+					}
+					if p.Width <= 0 {
+						t.Fatalf("%s layer %d point %+v has no width", test.file, i, p)
+					}
+				}
+			}
+		}
+		for i, n := range test.strokes {
+			if len(page.Layers[i].Strokes) != n {
+				t.Errorf("%s layer %d strokes %d not %d", test.file, i, len(page.Layers[i].Strokes), n)
+			}
+		}
+	}
+}
+
+// TestParseV5Strokes checks the last stroke of a v5 file is converted
+func TestParseV5Strokes(t *testing.T) {
+
+	f, err := os.Open("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm")
+	if err != nil {
+		t.Fatalf("Could not open file %v", err)
+	}
+	defer f.Close()
+
+	page, err := Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// see rmparse.TestRMParseA
+	strokes := page.Layers[0].Strokes
+	last := strokes[len(strokes)-1]
+	if last.Pen != 17 || last.Width != 2 || len(last.Points) != 226 {
+		t.Errorf("last stroke pen/width/points %d/%f/%d not 17/2/226", last.Pen, last.Width, len(last.Points))
+	}
+	expected := Point{X: 1033.4183, Y: 1429.1265, Pressure: 0.472628, Width: 4}
+	if p := last.Points[len(last.Points)-1]; p != expected {
+		t.Errorf("last point %+v not %+v", p, expected)
+	}
+	if len(page.Layers[1].Strokes) != 0 {
+		t.Errorf("second layer should be empty")
+	}
+}
+
+// TestParseUnsupported tests unsupported and invalid headers
+func TestParseUnsupported(t *testing.T) {
+
+	header := func(s string) []byte {
+		b := bytes.Repeat([]byte(" "), HeaderLength)
+		copy(b, s)
+		return append(b, 0, 0, 0, 0)
+	}
+
+	_, err := Parse(bytes.NewReader(header("reMarkable .lines file, version=9")))
+	if !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("version 9 should be unsupported, got %v", err)
+	}
+
+	_, err = Parse(bytes.NewReader(header("not a lines file")))
+	if err == nil {
+		t.Error("invalid header should error")
+	}
+
+	_, err = Parse(bytes.NewReader([]byte("reMarkable")))
+	if err == nil {
+		t.Error("short header should error")
+	}
+}
+
+// TestVersion tests header version detection
+func TestVersion(t *testing.T) {
+
+	v, err := Version([]byte("reMarkable .lines file, version=6          "))
+	if err != nil || v != 6 {
+		t.Errorf("version %d err %v, expected 6", v, err)
+	}
+	_, err = Version([]byte("reMarkable .lines file, version=x          "))
+	if err == nil {
+		t.Error("expected error for invalid version")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+encode_test.go
+MIT licenced, please see LICENCE
+RCL February 2022
+*/
+
+package rmparse
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtures returns the contents of the .rm files in the testfiles
+// directory, including those in zip files, by name
+func fixtures(t *testing.T) map[string][]byte {
+	t.Helper()
+
+	files := map[string][]byte{}
+	err := filepath.WalkDir("../testfiles", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".rm":
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			files[path] = b
+		case ".zip":
+			z, err := zip.OpenReader(path)
+			if err != nil {
+				return err
+			}
+			defer z.Close()
+			for _, zf := range z.File {
+				if !strings.HasSuffix(zf.Name, ".rm") {
+					continue
+				}
+				r, err := zf.Open()
+				if err != nil {
+					return err
+				}
+				b, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					return err
+				}
+				files[path+"/"+zf.Name] = b
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+// TestEncodeRoundTrip parses each version 5 fixture, re-encodes it and
+// checks the output is identical to the fixture
+func TestEncodeRoundTrip(t *testing.T) {
+
+	encoded := 0
+	for name, b := range fixtures(t) {
+		if !bytes.HasPrefix(b, []byte(Header)) {
+			t.Logf("skipping %s: not a version 5 file", name)
+			continue
+		}
+		layers, err := ReadLayers(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s parsing error %v", name, err)
+		}
+		var buf bytes.Buffer
+		err = Encode(&buf, layers)
+		if err != nil {
+			t.Fatalf("%s encoding error %v", name, err)
+		}
+		if !bytes.Equal(buf.Bytes(), b) {
+			t.Errorf("%s encoded %d bytes do not match the original %d bytes", name, buf.Len(), len(b))
+		}
+		encoded++
+	}
+	if encoded < 8 {
+		t.Errorf("only %d fixtures were encoded", encoded)
+	}
+}
+
+// TestEncodeV3 tests that a version 3 file is encoded as the version 5
+// file from which it was made
+func TestEncodeV3(t *testing.T) {
+
+	f, err := os.Open("../testfiles/version3.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	layers, err := ReadLayers(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = Encode(&buf, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the version 5 file has no data in the second unknown path field
+	b, err := os.ReadFile("../testfiles/54abf601-2e54-44d3-85d6-17c8c1472ef0.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), b) {
+		t.Error("encoded version 3 file does not match the version 5 file")
+	}
+}
+
+// TestEncodeModified tests encoding synthesized paths
+func TestEncodeModified(t *testing.T) {
+
+	layers := Layers{
+		{},
+		{
+			{
+				// Layer and NumSegments are set when encoding
+				Path: Path{Pen: 17, Colour: 1, Width: 2, NumSegments: 99},
+				Segments: []Segment{
+					{X: 10, Y: 20, Speed: 0.5, Direction: 0.1, Width: 2, Pressure: 0.3},
+					{X: 30, Y: 40, Speed: 0.6, Direction: 0.2, Width: 2, Pressure: 0.4},
+				},
+			},
+		},
+		{},
+	}
+
+	var buf bytes.Buffer
+	err := Encode(&buf, layers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rm, err := RMParse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rm.LayerNo != 3 {
+		t.Errorf("layers %d not 3", rm.LayerNo)
+	}
+	paths := []RMPath{}
+	for rm.Parse() {
+		if rm.Path.Layer != 0 {
+			paths = append(paths, rm.Path)
+		}
+	}
+	if err := rm.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("paths %d not 1", len(paths))
+	}
+	p := paths[0]
+	if p.Layer != 2 || p.Path.Pen != 17 || p.Path.NumSegments != 2 {
+		t.Errorf("layer/pen/segments %d/%d/%d not 2/17/2", p.Layer, p.Path.Pen, p.Path.NumSegments)
+	}
+	if p.Segments[1] != layers[1][0].Segments[1] {
+		t.Errorf("segment %+v not %+v", p.Segments[1], layers[1][0].Segments[1])
+	}
+	if rm.Bounds.MinX != 10 || rm.Bounds.MaxY != 40 {
+		t.Errorf("unexpected bounds %+v", rm.Bounds)
+	}
+}
+
+// TestEncodeNoLayers tests that files must have at least one layer
+func TestEncodeNoLayers(t *testing.T) {
+
+	err := Encode(io.Discard, Layers{})
+	if !errors.Is(err, ErrBadLayerCount) {
+		t.Errorf("expected ErrBadLayerCount, got %v", err)
+	}
+}
@@ -12,6 +12,18 @@ iterates over the referenced reMarkable .rm file returning a data
 structure consisting of each path with its associated layer and path
 segments.
 
+Version 5 files and the version 3 files made by early tablet software
+are supported. Version 3 path records lack the second unknown field of
+version 5 paths; the layer and segment records are the same. No version
+4 files are known.
+
+Encode writes Layers of paths, such as those read by ReadLayers, as a
+version 5 file. Version 5 files are re-encoded byte for byte.
+
+All parsing state, including the maximum coordinates and bounds of the
+segments parsed, is held in each RMFile, so separate files may be parsed
+concurrently. An RMFile should not itself be shared between goroutines.
+
 MIT licensed, please see LICENCE
 RCL January 2020
 */
@@ -19,27 +31,75 @@ RCL January 2020
 package rmparse
 
 import (
+	"bufio"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 )
 
 // Header is an rm file header
 var Header = "reMarkable .lines file, version=5         "
 
+// HeaderV3 is the header of rm files made by early tablet software
+var HeaderV3 = "reMarkable .lines file, version=3         "
+
+// MaxLayers is the maximum number of layers accepted in an .rm file,
+// well beyond the number of layers made on the tablet, to guard against
+// corrupt headers
+const MaxLayers = 1024
+
+// Kinds of ParseError
+var (
+	ErrBadLayerCount    = errors.New("bad layer count")
+	ErrTruncatedLayer   = errors.New("truncated layer")
+	ErrTruncatedPath    = errors.New("truncated path")
+	ErrTruncatedSegment = errors.New("truncated segment")
+)
+
+// ParseError reports an error parsing an .rm file, including the
+// 1-indexed layer and path being parsed and the byte offset of the
+// record that could not be read. The Kind of error may be checked with
+// errors.Is, for example errors.Is(err, ErrTruncatedPath).
+type ParseError struct {
+	Kind   error
+	Layer  uint32
+	Path   uint32
+	Offset int64
+	Err    error
+}
+
+// Error returns a string representation of a ParseError
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at layer %d path %d offset %d: %v", e.Kind, e.Layer, e.Path, e.Offset, e.Err)
+}
+
+// Is reports if the ParseError is of the target Kind
+func (e *ParseError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// Unwrap returns the underlying error
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
 // RMFile is the reMarkable .rm file File parser metadata base structure
 type RMFile struct {
-	File           fs.File
+	File           io.Reader
 	Header         [43]byte
+	Version        int // file version, 3 or 5
 	LayerNo        uint32
 	ThisLayer      uint32
 	PathNo         uint32
 	ThisPath       uint32
 	Path           RMPath
 	MaxCoordinates MaxCoordinates
+	Bounds         BoundingBox   // bounds of all the segments in the file
+	LayerBounds    []BoundingBox // bounds of the segments by 0-indexed layer
 	Verbose        bool
+	reader         *countingReader // reader recording the file offset
+	err            error           // the first parsing error, if any
 }
 
 // RMPath is the reMarkable parsed data structure, returned by Parse()
@@ -62,47 +122,95 @@ type Paths struct {
 	Number uint32
 }
 
-// Path describes a path
+// Path describes a path. The unknown fields are retained so that paths
+// may be encoded without loss.
 // format <IIIfII
 type Path struct {
 	Pen         uint32
 	Colour      uint32
-	_           uint32 // unknown
+	Unknown1    uint32
 	Width       float32
-	_           uint32 // unknown
+	Unknown2    uint32
 	NumSegments uint32
 }
 
-// Segment describes a path segment
+// PathV3 describes a path in a version 3 file
+// format <IIIfI
+type PathV3 struct {
+	Pen         uint32
+	Colour      uint32
+	Unknown1    uint32
+	Width       float32
+	NumSegments uint32
+}
+
+// Segment describes a path segment. Speed is the speed of the pen,
+// Direction the direction of the stroke in radians from 0 to 2π, and
+// Pressure the pressure of the pen from 0 to 1.
 // format <ffffff
 type Segment struct {
-	X        float32
-	Y        float32
-	Pressure float32
-	Tilt     float32
-	_        float32 // unknown
-	_        float32 // unknown
+	X         float32
+	Y         float32
+	Speed     float32
+	Direction float32
+	Width     float32
+	Pressure  float32
 }
 
-// MaxCoordinates stores the maximum and minimum path segments
+// MaxCoordinates stores the maximum path segment coordinates, or zero
+// if all the coordinates are negative
 type MaxCoordinates struct {
 	X float32
 	Y float32
 }
 
-var ms = MaxCoordinates{}
+// BoundingBox stores the minimum and maximum path segment coordinates
+type BoundingBox struct {
+	MinX float32
+	MinY float32
+	MaxX float32
+	MaxY float32
+	set  bool // at least one segment has been recorded
+}
+
+// Empty reports if no segments have been recorded in the BoundingBox
+func (b *BoundingBox) Empty() bool {
+	return !b.set
+}
+
+// Add extends the BoundingBox to include a segment
+func (b *BoundingBox) Add(sg Segment) {
+	if !b.set {
+		*b = BoundingBox{MinX: sg.X, MinY: sg.Y, MaxX: sg.X, MaxY: sg.Y, set: true}
+		return
+	}
+	if sg.X < b.MinX {
+		b.MinX = sg.X
+	}
+	if sg.Y < b.MinY {
+		b.MinY = sg.Y
+	}
+	if sg.X > b.MaxX {
+		b.MaxX = sg.X
+	}
+	if sg.Y > b.MaxY {
+		b.MaxY = sg.Y
+	}
+}
 
 // RMParse instantiates a parser by registering a file to parse and
 // initialising the header, layer count and related counters. Continue
 // parsing using the "Parse()" iterator-type function.
-func RMParse(f fs.File) (*RMFile, error) {
+func RMParse(f io.Reader) (*RMFile, error) {
 
 	rm := &RMFile{}
 	rm.File = f
+	// buffer the many small reads of each segment
+	rm.reader = &countingReader{r: bufio.NewReader(f)}
 
-	headerLayers, err := HeaderParse(f)
+	headerLayers, err := HeaderParse(rm.reader)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("could not read header: %w", err)
 	}
 
 	rm.Header = headerLayers.Header
@@ -110,42 +218,75 @@ func RMParse(f fs.File) (*RMFile, error) {
 
 	// last byte is 0-terminated, chop it off
 	h := string(rm.Header[:len(rm.Header)-1])
-	if h != Header {
+	switch h {
+	case Header:
+		rm.Version = 5
+	case HeaderV3:
+		rm.Version = 3
+	default:
 		return nil, fmt.Errorf("Header %s does not match %s", h, Header)
 	}
 
-	if rm.LayerNo < 1 {
-		return nil, errors.New("Number of layers less than 1")
+	if rm.LayerNo < 1 || rm.LayerNo > MaxLayers {
+		return nil, &ParseError{
+			Kind:   ErrBadLayerCount,
+			Offset: int64(len(rm.Header)),
+			Err:    fmt.Errorf("number of layers %d not between 1 and %d", rm.LayerNo, MaxLayers),
+		}
 	}
 
 	// init counters
 	rm.ThisLayer = 1
 	rm.ThisPath = 1
+	rm.LayerBounds = make([]BoundingBox, rm.LayerNo)
 
 	return rm, nil
 }
 
+// record records the segment in the bounds of the file and the current
+// layer and in the maximum coordinates
+func (rm *RMFile) record(sg Segment) {
+	rm.Bounds.Add(sg)
+	rm.LayerBounds[rm.ThisLayer-1].Add(sg)
+	if sg.X > rm.MaxCoordinates.X {
+		rm.MaxCoordinates.X = sg.X
+	}
+	if sg.Y > rm.MaxCoordinates.Y {
+		rm.MaxCoordinates.Y = sg.Y
+	}
+}
+
 // Parse an .rm file, returning an RMPath data structure until depleted.
 // The Parse() function collects all the segments in a path and collects
 // it in an RMFile.Path struct, stored in RMFile.Path. The Parse
 // function is based loosely on bufio.Scan() so it may be called using
 // "for" as follows:
 //
-//    rm := rmparse.RMParse(filename)
-//    for rm.Parse() {
-//        path = rm.Path
-//    }
+//	rm := rmparse.RMParse(filename)
+//	for rm.Parse() {
+//	    path = rm.Path
+//	}
+//	if err := rm.Err(); err != nil {
+//	    // deal with the corrupt file
+//	}
 //
-// Catching io.ErrUnexpectedEOF errors is necessary because it is
-// possible to have corrupt .rm files which, for example, report layers
-// but do not have any content.
+// Parse returns false when the file is depleted or if an error occurs,
+// in which case the error is reported by Err. It is possible to have
+// corrupt .rm files which, for example, report layers but do not have
+// any content.
 func (rm *RMFile) Parse() bool {
 
+	if rm.err != nil {
+		return false
+	}
+
 	if rm.PathNo == 0 {
 		// find number of paths in this layer
-		paths, err := ParseLayers(rm.File)
+		offset := rm.reader.n
+		paths, err := ParseLayers(rm.reader)
 		if err != nil {
-			panic("Cannot determine number of paths")
+			rm.err = rm.parseError(ErrTruncatedLayer, offset, err)
+			return false
 		}
 
 		rm.PathNo = paths.Number
@@ -156,7 +297,6 @@ func (rm *RMFile) Parse() bool {
 
 	// complete processing
 	if rm.ThisPath > rm.PathNo && rm.ThisLayer == rm.LayerNo {
-		rm.MaxCoordinates = ms // record max segment in rm struct
 		return false
 	}
 
@@ -164,9 +304,11 @@ func (rm *RMFile) Parse() bool {
 	if rm.PathNo > 0 {
 
 		// get next path
-		path, err := ParsePath(rm.File)
+		offset := rm.reader.n
+		path, err := rm.parsePath()
 		if err != nil {
-			panic("ParsePath failed")
+			rm.err = rm.parseError(ErrTruncatedPath, offset, err)
+			return false
 		}
 
 		rm.Path.Layer = rm.ThisLayer
@@ -174,11 +316,15 @@ func (rm *RMFile) Parse() bool {
 
 		// retrieve segments
 		for s := 1; s <= int(rm.Path.Path.NumSegments); s++ {
-			segment, err := ParseSegment(rm.File)
+			offset := rm.reader.n
+			segment, err := ParseSegment(rm.reader)
 			if err != nil {
-				panic("ParseSegment failed")
+				rm.err = rm.parseError(ErrTruncatedSegment, offset, err)
+				rm.Path = RMPath{}
+				return false
 			}
 			rm.Path.Segments = append(rm.Path.Segments, segment)
+			rm.record(segment)
 		}
 
 	}
@@ -196,74 +342,89 @@ func (rm *RMFile) Parse() bool {
 	return true
 }
 
-// HeaderParse starts parsing an .rm file, returning the header and number of layers
-func HeaderParse(f fs.File) (HeaderLayers, error) {
+// Err returns the first error encountered by Parse, or nil if the file
+// was parsed successfully
+func (rm *RMFile) Err() error {
+	return rm.err
+}
 
-	hl := HeaderLayers{}
+// parseError makes a ParseError for the current layer and path
+func (rm *RMFile) parseError(kind error, offset int64, err error) *ParseError {
+	return &ParseError{
+		Kind:   kind,
+		Layer:  rm.ThisLayer,
+		Path:   rm.ThisPath,
+		Offset: offset,
+		Err:    err,
+	}
+}
 
-	err := binary.Read(f, binary.LittleEndian, &hl)
-	if err == io.ErrUnexpectedEOF {
-		return hl, err
-	} else if err != nil {
-		panic(err)
+// parsePath parses a path in the format of the file version
+func (rm *RMFile) parsePath() (Path, error) {
+	if rm.Version == 3 {
+		return ParsePathV3(rm.reader)
 	}
+	return ParsePath(rm.reader)
+}
 
-	return hl, nil
+// HeaderParse starts parsing an .rm file, returning the header and number of layers
+func HeaderParse(f io.Reader) (HeaderLayers, error) {
+
+	hl := HeaderLayers{}
+	err := binary.Read(f, binary.LittleEndian, &hl)
+	return hl, err
 }
 
 // ParseLayers returns the number of paths for each layer in the .rm
 // file
-func ParseLayers(f fs.File) (Paths, error) {
+func ParseLayers(f io.Reader) (Paths, error) {
 
 	pths := Paths{}
-
 	err := binary.Read(f, binary.LittleEndian, &pths)
-	if err == io.ErrUnexpectedEOF {
-		return pths, err
-	} else if err != nil {
-		panic(err)
-	}
-
-	return pths, nil
+	return pths, err
 }
 
 // ParsePath returns the path for each path in the layer.paths
-func ParsePath(f fs.File) (Path, error) {
+func ParsePath(f io.Reader) (Path, error) {
 
 	path := Path{}
-
 	err := binary.Read(f, binary.LittleEndian, &path)
-	if err == io.ErrUnexpectedEOF {
-		return path, err
-	} else if err != nil {
-		fmt.Printf("%T %+v", err, err)
-		panic(err)
-	}
+	return path, err
+}
 
-	return path, nil
+// ParsePathV3 returns the path for each path in the layer.paths of a
+// version 3 file
+func ParsePathV3(f io.Reader) (Path, error) {
+
+	p := PathV3{}
+	err := binary.Read(f, binary.LittleEndian, &p)
+	return Path{
+		Pen:         p.Pen,
+		Colour:      p.Colour,
+		Unknown1:    p.Unknown1,
+		Width:       p.Width,
+		NumSegments: p.NumSegments,
+	}, err
 }
 
 // ParseSegment returns the segment for each segment in a path
-func ParseSegment(f fs.File) (Segment, error) {
+func ParseSegment(f io.Reader) (Segment, error) {
 
 	sg := Segment{}
-
 	err := binary.Read(f, binary.LittleEndian, &sg)
-	if err == io.EOF {
-		return sg, err
-	} else if err == io.ErrUnexpectedEOF {
-		return sg, err
-	} else if err != nil {
-		panic(err)
-	}
+	return sg, err
+}
 
-	// record maximum segment coordinates
-	if sg.X > ms.X {
-		ms.X = sg.X
-	}
-	if sg.Y > ms.Y {
-		ms.Y = sg.Y
-	}
+// countingReader records the number of bytes read, to report the offset
+// of errors
+type countingReader struct {
+	r io.Reader
+	n int64
+}
 
-	return sg, nil
+// Read reads from the underlying reader, counting bytes read
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
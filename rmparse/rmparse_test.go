@@ -7,8 +7,11 @@ RCL January 2020
 package rmparse
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
-	// "fmt"
+	"path/filepath"
 	"testing"
 )
 
@@ -35,7 +38,7 @@ func TestRMParseA(t *testing.T) {
 	}
 
 	// fmt.Printf("%+v", lastPath)
-	// {Layer:1 Path:{Pen:17 Colour:0 _:0 Width:2 _:0 NumSegments:226} Segments:[... {X:1033.4183 Y:1429.1265 Pressure:0.33935595 Tilt:0.35699552 _:0 _:0}]}
+	// {Layer:1 Path:{Pen:17 Colour:0 _:0 Width:2 _:0 NumSegments:226} Segments:[... {X:1033.4183 Y:1429.1265 Speed:0.33935595 Direction:0.35699552 Width:4 Pressure:0.472628}]}
 	thisPath := RMPath{
 		Layer: 1,
 		Path: Path{
@@ -47,10 +50,12 @@ func TestRMParseA(t *testing.T) {
 		Segments: []Segment{
 			// only the last segment
 			{
-				X:        1033.4183,
-				Y:        1429.1265,
-				Pressure: 0.33935595,
-				Tilt:     0.35699552,
+				X:         1033.4183,
+				Y:         1429.1265,
+				Speed:     0.33935595,
+				Direction: 0.35699552,
+				Width:     4,
+				Pressure:  0.472628,
 			},
 		},
 	}
@@ -77,12 +82,18 @@ func TestRMParseA(t *testing.T) {
 	if Last(lastPath.Segments).Y != Last(thisPath.Segments).Y {
 		t.Errorf("Last(Segments).Y not %v", Last(thisPath.Segments).Y)
 	}
+	if Last(lastPath.Segments).Speed != Last(thisPath.Segments).Speed {
+		t.Errorf("Last(Segments).Speed not %v", Last(thisPath.Segments).Speed)
+	}
+	if Last(lastPath.Segments).Direction != Last(thisPath.Segments).Direction {
+		t.Errorf("Last(Segments).Direction not %v", Last(thisPath.Segments).Direction)
+	}
+	if Last(lastPath.Segments).Width != Last(thisPath.Segments).Width {
+		t.Errorf("Last(Segments).Width not %v", Last(thisPath.Segments).Width)
+	}
 	if Last(lastPath.Segments).Pressure != Last(thisPath.Segments).Pressure {
 		t.Errorf("Last(Segments).Pressure not %v", Last(thisPath.Segments).Pressure)
 	}
-	if Last(lastPath.Segments).Tilt != Last(thisPath.Segments).Tilt {
-		t.Errorf("Last(Segments).Tilt not %v", Last(thisPath.Segments).Tilt)
-	}
 
 	// fmt.Printf("%+v", rm)
 	// &{File:0xc00008c560 Header:[..] LayerNo:2 ThisLayer:2 PathNo:0 ThisPath:1 Path:{Layer:0 Path:{Pen:0 Colour:0 _:0 Width:0 _:0 NumSegments:0} Segments:[]} MaxCoordinates:{X:1404.1321 Y:1873.1632} Verbose:false}--- FAIL: TestMain (0.02s)
@@ -167,6 +178,64 @@ func TestRMParseEmptyLayers(t *testing.T) {
 
 }
 
+// TestRMParseV3 tests parsing a version 3 file, made from
+// 54abf601-2e54-44d3-85d6-17c8c1472ef0.rm by removing the second unknown
+// field from each path
+func TestRMParseV3(t *testing.T) {
+
+	parseAll := func(file string) (*RMFile, []RMPath) {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("Could not open file %v", err)
+		}
+		defer f.Close()
+		rm, err := RMParse(f)
+		if err != nil {
+			t.Fatalf("%s could not be setup for parsing: %v", file, err)
+		}
+		paths := []RMPath{}
+		for rm.Parse() {
+			paths = append(paths, rm.Path)
+		}
+		if err := rm.Err(); err != nil {
+			t.Fatalf("%s parse error %v", file, err)
+		}
+		return rm, paths
+	}
+
+	rm3, paths3 := parseAll("../testfiles/version3.rm")
+	rm5, paths5 := parseAll("../testfiles/54abf601-2e54-44d3-85d6-17c8c1472ef0.rm")
+
+	if rm3.Version != 3 || rm5.Version != 5 {
+		t.Errorf("versions %d and %d not 3 and 5", rm3.Version, rm5.Version)
+	}
+	if rm3.LayerNo != rm5.LayerNo {
+		t.Errorf("layers %d not %d", rm3.LayerNo, rm5.LayerNo)
+	}
+	if len(paths3) != len(paths5) {
+		t.Fatalf("paths %d not %d", len(paths3), len(paths5))
+	}
+	for i := range paths5 {
+		p3, p5 := paths3[i], paths5[i]
+		// the second unknown path field is not in version 3 files
+		p5.Path.Unknown2 = 0
+		if p3.Layer != p5.Layer || p3.Path != p5.Path {
+			t.Errorf("path %d layer/path %d/%+v not %d/%+v", i, p3.Layer, p3.Path, p5.Layer, p5.Path)
+		}
+		if len(p3.Segments) != len(p5.Segments) {
+			t.Fatalf("path %d segments %d not %d", i, len(p3.Segments), len(p5.Segments))
+		}
+		for j := range p5.Segments {
+			if p3.Segments[j] != p5.Segments[j] {
+				t.Errorf("path %d segment %d %+v not %+v", i, j, p3.Segments[j], p5.Segments[j])
+			}
+		}
+	}
+	if rm3.Bounds != rm5.Bounds {
+		t.Errorf("bounds %+v not %+v", rm3.Bounds, rm5.Bounds)
+	}
+}
+
 // TestRMParseV6RMFile tests for a remarkable version 3 file
 func TestRMParseV6RMFile(t *testing.T) {
 
@@ -182,3 +251,181 @@ func TestRMParseV6RMFile(t *testing.T) {
 		t.Errorf("expected error for v6 rm file")
 	}
 }
+
+// TestRMParseTruncated tests that truncated files report typed errors
+// rather than panicking
+func TestRMParseTruncated(t *testing.T) {
+
+	b, err := os.ReadFile("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		length int
+		kind   error
+		layer  uint32
+		path   uint32
+		offset int64
+	}{
+		// no path count for the first layer
+		{length: 47, kind: ErrTruncatedLayer, layer: 1, path: 1, offset: 47},
+		// first path record is incomplete
+		{length: 60, kind: ErrTruncatedPath, layer: 1, path: 1, offset: 51},
+		// first segment of the first path is incomplete
+		{length: 80, kind: ErrTruncatedSegment, layer: 1, path: 1, offset: 75},
+	} {
+		rm, err := RMParse(bytes.NewReader(b[:test.length]))
+		if err != nil {
+			t.Fatalf("length %d: unexpected setup error %v", test.length, err)
+		}
+		for rm.Parse() {
+		}
+		err = rm.Err()
+		if !errors.Is(err, test.kind) {
+			t.Fatalf("length %d: error %v is not %v", test.length, err, test.kind)
+		}
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Fatalf("length %d: error %T is not a ParseError", test.length, err)
+		}
+		if pe.Layer != test.layer || pe.Path != test.path || pe.Offset != test.offset {
+			t.Errorf(
+				"length %d: layer/path/offset %d/%d/%d not %d/%d/%d",
+				test.length, pe.Layer, pe.Path, pe.Offset, test.layer, test.path, test.offset,
+			)
+		}
+		if rm.Parse() {
+			t.Errorf("length %d: Parse should continue to return false after an error", test.length)
+		}
+	}
+
+	// truncating anywhere in the file should report an error
+	for l := 51; l < len(b); l += 997 {
+		rm, err := RMParse(bytes.NewReader(b[:l]))
+		if err != nil {
+			t.Fatalf("length %d: unexpected setup error %v", l, err)
+		}
+		for rm.Parse() {
+		}
+		if rm.Err() == nil {
+			t.Errorf("length %d: expected a parse error", l)
+		}
+	}
+
+	// a complete file reports no error
+	rm, err := RMParse(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rm.Parse() {
+	}
+	if rm.Err() != nil {
+		t.Errorf("unexpected error %v", rm.Err())
+	}
+}
+
+// TestRMParseBadLayerCount tests that a file reporting no layers errors
+func TestRMParseBadLayerCount(t *testing.T) {
+
+	b := append([]byte(Header), 0, 0, 0, 0, 0)
+	_, err := RMParse(bytes.NewReader(b))
+	if !errors.Is(err, ErrBadLayerCount) {
+		t.Errorf("error %v is not %v", err, ErrBadLayerCount)
+	}
+}
+
+// parseBounds parses an .rm file, returning the file bounds, layer
+// bounds and maximum coordinates
+func parseBounds(path string) (BoundingBox, []BoundingBox, MaxCoordinates, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return BoundingBox{}, nil, MaxCoordinates{}, err
+	}
+	defer f.Close()
+	rm, err := RMParse(f)
+	if err != nil {
+		return BoundingBox{}, nil, MaxCoordinates{}, err
+	}
+	for rm.Parse() {
+	}
+	return rm.Bounds, rm.LayerBounds, rm.MaxCoordinates, rm.Err()
+}
+
+// TestRMParseBounds tests the per-file and per-layer bounds
+func TestRMParseBounds(t *testing.T) {
+
+	bounds, layerBounds, mc, err := parseBounds("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bounds.Empty() {
+		t.Fatal("bounds should not be empty")
+	}
+	if bounds.MaxX != mc.X || bounds.MaxY != mc.Y {
+		t.Errorf("bounds max %f/%f not max coordinates %+v", bounds.MaxX, bounds.MaxY, mc)
+	}
+	if bounds.MinX > bounds.MaxX || bounds.MinY > bounds.MaxY {
+		t.Errorf("bounds min greater than max %+v", bounds)
+	}
+	if len(layerBounds) != 2 {
+		t.Fatalf("layer bounds %d not 2", len(layerBounds))
+	}
+	if layerBounds[0] != bounds {
+		t.Errorf("first layer bounds %+v should equal file bounds %+v", layerBounds[0], bounds)
+	}
+	if !layerBounds[1].Empty() {
+		t.Errorf("the second layer is empty and should have empty bounds")
+	}
+
+	// bounds are not shared between files
+	_, _, mc2, err := parseBounds("../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c/fa678373-8530-465d-a988-a0b158d957e4.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mc2 == mc {
+		t.Errorf("max coordinates %+v should differ between files", mc2)
+	}
+}
+
+// TestRMParseConcurrent parses the test bundle .rm files in parallel
+// and checks the results match parsing them sequentially. Run with
+// "go test -race" to detect data races.
+func TestRMParseConcurrent(t *testing.T) {
+
+	paths := []string{
+		"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm",
+		"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/7794dbce-2506-4fb0-99fd-9ec031426d57.rm",
+		"../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7/2c277cdb-79a5-4f69-b583-4901d944e77e.rm",
+		"../testfiles/e724bba2-266f-434d-aaf2-935d2b405aee/1a9ef8e1-8009-4c84-bbe8-ba2885a137e6.rm",
+		"../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c/fa678373-8530-465d-a988-a0b158d957e4.rm",
+		"../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c/0b8b6e65-926c-4269-9109-36fca8718c94.rm",
+		"../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c/e2a69ab6-5c11-42d1-8d2d-9ce6569d9fdf.rm",
+		"../testfiles/54abf601-2e54-44d3-85d6-17c8c1472ef0.rm",
+	}
+
+	expected := map[string]BoundingBox{}
+	for _, p := range paths {
+		b, _, _, err := parseBounds(p)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		expected[p] = b
+	}
+
+	for i := 0; i < 4; i++ {
+		for _, p := range paths {
+			p := p
+			t.Run(fmt.Sprintf("%d/%s", i, filepath.Base(p)), func(t *testing.T) {
+				t.Parallel()
+				b, _, _, err := parseBounds(p)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if b != expected[p] {
+					t.Errorf("bounds %+v not %+v", b, expected[p])
+				}
+			})
+		}
+	}
+}
@@ -1,259 +1,1071 @@
 /*
-From ddvk's work at https://github.com/ddvk/reader, licence not known.
+Parser for reMarkable tablet version 6 "lines" or ".rm" files, as
+written by reMarkable software version 3 and later.
 
+Version 6 files are a stream of tagged blocks describing a CRDT scene
+tree rather than a simple list of layers and paths. The format
+description is drawn from the reverse engineering work at
+https://github.com/ricklupton/rmscene and ddvk's work at
+https://github.com/ddvk/reader.
+
+Each block has an 8 byte header (length, unknown, minimum version,
+current version, block type) followed by the block data. Values within
+a block are tagged with a varuint recording the field index and the
+value type. Layers are scene groups whose parent is the root node of the
+scene tree, and lines are scene items whose parent is a layer or a group
+within a layer. The children of each node form a CRDT sequence, in
+which each item records its neighbours when it was inserted; as in
+rmscene, the items are ordered by sorting these topologically, and
+items with no order between them by id, to give the order in which
+layers, groups and lines are drawn.
+
+The parser reads the whole file on RMParse and then provides the same
+python-like iterator based on bufio.Scan as the version 5 parser in
+rmparse, returning rmparse.RMPath data structures so that version 6
+pages can be drawn in the same way as version 5 pages. Version 6 x
+coordinates are centred on the page; these are moved to the version 5
+origin at the top left of the page. The thickness scale of each line is
+reported as the nearest of the version 5 pen widths, 1.875, 2.0 and
+2.125, by which pens are chosen as narrow, standard or broad.
+
+MIT licensed, please see LICENCE
 RCL February 2022
 */
 
-package rmparse
+package rmparsev6
 
 import (
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
-	"log"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/rorycl/rm2pdf/rmparse"
 )
 
 // Header is an rm file header
-var Header = "reMarkable .lines file, version=6         "
+var Header = "reMarkable .lines file, version=6"
+
+// XOffset is the offset added to version 6 x coordinates to move the
+// origin from the top centre of the page to the top left, in line with
+// version 5 files. The tablet is 1404 pixels wide.
+const XOffset = 1404 / 2
+
+// Block types
+const (
+	migrationInfoBlock  = 0x00
+	sceneTreeBlock      = 0x01
+	treeNodeBlock       = 0x02
+	sceneGlyphItemBlock = 0x03
+	sceneGroupItemBlock = 0x04
+	sceneLineItemBlock  = 0x05
+	sceneTextItemBlock  = 0x06
+	rootTextBlock       = 0x07
+	sceneTombstoneBlock = 0x08
+	authorIDsBlock      = 0x09
+	pageInfoBlock       = 0x0a
+	sceneInfoBlock      = 0x0d
+)
+
+// Scene item types, point sizes by block version and block limits
+const (
+	sceneItemTypeGroup     = 0x02
+	sceneItemTypeLine      = 0x03
+	pointSizeV1            = 0x18
+	pointSizeV2            = 0x0e
+	blockHeaderLength      = 8
+	maxReasonableBlockSize = 1 << 26
+)
+
+// tag types
+const (
+	tagByte1   = 0x1
+	tagByte4   = 0x4
+	tagByte8   = 0x8
+	tagLength4 = 0xc
+	tagID      = 0xf
+)
+
+// CrdtID identifies an item in the scene tree
+type CrdtID struct {
+	Part1 uint8
+	Part2 uint64
+}
+
+// String returns a string representation of a CrdtID
+func (c CrdtID) String() string {
+	return fmt.Sprintf("%d:%d", c.Part1, c.Part2)
+}
+
+// rootID is the id of the root node of the scene tree
+var rootID = CrdtID{0, 1}
+
+// BlockHeader describes the header of each block in the file
+// format <IBBBB
+type BlockHeader struct {
+	Length         uint32
+	Unknown        uint8
+	MinVersion     uint8
+	CurrentVersion uint8
+	BlockType      uint8
+}
 
-// RMFile is the reMarkable .rm file File parser metadata base structure
+// PageInfo records counters from the page information block
+type PageInfo struct {
+	LoadsCount     uint32
+	MergesCount    uint32
+	TextCharsCount uint32
+	TextLinesCount uint32
+}
+
+// MigrationInfo records the migration information block
+type MigrationInfo struct {
+	ID       CrdtID
+	IsDevice bool
+}
+
+// Line is a version 6 line (a stroke) with its points
+type Line struct {
+	ID             CrdtID
+	Tool           uint32
+	Colour         uint32
+	ThicknessScale float64
+	StartingLength float32
+	Points         []Point
+}
+
+// Point is a version 6 point. Pressure is recorded as a fraction of 1
+// and Direction in radians to match the version 5 segment values.
+type Point struct {
+	X         float32
+	Y         float32
+	Speed     float32
+	Direction float32
+	Width     float32
+	Pressure  float32
+}
+
+// Layer is a top level group in the scene tree
+type Layer struct {
+	ID      CrdtID
+	Name    string
+	Visible bool
+	Lines   []Line
+}
+
+// Text is the root text block of a page, if any
+type Text struct {
+	X     float64
+	Y     float64
+	Width float32
+	Text  string
+}
+
+// RMFile is the reMarkable version 6 .rm file parser metadata base
+// structure. The exported Layer, Path and counter fields mirror those in
+// rmparse.RMFile.
 type RMFile struct {
-	File           fs.File
 	Header         [43]byte
 	LayerNo        uint32
 	ThisLayer      uint32
 	PathNo         uint32
 	ThisPath       uint32
-	Path           RMPath
-	MaxCoordinates MaxCoordinates
+	Path           rmparse.RMPath
+	MaxCoordinates rmparse.MaxCoordinates
+	Bounds         rmparse.BoundingBox   // bounds of all the segments in the file
+	LayerBounds    []rmparse.BoundingBox // bounds of the segments by 0-indexed layer
 	Verbose        bool
+
+	AuthorIDs     map[uint16]string
+	MigrationInfo MigrationInfo
+	PageInfo      PageInfo
+	Layers        []Layer
+	Text          *Text
+
+	// scene tree construction
+	nodeParents map[CrdtID]CrdtID
+	nodeOrder   []CrdtID
+	nodes       map[CrdtID]*Layer
+	items       map[CrdtID][]sequenceItem // group and line items by parent
+}
+
+// sequenceItem is a group or line in the CRDT sequence of the children
+// of a scene tree node. Deleted items are kept, as the neighbours of
+// other items.
+type sequenceItem struct {
+	ID      CrdtID
+	Left    CrdtID
+	Right   CrdtID
+	Deleted bool
+	Group   *CrdtID // the node id of a group
+	Line    *Line
+}
+
+// RMParse instantiates a parser by reading the version 6 file in its
+// entirety, constructing the layers of the scene tree and initialising
+// the layer count and related counters. Continue parsing using the
+// "Parse()" iterator-type function.
+func RMParse(f io.Reader) (*RMFile, error) {
+
+	rm := &RMFile{
+		AuthorIDs:   map[uint16]string{},
+		nodeParents: map[CrdtID]CrdtID{},
+		nodes:       map[CrdtID]*Layer{},
+		items:       map[CrdtID][]sequenceItem{},
+	}
+
+	_, err := io.ReadFull(f, rm.Header[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not read header: %w", err)
+	}
+	h := strings.TrimRight(string(rm.Header[:]), " \x00")
+	if h != Header {
+		return nil, fmt.Errorf("Header %s does not match %s", h, Header)
+	}
+
+	offset := int64(len(rm.Header))
+	for {
+		bh := BlockHeader{}
+		err := binary.Read(f, binary.LittleEndian, &bh)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("block header at offset %d: %w", offset, err)
+		}
+		if bh.Length > maxReasonableBlockSize {
+			return nil, fmt.Errorf("block at offset %d has invalid length %d", offset, bh.Length)
+		}
+		data := make([]byte, bh.Length)
+		_, err = io.ReadFull(f, data)
+		if err != nil {
+			return nil, fmt.Errorf("block type %d at offset %d truncated: %w", bh.BlockType, offset, err)
+		}
+		ds := &dataStream{
+			buf:     data,
+			offset:  offset + blockHeaderLength,
+			version: bh.CurrentVersion,
+		}
+		err = rm.parseBlock(bh, ds)
+		if err != nil {
+			return nil, fmt.Errorf("block type %d at offset %d: %w", bh.BlockType, offset, err)
+		}
+		offset += blockHeaderLength + int64(bh.Length)
+	}
+
+	rm.buildLayers()
+	if len(rm.Layers) < 1 {
+		return nil, errors.New("Number of layers less than 1")
+	}
+
+	// init counters
+	rm.LayerNo = uint32(len(rm.Layers))
+	rm.ThisLayer = 1
+	rm.ThisPath = 1
+	rm.PathNo = uint32(len(rm.Layers[0].Lines))
+	rm.LayerBounds = make([]rmparse.BoundingBox, rm.LayerNo)
+
+	return rm, nil
 }
 
-// RMPath is the reMarkable parsed data structure, returned by Parse()
-type RMPath struct {
-	Layer    uint32
-	Path     Path
-	Segments []Segment
+// parseBlock parses the known block types; unknown blocks and any
+// unparsed trailing data in known blocks are ignored
+func (rm *RMFile) parseBlock(bh BlockHeader, ds *dataStream) error {
+	switch bh.BlockType {
+	case authorIDsBlock:
+		return rm.parseAuthorIDs(ds)
+	case migrationInfoBlock:
+		return rm.parseMigrationInfo(ds)
+	case pageInfoBlock:
+		return rm.parsePageInfo(ds)
+	case sceneTreeBlock:
+		return rm.parseSceneTree(ds)
+	case treeNodeBlock:
+		return rm.parseTreeNode(ds)
+	case sceneGroupItemBlock:
+		return rm.parseGroupItem(ds)
+	case sceneLineItemBlock:
+		return rm.parseLineItem(ds)
+	case rootTextBlock:
+		return rm.parseRootText(ds)
+	}
+	return nil
 }
 
-// HeaderLayers is the header and number of layers
-// format <{}sI
-type HeaderLayers struct {
-	Header [43]byte
-	Layers uint32
+// parseAuthorIDs parses the author uuid to author id mapping
+func (rm *RMFile) parseAuthorIDs(ds *dataStream) error {
+	n, err := ds.varUint()
+	if err != nil {
+		return err
+	}
+	for i := uint64(0); i < n; i++ {
+		sb, err := ds.subBlock(0)
+		if err != nil {
+			return err
+		}
+		l, err := sb.varUint()
+		if err != nil {
+			return err
+		}
+		u, err := sb.bytes(int(l))
+		if err != nil {
+			return err
+		}
+		id, err := sb.uint16()
+		if err != nil {
+			return err
+		}
+		rm.AuthorIDs[id] = formatUUID(u)
+	}
+	return nil
 }
 
-// Paths is the number of paths in this layer
-// format <I
-type Paths struct {
-	Number uint32
+// parseMigrationInfo parses the migration information block
+func (rm *RMFile) parseMigrationInfo(ds *dataStream) (err error) {
+	rm.MigrationInfo.ID, err = ds.taggedID(1)
+	if err != nil {
+		return err
+	}
+	rm.MigrationInfo.IsDevice, err = ds.taggedBool(2)
+	return err
 }
 
-// Path describes a path
-// format <IIIfII
-type Path struct {
-	Pen         uint32
-	Colour      uint32
-	_           uint32 // unknown
-	Width       float32
-	_           uint32 // unknown
-	NumSegments uint32
+// parsePageInfo parses the page information block
+func (rm *RMFile) parsePageInfo(ds *dataStream) error {
+	for i, v := range []*uint32{
+		&rm.PageInfo.LoadsCount,
+		&rm.PageInfo.MergesCount,
+		&rm.PageInfo.TextCharsCount,
+		&rm.PageInfo.TextLinesCount,
+	} {
+		n, err := ds.taggedUint32(i + 1)
+		if err != nil {
+			return err
+		}
+		*v = n
+	}
+	return nil
 }
 
-// Segment describes a path segment
-// format <ffffff
-type Segment struct {
-	X        float32
-	Y        float32
-	Pressure float32
-	Tilt     float32
-	_        float32 // unknown
-	_        float32 // unknown
+// parseSceneTree records the parent of each node in the scene tree
+func (rm *RMFile) parseSceneTree(ds *dataStream) error {
+	treeID, err := ds.taggedID(1)
+	if err != nil {
+		return err
+	}
+	if _, err = ds.taggedID(2); err != nil { // node id
+		return err
+	}
+	if _, err = ds.taggedBool(3); err != nil { // is update
+		return err
+	}
+	sb, err := ds.subBlock(4)
+	if err != nil {
+		return err
+	}
+	parentID, err := sb.taggedID(1)
+	if err != nil {
+		return err
+	}
+	if _, ok := rm.nodeParents[treeID]; !ok {
+		rm.nodeOrder = append(rm.nodeOrder, treeID)
+	}
+	rm.nodeParents[treeID] = parentID
+	return nil
 }
 
-// MaxCoordinates stores the maximum and minimum path segments
-type MaxCoordinates struct {
-	X float32
-	Y float32
+// parseTreeNode records the label and visibility of a scene tree node
+func (rm *RMFile) parseTreeNode(ds *dataStream) error {
+	nodeID, err := ds.taggedID(1)
+	if err != nil {
+		return err
+	}
+	label, err := ds.lwwString(2)
+	if err != nil {
+		return err
+	}
+	visible, err := ds.lwwBool(3)
+	if err != nil {
+		return err
+	}
+	rm.nodes[nodeID] = &Layer{ID: nodeID, Name: label, Visible: visible}
+	return nil
 }
 
-var ms = MaxCoordinates{}
+// sceneItem is the common header of scene item blocks
+type sceneItem struct {
+	parentID      CrdtID
+	itemID        CrdtID
+	leftID        CrdtID
+	rightID       CrdtID
+	deletedLength uint32
+	value         *dataStream // nil if the item has no value
+}
 
-// RMParse instantiates a parser by registering a file to parse and
-// initialising the header, layer count and related counters. Continue
-// parsing using the "Parse()" iterator-type function.
-func RMParse(f fs.File) (*RMFile, error) {
+// sceneItem parses the common header of scene item blocks
+func (ds *dataStream) sceneItem(itemType uint8) (si sceneItem, err error) {
+	if si.parentID, err = ds.taggedID(1); err != nil {
+		return
+	}
+	if si.itemID, err = ds.taggedID(2); err != nil {
+		return
+	}
+	if si.leftID, err = ds.taggedID(3); err != nil {
+		return
+	}
+	if si.rightID, err = ds.taggedID(4); err != nil {
+		return
+	}
+	if si.deletedLength, err = ds.taggedUint32(5); err != nil {
+		return
+	}
+	if !ds.hasTag(6, tagLength4) {
+		return
+	}
+	if si.value, err = ds.subBlock(6); err != nil {
+		return
+	}
+	var t uint8
+	if t, err = si.value.uint8(); err != nil {
+		return
+	}
+	if t != itemType {
+		err = fmt.Errorf("item type %d is not the expected type %d", t, itemType)
+	}
+	return
+}
 
-	rm := &RMFile{}
-	rm.File = f
+// addItem records a group or line item in the sequence of its parent
+func (rm *RMFile) addItem(si sceneItem, item sequenceItem) {
+	item.ID, item.Left, item.Right = si.itemID, si.leftID, si.rightID
+	item.Deleted = si.value == nil || si.deletedLength > 0
+	rm.items[si.parentID] = append(rm.items[si.parentID], item)
+}
 
-	headerLayers, err := HeaderParse(f)
+// parseGroupItem records a group in the sequence of its parent; groups
+// under the root node are layers
+func (rm *RMFile) parseGroupItem(ds *dataStream) error {
+	si, err := ds.sceneItem(sceneItemTypeGroup)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	if si.value == nil || si.deletedLength > 0 {
+		rm.addItem(si, sequenceItem{})
+		return nil
+	}
+	nodeID, err := si.value.taggedID(2)
+	if err != nil {
+		return err
+	}
+	rm.addItem(si, sequenceItem{Group: &nodeID})
+	return nil
+}
 
-	rm.Header = headerLayers.Header
-	rm.LayerNo = headerLayers.Layers
+// parseLineItem parses a line and its points
+func (rm *RMFile) parseLineItem(ds *dataStream) error {
+	si, err := ds.sceneItem(sceneItemTypeLine)
+	if err != nil {
+		return err
+	}
+	if si.value == nil || si.deletedLength > 0 {
+		rm.addItem(si, sequenceItem{})
+		return nil
+	}
+	v := si.value
+	line := Line{ID: si.itemID}
+	if line.Tool, err = v.taggedUint32(1); err != nil {
+		return err
+	}
+	if line.Colour, err = v.taggedUint32(2); err != nil {
+		return err
+	}
+	if line.ThicknessScale, err = v.taggedFloat64(3); err != nil {
+		return err
+	}
+	if line.StartingLength, err = v.taggedFloat32(4); err != nil {
+		return err
+	}
+	pts, err := v.subBlock(5)
+	if err != nil {
+		return err
+	}
+	line.Points, err = pts.points()
+	if err != nil {
+		return err
+	}
+	rm.addItem(si, sequenceItem{Line: &line})
+	return nil
+}
 
-	// last byte is 0-terminated, chop it off
-	h := string(rm.Header[:len(rm.Header)-1])
-	if h != Header {
-		return nil, fmt.Errorf("Header %s does not match %s", h, Header)
+// parseRootText parses the text of a page. Formatting information is
+// ignored.
+func (rm *RMFile) parseRootText(ds *dataStream) error {
+	if _, err := ds.taggedID(1); err != nil {
+		return err
+	}
+	outer, err := ds.subBlock(2)
+	if err != nil {
+		return err
+	}
+	middle, err := outer.subBlock(1)
+	if err != nil {
+		return err
+	}
+	items, err := middle.subBlock(1)
+	if err != nil {
+		return err
+	}
+	n, err := items.varUint()
+	if err != nil {
+		return err
+	}
+	var sb strings.Builder
+	for i := uint64(0); i < n; i++ {
+		item, err := items.subBlock(0)
+		if err != nil {
+			return err
+		}
+		for j := 2; j <= 4; j++ { // item, left and right ids
+			if _, err := item.taggedID(j); err != nil {
+				return err
+			}
+		}
+		deleted, err := item.taggedUint32(5)
+		if err != nil {
+			return err
+		}
+		if deleted > 0 || !item.hasTag(6, tagLength4) {
+			continue
+		}
+		s, err := item.subBlock(6)
+		if err != nil {
+			return err
+		}
+		str, err := s.string()
+		if err != nil {
+			return err
+		}
+		sb.WriteString(str)
 	}
 
-	if rm.LayerNo < 1 {
-		return nil, errors.New("Number of layers less than 1")
+	pos, err := ds.subBlock(3)
+	if err != nil {
+		return err
+	}
+	t := Text{Text: sb.String()}
+	if t.X, err = pos.float64(); err != nil {
+		return err
 	}
+	if t.Y, err = pos.float64(); err != nil {
+		return err
+	}
+	if t.Width, err = ds.taggedFloat32(4); err != nil {
+		return err
+	}
+	rm.Text = &t
+	return nil
+}
 
-	// init counters
-	rm.ThisLayer = 1
-	rm.ThisPath = 1
+// topLayer finds the layer (the child of the root node) for a node
+func (rm *RMFile) topLayer(id CrdtID) (CrdtID, bool) {
+	for i := 0; i < len(rm.nodeParents)+1; i++ {
+		parent, ok := rm.nodeParents[id]
+		if !ok {
+			return id, false
+		}
+		if parent == rootID {
+			return id, true
+		}
+		id = parent
+	}
+	return id, false
+}
+
+// buildLayers assembles lines into layers in the order of the layers
+// under the root node. The lines of each layer, and of the groups within
+// it, are added in sequence order, so that they are drawn in the order
+// shown on the tablet. The lines of groups which are not in the
+// sequence of their parent are added to their enclosing layer after
+// these, in the order the groups were declared in the scene tree.
+func (rm *RMFile) buildLayers() {
+	added := map[CrdtID]bool{} // nodes whose lines have been added
+
+	// lines adds the lines of a node and the groups within it
+	var lines func(id CrdtID) []Line
+	lines = func(id CrdtID) []Line {
+		if added[id] {
+			return nil // a loop of groups
+		}
+		added[id] = true
+		ls := []Line{}
+		for _, item := range orderItems(rm.items[id]) {
+			switch {
+			case item.Deleted:
+			case item.Line != nil:
+				ls = append(ls, *item.Line)
+			case item.Group != nil:
+				ls = append(ls, lines(*item.Group)...)
+			}
+		}
+		return ls
+	}
+
+	layerIndex := map[CrdtID]int{}
+	added[rootID] = true
+	for _, item := range orderItems(rm.items[rootID]) {
+		if item.Deleted || item.Group == nil {
+			continue
+		}
+		id := *item.Group
+		if _, ok := layerIndex[id]; ok {
+			continue
+		}
+		layer := Layer{ID: id, Visible: true}
+		if node, ok := rm.nodes[id]; ok {
+			layer = *node
+		}
+		layer.Lines = lines(id)
+		layerIndex[id] = len(rm.Layers)
+		rm.Layers = append(rm.Layers, layer)
+	}
+
+	for _, id := range rm.nodeOrder {
+		if added[id] {
+			continue
+		}
+		layerID, ok := rm.topLayer(id)
+		if !ok {
+			continue
+		}
+		i, ok := layerIndex[layerID]
+		if !ok {
+			continue
+		}
+		rm.Layers[i].Lines = append(rm.Layers[i].Lines, lines(id)...)
+	}
+}
+
+// sequenceKey is an item, or the start or end, of a CRDT sequence
+type sequenceKey struct {
+	id   CrdtID
+	edge int // -1 for the start, 1 for the end, otherwise 0
+}
+
+// endMarker is the left id of the first item in a sequence, and the
+// right id of the last
+var endMarker = CrdtID{0, 0}
+
+// orderItems orders the items of a CRDT sequence, as in rmscene, by
+// sorting them topologically so that each item comes after the item to
+// its left and before the item to its right. Items whose order is not
+// fixed in this way are sorted by id. Any loop is broken by adding the
+// remaining items by id.
+func orderItems(items []sequenceItem) []sequenceItem {
 
-	return rm, errors.New("rm file version 6 parsing not supported")
+	byID := map[CrdtID]sequenceItem{}
+	before := map[sequenceKey]map[sequenceKey]bool{} // keys which must come before each key
+	add := func(k, b sequenceKey) {
+		if before[k] == nil {
+			before[k] = map[sequenceKey]bool{}
+		}
+		if before[b] == nil {
+			before[b] = map[sequenceKey]bool{}
+		}
+		if k != b {
+			before[k][b] = true
+		}
+	}
+	for _, item := range items {
+		byID[item.ID] = item
+		k := sequenceKey{id: item.ID}
+		left, right := sequenceKey{id: item.Left}, sequenceKey{id: item.Right}
+		if item.Left == endMarker {
+			left = sequenceKey{edge: -1}
+		}
+		if item.Right == endMarker {
+			right = sequenceKey{edge: 1}
+		}
+		add(k, left)
+		add(right, k)
+	}
+
+	ordered := []sequenceItem{}
+	for len(before) > 0 {
+		ready := []sequenceKey{}
+		for k, b := range before {
+			if len(b) == 0 {
+				ready = append(ready, k)
+			}
+		}
+		if len(ready) == 0 {
+			for k := range before {
+				ready = append(ready, k)
+			}
+		}
+		sort.Slice(ready, func(i, j int) bool {
+			a, b := ready[i], ready[j]
+			if a.edge != b.edge {
+				return a.edge < b.edge
+			}
+			if a.id.Part1 != b.id.Part1 {
+				return a.id.Part1 < b.id.Part1
+			}
+			return a.id.Part2 < b.id.Part2
+		})
+		for _, k := range ready {
+			delete(before, k)
+		}
+		for _, b := range before {
+			for _, k := range ready {
+				delete(b, k)
+			}
+		}
+		for _, k := range ready {
+			if item, ok := byID[k.id]; ok && k.edge == 0 {
+				ordered = append(ordered, item)
+			}
+		}
+	}
+	return ordered
 }
 
-// Parse an .rm file, returning an RMPath data structure until depleted.
-// The Parse() function collects all the segments in a path and collects
-// it in an RMFile.Path struct, stored in RMFile.Path. The Parse
-// function is based loosely on bufio.Scan() so it may be called using
-// "for" as follows:
+// Parse a version 6 .rm file, returning an RMPath data structure until
+// depleted, in the same manner as rmparse.RMFile.Parse:
 //
-//	rm := rmparse.RMParse(filename)
+//	rm, err := rmparsev6.RMParse(f)
 //	for rm.Parse() {
 //	    path = rm.Path
 //	}
-//
-// Catching io.ErrUnexpectedEOF errors is necessary because it is
-// possible to have corrupt .rm files which, for example, report layers
-// but do not have any content.
 func (rm *RMFile) Parse() bool {
 
-	if rm.PathNo == 0 {
-		// find number of paths in this layer
-		paths, err := ParseLayers(rm.File)
-		if err != nil {
-			log.Print("Cannot determine number of paths")
-			return false
-		}
+	rm.Path = rmparse.RMPath{}
 
-		rm.PathNo = paths.Number
+	// skip empty layers
+	for rm.ThisPath > rm.PathNo && rm.ThisLayer < rm.LayerNo {
+		rm.ThisLayer++
+		rm.ThisPath = 1
+		rm.PathNo = uint32(len(rm.Layers[rm.ThisLayer-1].Lines))
 	}
 
-	// init return structure
-	rm.Path = RMPath{}
-
 	// complete processing
-	if rm.ThisPath > rm.PathNo && rm.ThisLayer == rm.LayerNo {
-		rm.MaxCoordinates = ms // record max segment in rm struct
+	if rm.ThisPath > rm.PathNo {
 		return false
 	}
 
-	// some layers are empty
-	if rm.PathNo > 0 {
-
-		// get next path
-		path, err := ParsePath(rm.File)
-		if err != nil {
-			panic("ParsePath failed")
+	line := rm.Layers[rm.ThisLayer-1].Lines[rm.ThisPath-1]
+	rm.Path.Layer = rm.ThisLayer
+	rm.Path.Path = rmparse.Path{
+		Pen:         line.Tool,
+		Colour:      line.Colour,
+		Width:       pathWidth(line.ThicknessScale),
+		NumSegments: uint32(len(line.Points)),
+	}
+	for _, p := range line.Points {
+		sg := rmparse.Segment{
+			X:        p.X + XOffset,
+			Y:        p.Y,
+			Pressure: p.Pressure,
+			Width:    p.Width,
+		}
+		rm.Bounds.Add(sg)
+		rm.LayerBounds[rm.ThisLayer-1].Add(sg)
+		if sg.X > rm.MaxCoordinates.X {
+			rm.MaxCoordinates.X = sg.X
 		}
+		if sg.Y > rm.MaxCoordinates.Y {
+			rm.MaxCoordinates.Y = sg.Y
+		}
+		rm.Path.Segments = append(rm.Path.Segments, sg)
+	}
 
-		rm.Path.Layer = rm.ThisLayer
-		rm.Path.Path = path
+	rm.ThisPath++
+	return true
+}
 
-		// retrieve segments
-		for s := 1; s <= int(rm.Path.Path.NumSegments); s++ {
-			segment, err := ParseSegment(rm.File)
-			if err != nil {
-				panic("ParseSegment failed")
-			}
-			rm.Path.Segments = append(rm.Path.Segments, segment)
+// pathWidths are the version 5 widths of narrow, standard and broad
+// pens
+var pathWidths = []float32{1.875, 2.0, 2.125}
+
+// pathWidth maps the thickness scale of a line onto the nearest of the
+// version 5 pen widths
+func pathWidth(scale float64) float32 {
+	width := pathWidths[0]
+	for _, w := range pathWidths[1:] {
+		if math.Abs(scale-float64(w)) < math.Abs(scale-float64(width)) {
+			width = w
 		}
+	}
+	return width
+}
+
+// dataStream reads values from the data of a single block or subblock
+type dataStream struct {
+	buf     []byte
+	pos     int
+	offset  int64 // file offset of buf[0], for error reporting
+	version uint8 // current version of the enclosing block
+}
+
+// errorf reports an error with the current file offset
+func (ds *dataStream) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("offset %d: %s", ds.offset+int64(ds.pos), fmt.Sprintf(format, a...))
+}
+
+// remaining reports the number of unread bytes
+func (ds *dataStream) remaining() int {
+	return len(ds.buf) - ds.pos
+}
 
+func (ds *dataStream) bytes(n int) ([]byte, error) {
+	if n < 0 || ds.remaining() < n {
+		return nil, ds.errorf("%d bytes requested, %d remaining: %s", n, ds.remaining(), io.ErrUnexpectedEOF)
 	}
+	b := ds.buf[ds.pos : ds.pos+n]
+	ds.pos += n
+	return b, nil
+}
 
-	// increment path counter
-	rm.ThisPath++
+func (ds *dataStream) uint8() (uint8, error) {
+	b, err := ds.bytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
 
-	// advance to next layer if necessary
-	if rm.ThisPath > rm.PathNo && rm.ThisLayer < rm.LayerNo {
-		rm.ThisLayer++
-		rm.ThisPath = 1
-		rm.PathNo = 0
+func (ds *dataStream) uint16() (uint16, error) {
+	b, err := ds.bytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (ds *dataStream) uint32() (uint32, error) {
+	b, err := ds.bytes(4)
+	if err != nil {
+		return 0, err
 	}
+	return binary.LittleEndian.Uint32(b), nil
+}
 
-	return true
+func (ds *dataStream) float32() (float32, error) {
+	u, err := ds.uint32()
+	return math.Float32frombits(u), err
 }
 
-// HeaderParse starts parsing an .rm file, returning the header and number of layers
-func HeaderParse(f fs.File) (HeaderLayers, error) {
+func (ds *dataStream) float64() (float64, error) {
+	b, err := ds.bytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+}
 
-	hl := HeaderLayers{}
+// varUint reads a little endian base 128 varuint
+func (ds *dataStream) varUint() (uint64, error) {
+	v, n := binary.Uvarint(ds.buf[ds.pos:])
+	if n <= 0 {
+		return 0, ds.errorf("invalid varuint")
+	}
+	ds.pos += n
+	return v, nil
+}
 
-	err := binary.Read(f, binary.LittleEndian, &hl)
-	if err == io.ErrUnexpectedEOF {
-		return hl, err
-	} else if err != nil {
-		panic(err)
+// id reads an untagged CrdtID
+func (ds *dataStream) id() (CrdtID, error) {
+	p1, err := ds.uint8()
+	if err != nil {
+		return CrdtID{}, err
 	}
+	p2, err := ds.varUint()
+	if err != nil {
+		return CrdtID{}, err
+	}
+	return CrdtID{p1, p2}, nil
+}
 
-	return hl, nil
+// peekTag reports the index and type of the next tag without advancing
+func (ds *dataStream) peekTag() (int, uint8, bool) {
+	if ds.remaining() == 0 {
+		return 0, 0, false
+	}
+	v, n := binary.Uvarint(ds.buf[ds.pos:])
+	if n <= 0 {
+		return 0, 0, false
+	}
+	return int(v >> 4), uint8(v & 0xf), true
 }
 
-// ParseLayers returns the number of paths for each layer in the .rm
-// file
-func ParseLayers(f fs.File) (Paths, error) {
+// hasTag reports if the next tag is of the given index and type
+func (ds *dataStream) hasTag(index int, tagType uint8) bool {
+	i, t, ok := ds.peekTag()
+	return ok && i == index && t == tagType
+}
 
-	pths := Paths{}
+// tag reads a tag, checking that it has the expected index and type
+func (ds *dataStream) tag(index int, tagType uint8) error {
+	i, t, ok := ds.peekTag()
+	if !ok {
+		return ds.errorf("expected tag %d type %x: %s", index, tagType, io.ErrUnexpectedEOF)
+	}
+	if i != index || t != tagType {
+		return ds.errorf("expected tag %d type %x, got tag %d type %x", index, tagType, i, t)
+	}
+	_, err := ds.varUint()
+	return err
+}
 
-	err := binary.Read(f, binary.LittleEndian, &pths)
-	if err == io.ErrUnexpectedEOF {
-		return pths, err
-	} else if err != nil {
-		panic(err)
+func (ds *dataStream) taggedID(index int) (CrdtID, error) {
+	if err := ds.tag(index, tagID); err != nil {
+		return CrdtID{}, err
 	}
+	return ds.id()
+}
 
-	return pths, nil
+func (ds *dataStream) taggedBool(index int) (bool, error) {
+	if err := ds.tag(index, tagByte1); err != nil {
+		return false, err
+	}
+	b, err := ds.uint8()
+	return b != 0, err
 }
 
-// ParsePath returns the path for each path in the layer.paths
-func ParsePath(f fs.File) (Path, error) {
+func (ds *dataStream) taggedUint32(index int) (uint32, error) {
+	if err := ds.tag(index, tagByte4); err != nil {
+		return 0, err
+	}
+	return ds.uint32()
+}
 
-	path := Path{}
+func (ds *dataStream) taggedFloat32(index int) (float32, error) {
+	if err := ds.tag(index, tagByte4); err != nil {
+		return 0, err
+	}
+	return ds.float32()
+}
 
-	err := binary.Read(f, binary.LittleEndian, &path)
-	if err == io.ErrUnexpectedEOF {
-		return path, err
-	} else if err != nil {
-		fmt.Printf("%T %+v", err, err)
-		panic(err)
+func (ds *dataStream) taggedFloat64(index int) (float64, error) {
+	if err := ds.tag(index, tagByte8); err != nil {
+		return 0, err
 	}
+	return ds.float64()
+}
 
-	return path, nil
+// subBlock returns a new dataStream for a tagged subblock and advances
+// past it
+func (ds *dataStream) subBlock(index int) (*dataStream, error) {
+	if err := ds.tag(index, tagLength4); err != nil {
+		return nil, err
+	}
+	l, err := ds.uint32()
+	if err != nil {
+		return nil, err
+	}
+	start := ds.pos
+	if _, err := ds.bytes(int(l)); err != nil {
+		return nil, err
+	}
+	return &dataStream{
+		buf:     ds.buf[start : start+int(l)],
+		offset:  ds.offset + int64(start),
+		version: ds.version,
+	}, nil
 }
 
-// ParseSegment returns the segment for each segment in a path
-func ParseSegment(f fs.File) (Segment, error) {
+// string reads a length-prefixed string
+func (ds *dataStream) string() (string, error) {
+	l, err := ds.varUint()
+	if err != nil {
+		return "", err
+	}
+	if _, err := ds.uint8(); err != nil { // is ascii
+		return "", err
+	}
+	b, err := ds.bytes(int(l))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
 
-	sg := Segment{}
+// lwwString reads a "last write wins" string
+func (ds *dataStream) lwwString(index int) (string, error) {
+	sb, err := ds.subBlock(index)
+	if err != nil {
+		return "", err
+	}
+	if _, err := sb.taggedID(1); err != nil { // timestamp
+		return "", err
+	}
+	s, err := sb.subBlock(2)
+	if err != nil {
+		return "", err
+	}
+	return s.string()
+}
 
-	err := binary.Read(f, binary.LittleEndian, &sg)
-	if err == io.EOF {
-		return sg, err
-	} else if err == io.ErrUnexpectedEOF {
-		return sg, err
-	} else if err != nil {
-		panic(err)
+// lwwBool reads a "last write wins" boolean
+func (ds *dataStream) lwwBool(index int) (bool, error) {
+	sb, err := ds.subBlock(index)
+	if err != nil {
+		return false, err
+	}
+	if _, err := sb.taggedID(1); err != nil { // timestamp
+		return false, err
 	}
+	return sb.taggedBool(2)
+}
 
-	// record maximum segment coordinates
-	if sg.X > ms.X {
-		ms.X = sg.X
+// points reads the points of a line, the format of which depends on the
+// block version
+func (ds *dataStream) points() ([]Point, error) {
+	size := pointSizeV2
+	if ds.version == 1 {
+		size = pointSizeV1
 	}
-	if sg.Y > ms.Y {
-		ms.Y = sg.Y
+	if len(ds.buf)%size != 0 {
+		return nil, ds.errorf("points data length %d not a multiple of %d", len(ds.buf), size)
 	}
+	points := make([]Point, 0, len(ds.buf)/size)
+	for ds.remaining() > 0 {
+		var p Point
+		b, _ := ds.bytes(size)
+		f32 := func(i int) float32 {
+			return math.Float32frombits(binary.LittleEndian.Uint32(b[i:]))
+		}
+		p.X, p.Y = f32(0), f32(4)
+		if size == pointSizeV1 {
+			p.Speed = f32(8) * 4
+			p.Direction = f32(12)
+			p.Width = f32(16) * 4
+			p.Pressure = f32(20)
+		} else {
+			p.Speed = float32(binary.LittleEndian.Uint16(b[8:]))
+			p.Width = float32(binary.LittleEndian.Uint16(b[10:]))
+			p.Direction = float32(b[12]) * 2 * math.Pi / 255
+			p.Pressure = float32(b[13]) / 255
+		}
+		points = append(points, p)
+	}
+	return points, nil
+}
 
-	return sg, nil
+// formatUUID formats 16 bytes in uuid form (the bytes are little endian
+// for the first three fields)
+func formatUUID(b []byte) string {
+	if len(b) != 16 {
+		return fmt.Sprintf("%x", b)
+	}
+	return fmt.Sprintf(
+		"%08x-%04x-%04x-%x-%x",
+		binary.LittleEndian.Uint32(b[0:4]),
+		binary.LittleEndian.Uint16(b[4:6]),
+		binary.LittleEndian.Uint16(b[6:8]),
+		b[8:10],
+		b[10:16],
+	)
 }
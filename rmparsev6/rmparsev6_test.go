@@ -1,31 +1,235 @@
 /*
-rmparse_test.go
+rmparsev6_test.go
 MIT licenced, please see LICENCE
-RCL January 2020
+RCL February 2022
 */
 
-package rmparse
+package rmparsev6
 
 import (
-	"fmt"
+	"bytes"
 	"os"
-
-	// "fmt"
+	"strings"
 	"testing"
+
+	"github.com/rorycl/rm2pdf/rmparse"
 )
 
-// TestRMParseV6RMFile tests for a remarkable version 3 file
+// TestRMParseV6RMFile tests parsing a remarkable version 6 file
 func TestRMParseV6RMFile(t *testing.T) {
 
 	filer, err := os.Open("../testfiles/version6.rm")
 	if err != nil {
-		t.Errorf("Could not open version6 rm file %v", err)
+		t.Fatalf("Could not open version6 rm file %v", err)
 	}
 	defer filer.Close()
 
-	x, err := RMParse(filer)
+	rm, err := RMParse(filer)
+	if err != nil {
+		t.Fatalf("v6 rm file could not be setup for parsing: %v", err)
+	}
+
+	if rm.LayerNo != 2 {
+		t.Errorf("Layer number %d not 2", rm.LayerNo)
+	}
+	for i, name := range []string{"Layer 1", "Layer 2"} {
+		if rm.Layers[i].Name != name {
+			t.Errorf("Layer %d name %s not %s", i, rm.Layers[i].Name, name)
+		}
+		if !rm.Layers[i].Visible {
+			t.Errorf("Layer %d should be visible", i)
+		}
+	}
+	if rm.MigrationInfo.ID != (CrdtID{1, 1}) || !rm.MigrationInfo.IsDevice {
+		t.Errorf("Migration info unexpected %+v", rm.MigrationInfo)
+	}
+	if len(rm.AuthorIDs) != 1 {
+		t.Errorf("Author ids %d not 1", len(rm.AuthorIDs))
+	}
+	if rm.PageInfo.LoadsCount != 1 {
+		t.Errorf("Page info loads count %d not 1", rm.PageInfo.LoadsCount)
+	}
+
+	// deleted lines are not reported
+	layerPaths := map[uint32]int{}
+	lastPath := rmparse.RMPath{}
+	for rm.Parse() {
+		layerPaths[rm.Path.Layer]++
+		lastPath = rm.Path
+	}
+	if layerPaths[1] != 11 || layerPaths[2] != 15 {
+		t.Errorf("Paths by layer %v not map[1:11 2:15]", layerPaths)
+	}
+
+	if lastPath.Layer != 2 {
+		t.Errorf("Layer not 2")
+	}
+	if lastPath.Path.Pen != 17 {
+		t.Errorf("Path.Pen %d not 17", lastPath.Path.Pen)
+	}
+	if lastPath.Path.NumSegments != 55 || len(lastPath.Segments) != 55 {
+		t.Errorf("Path.NumSegments %d not 55", lastPath.Path.NumSegments)
+	}
+	// a thickness scale of 1.338 is a narrow pen
+	if lastPath.Path.Width != 1.875 {
+		t.Errorf("Path.Width %f not 1.875", lastPath.Path.Width)
+	}
+
+	// coordinates are moved to the top left origin used in v5 files
+	expected := rmparse.MaxCoordinates{X: 1144.1006, Y: 2263.3682}
+	if rm.MaxCoordinates != expected {
+		t.Errorf("MaxCoordinates %+v not %+v", rm.MaxCoordinates, expected)
+	}
+
+	// bounds are recorded for the file and each layer, as for v5 files
+	if rm.Bounds.Empty() || rm.Bounds.MaxX != expected.X || rm.Bounds.MaxY != expected.Y {
+		t.Errorf("Bounds %+v do not match MaxCoordinates %+v", rm.Bounds, expected)
+	}
+	if len(rm.LayerBounds) != 2 {
+		t.Fatalf("LayerBounds %d not 2", len(rm.LayerBounds))
+	}
+	for i, lb := range rm.LayerBounds {
+		if lb.Empty() {
+			t.Errorf("LayerBounds %d should not be empty", i)
+		}
+		if lb.MinX < rm.Bounds.MinX || lb.MinY < rm.Bounds.MinY || lb.MaxX > rm.Bounds.MaxX || lb.MaxY > rm.Bounds.MaxY {
+			t.Errorf("LayerBounds %d %+v outside Bounds %+v", i, lb, rm.Bounds)
+		}
+	}
+}
+
+// TestPathWidth tests mapping thickness scales onto the version 5 pen
+// widths
+func TestPathWidth(t *testing.T) {
+	for _, test := range []struct {
+		scale    float64
+		expected float32
+	}{
+		{1.0, 1.875},
+		{1.338, 1.875},
+		{1.875, 1.875},
+		{1.95, 2.0},
+		{2.0, 2.0},
+		{2.1, 2.125},
+		{3.0, 2.125},
+	} {
+		if w := pathWidth(test.scale); w != test.expected {
+			t.Errorf("thickness scale %f width %f not %f", test.scale, w, test.expected)
+		}
+	}
+}
+
+// TestRMParseV6Points tests the decoding of version 1 points
+func TestRMParseV6Points(t *testing.T) {
+
+	filer, err := os.Open("../testfiles/version6.rm")
+	if err != nil {
+		t.Fatalf("Could not open version6 rm file %v", err)
+	}
+	defer filer.Close()
+
+	rm, err := RMParse(filer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	line := rm.Layers[1].Lines[0]
+	if line.ID != (CrdtID{1, 33}) {
+		t.Errorf("Line id %s not 1:33", line.ID)
+	}
+	p := line.Points[len(line.Points)-1]
+	if p.X != 130.64754 || p.Y != 1320.1522 {
+		t.Errorf("Last point x/y %f/%f not 130.64754/1320.1522", p.X, p.Y)
+	}
+	if p.Pressure < 0 || p.Pressure > 1 {
+		t.Errorf("Pressure %f out of range", p.Pressure)
+	}
+}
+
+// TestRMParseV6Truncated tests that a truncated file reports an error
+func TestRMParseV6Truncated(t *testing.T) {
+
+	b, err := os.ReadFile("../testfiles/version6.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = RMParse(bytes.NewReader(b[:len(b)-10]))
 	if err == nil {
-		t.Errorf("expected error for v6 rm file")
+		t.Fatal("expected error for truncated v6 rm file")
+	}
+	if !strings.Contains(err.Error(), "truncated") {
+		t.Errorf("error %s should report truncation", err)
+	}
+}
+
+// TestRMParseV6WrongVersion tests that a v5 file is rejected
+func TestRMParseV6WrongVersion(t *testing.T) {
+
+	filer, err := os.Open("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm")
+	if err != nil {
+		t.Fatalf("Could not open file %v", err)
+	}
+	defer filer.Close()
+
+	_, err = RMParse(filer)
+	if err == nil {
+		t.Errorf("expected error for v5 rm file")
+	}
+}
+
+// TestRMParseV6SequenceOrder tests that the lines and groups of a layer
+// are added in CRDT sequence order rather than file order, with deleted
+// items kept as neighbours, and that the lines of groups not in any
+// sequence are added after these in the order the groups were declared
+func TestRMParseV6SequenceOrder(t *testing.T) {
+
+	layer := CrdtID{0, 11}
+	group := CrdtID{0, 20}
+	orphan := CrdtID{0, 30}
+	id := func(n uint64) CrdtID { return CrdtID{1, n} }
+	line := func(n uint64, left, right CrdtID) sequenceItem {
+		return sequenceItem{ID: id(n), Left: left, Right: right, Line: &Line{ID: id(n)}}
+	}
+
+	rm := &RMFile{
+		nodeParents: map[CrdtID]CrdtID{layer: rootID, group: layer, orphan: layer},
+		nodeOrder:   []CrdtID{layer, group, orphan},
+		nodes:       map[CrdtID]*Layer{},
+		items: map[CrdtID][]sequenceItem{
+			rootID: {{ID: id(1), Left: endMarker, Right: endMarker, Group: &layer}},
+			// in file order; in sequence order 10, 14, (deleted 15),
+			// group, 16 and 17, then 12, with 16 and 17 inserted at
+			// the same place ordered by id
+			layer: {
+				line(12, id(10), endMarker),
+				line(17, id(15), id(12)),
+				line(14, id(10), id(12)),
+				{ID: id(15), Left: id(14), Right: id(12), Deleted: true},
+				{ID: id(13), Left: id(15), Right: id(17), Group: &group},
+				line(16, id(15), id(12)),
+				line(10, endMarker, endMarker),
+			},
+			group: {
+				line(22, id(21), endMarker),
+				line(21, endMarker, endMarker),
+			},
+			orphan: {line(31, endMarker, endMarker)},
+		},
+	}
+	rm.buildLayers()
+
+	if len(rm.Layers) != 1 {
+		t.Fatalf("%d layers not 1", len(rm.Layers))
+	}
+	expected := []CrdtID{id(10), id(14), id(21), id(22), id(16), id(17), id(12), id(31)}
+	lines := rm.Layers[0].Lines
+	if len(lines) != len(expected) {
+		t.Fatalf("%d lines not %d", len(lines), len(expected))
+	}
+	for i, e := range expected {
+		if lines[i].ID != e {
+			t.Errorf("line %d is %s not %s", i, lines[i].ID, e)
+		}
 	}
-	fmt.Printf("%#v\n", x)
 }
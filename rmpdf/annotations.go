@@ -0,0 +1,119 @@
+/*
+Write the marks on each page of a reMarkable bundle as pdf ink
+annotations, which may be listed, hidden, edited or removed in the
+comment panes of pdf readers, in place of drawing them in layers.
+
+Nearby strokes made one after the other in a layer with the same pen,
+colour and width are grouped into a single annotation, such as the
+strokes of a word. Annotations are titled with the name of their layer
+and those of hidden layers are hidden. Highlighter strokes are written
+as ink annotations with the highlighter's transparency, as highlight
+annotations can only mark text.
+
+If every page of an annotated pdf is converted, in order and without
+inserted pages, the annotations are added to the original pdf as an
+incremental update, leaving its content unchanged. Otherwise they are
+added to the pdf made for the bundle, without its drawn marks.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"image/color"
+	"io"
+	"math"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// annotationGap is the largest gap in points between a stroke and the
+// strokes before it in the same annotation
+const annotationGap = 12.0
+
+// inkAnnotations groups the strokes of the layers of a page into ink
+// annotations
+func inkAnnotations(marks []layerMarks) []pdfutil.Ink {
+
+	inks := []pdfutil.Ink{}
+	for _, layer := range marks {
+		var last strokeMarks
+		var box bbox
+		for i, s := range layer.strokes {
+			near := box.overlaps(bbox{
+				s.box.minX - annotationGap, s.box.minY - annotationGap,
+				s.box.maxX + annotationGap, s.box.maxY + annotationGap,
+			})
+			if i == 0 || s.pen != last.pen || s.width != last.width || !near {
+				p := s.pen
+				inks = append(inks, pdfutil.Ink{
+					Width:   s.width,
+					Colour:  color.RGBA{uint8(p.r), uint8(p.g), uint8(p.b), 255},
+					Opacity: math.Max(0, math.Min(1, p.opacity)),
+					Title:   layer.name,
+					Subject: p.name,
+					Hidden:  !layer.visible,
+				})
+				box = s.box
+			}
+			ink := &inks[len(inks)-1]
+			ink.Paths = append(ink.Paths, inkPath(s, layer.erased))
+			box = bbox{
+				math.Min(box.minX, s.box.minX), math.Min(box.minY, s.box.minY),
+				math.Max(box.maxX, s.box.maxX), math.Max(box.maxY, s.box.maxY),
+			}
+			last = s
+		}
+	}
+	return inks
+}
+
+// inkPath makes the path of an ink annotation for a stroke, with the
+// areas erased after it was drawn
+func inkPath(s strokeMarks, erased []erasedArea) pdfutil.InkPath {
+	p := pdfutil.InkPath{
+		Points:  annotationPoints(s.points),
+		Outline: annotationPoints(s.outline),
+	}
+	for _, a := range erased {
+		if a.strokeNo > s.strokeNo && a.box.overlaps(s.box) {
+			p.Erased = append(p.Erased, annotationPoints(a.polygon))
+		}
+	}
+	return p
+}
+
+// annotationPoints converts points on the page to annotation points
+func annotationPoints(points []gofpdf.PointType) []pdfutil.Point {
+	if len(points) == 0 {
+		return nil
+	}
+	p := make([]pdfutil.Point, len(points))
+	for i, point := range points {
+		p[i] = pdfutil.Point{X: point.X, Y: point.Y}
+	}
+	return p
+}
+
+// unchangedPDF returns the annotated pdf of the bundle if each of its
+// pages is converted, in order and without inserted pages, so that the
+// annotations may be added to it unchanged, and otherwise nil
+func (c *conversion) unchangedPDF(jobs []pageJob) io.ReadSeeker {
+
+	if len(jobs) == 0 || jobs[0].isTemplate {
+		return nil
+	}
+	fh := jobs[0].sourceFH
+	if len(jobs) != len(c.pageSizes[fh]) {
+		return nil
+	}
+	for i, pg := range jobs {
+		if pg.isTemplate || pg.sourceFH != fh || pg.pdfPageNo != i {
+			return nil
+		}
+	}
+	return *fh
+}
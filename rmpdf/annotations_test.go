@@ -0,0 +1,136 @@
+/*
+annotations_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+)
+
+// TestInkAnnotations tests grouping nearby strokes of the same pen into
+// ink annotations
+func TestInkAnnotations(t *testing.T) {
+
+	fineliner := pen{name: "fineliner", opacity: 1}
+	highlighter := pen{name: "highlighter", opacity: 0.25, r: 255, g: 255}
+	stroke := func(strokeNo int, p pen, x, y float64) strokeMarks {
+		points := []gofpdf.PointType{{X: x, Y: y}, {X: x + 10, Y: y}}
+		return strokeMarks{strokeNo: strokeNo, pen: p, width: 1, points: points, box: pointsBox(points, 0.5)}
+	}
+
+	marks := []layerMarks{
+		{
+			name:    "Layer 1",
+			visible: true,
+			erased: []erasedArea{
+				{strokeNo: 2, box: bbox{0, 0, 50, 50}, polygon: []gofpdf.PointType{{X: 0, Y: 0}, {X: 50, Y: 0}, {X: 0, Y: 50}}},
+			},
+			strokes: []strokeMarks{
+				stroke(0, fineliner, 10, 10),
+				stroke(1, fineliner, 25, 10),  // near the first
+				stroke(3, fineliner, 200, 10), // far from the others
+				stroke(4, highlighter, 200, 12),
+			},
+		},
+		{
+			name:    "Notes",
+			visible: false,
+			strokes: []strokeMarks{stroke(0, fineliner, 10, 10)},
+		},
+	}
+
+	inks := inkAnnotations(marks)
+	if len(inks) != 4 {
+		t.Fatalf("%d annotations not 4", len(inks))
+	}
+	paths := []int{2, 1, 1, 1}
+	for i, ink := range inks {
+		if len(ink.Paths) != paths[i] {
+			t.Errorf("annotation %d has %d paths not %d", i+1, len(ink.Paths), paths[i])
+		}
+	}
+	if len(inks[0].Paths[0].Erased) != 1 || len(inks[1].Paths[0].Erased) != 0 {
+		t.Error("only strokes made before an eraser stroke should be erased")
+	}
+	if inks[2].Subject != "highlighter" || inks[2].Opacity != 0.25 || inks[2].Colour.R != 255 {
+		t.Errorf("unexpected highlighter annotation %+v", inks[2])
+	}
+	if inks[3].Title != "Notes" || !inks[3].Hidden || inks[0].Hidden {
+		t.Error("annotations of hidden layers should be hidden")
+	}
+}
+
+// TestConvertAnnotations tests writing marks as ink annotations, added
+// to the unchanged pdf when all of its pages are converted
+func TestConvertAnnotations(t *testing.T) {
+
+	const pdf = "../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf"
+	original, err := os.ReadFile(pdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	convert := func(inputpath string, options ...Option) []byte {
+		t.Helper()
+		options = append(options, WithLogger(log.New(io.Discard, "", 0)))
+		c, err := NewConverter(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := c.ConvertTo(inputpath, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := api.Validate(bytes.NewReader(buf.Bytes()), nil); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	annotated := func(b []byte) map[int]bool {
+		t.Helper()
+		annots, err := api.Annotations(bytes.NewReader(b), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages := map[int]bool{}
+		for pageNo := range annots {
+			pages[pageNo] = true
+		}
+		return pages
+	}
+
+	b := convert(pdf, WithAnnotations(true))
+	if !bytes.HasPrefix(b, original) {
+		t.Error("the annotated pdf should be unchanged")
+	}
+	if pages := annotated(b); !pages[1] || !pages[2] {
+		t.Errorf("annotated pages %v not 1 and 2", pages)
+	}
+
+	// the pdf is made afresh, without drawn marks, for some pages
+	layered := pageContents(t, convert(pdf, WithPageRanges("2")))
+	b = convert(pdf, WithPageRanges("2"), WithAnnotations(true))
+	if bytes.HasPrefix(b, original) {
+		t.Error("the pdf of some pages should not be the annotated pdf")
+	}
+	if contents := pageContents(t, b); len(contents) != 1 || len(contents[0]) >= len(layered[0]) {
+		t.Error("marks should not be drawn on the page as well as annotated")
+	}
+	if pages := annotated(b); !pages[1] || len(pages) != 1 {
+		t.Errorf("annotated pages %v not 1", pages)
+	}
+
+	if pages := annotated(convert(notebook(t, 3), WithAnnotations(true))); len(pages) != 3 {
+		t.Errorf("annotated notebook pages %v not 1 to 3", pages)
+	}
+}
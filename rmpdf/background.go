@@ -0,0 +1,91 @@
+/*
+Draw png and jpeg image templates, such as the tablet's raster
+templates or scanned paper, as the background of template pages.
+
+An image is drawn over the area of the page showing the tablet
+display, scaled to fit it with its aspect kept, and centred. The
+tablet's own templates for landscape notebooks are stored as portrait
+images, so images whose orientation differs from that of the display
+as it is held are turned a quarter turn clockwise to fit it.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/files"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// imagePlacement returns the area of the page, in points, over which
+// an image template is drawn, reporting if the image is turned a
+// quarter turn clockwise to fill it
+func imagePlacement(img *files.TemplateImage, t pageTransform) (x, y, w, h float64, rotate bool) {
+
+	viewWidth, viewHeight := t.viewSize()
+	iw, ih := float64(img.Width), float64(img.Height)
+	rotate = (iw > ih) != (viewWidth > viewHeight)
+	if rotate {
+		iw, ih = ih, iw
+	}
+	s := math.Min(viewWidth/iw, viewHeight/ih)
+	p := t.viewPoint((viewWidth-iw*s)/2, (viewHeight-ih*s)/2)
+	return p.X, p.Y, iw * s * t.scale, ih * s * t.scale, rotate
+}
+
+// drawTemplateImage draws an image template on the pdf page placed by t
+func drawTemplateImage(pdf *gofpdf.Fpdf, img *files.TemplateImage, t pageTransform) {
+
+	x, y, w, h, rotate := imagePlacement(img, t)
+	options := gofpdf.ImageOptions{ImageType: img.Type}
+	pdf.RegisterImageOptionsReader(img.Path, options, bytes.NewReader(img.Data))
+	if !rotate {
+		pdf.ImageOptions(img.Path, x, y, w, h, false, options, 0, "")
+		return
+	}
+
+	// draw the image upright about the centre of the area, then turn it
+	cx, cy := x+w/2, y+h/2
+	pdf.TransformBegin()
+	pdf.TransformRotate(-90, cx, cy)
+	pdf.ImageOptions(img.Path, cx-h/2, cy-w/2, h, w, false, options, 0, "")
+	pdf.TransformEnd()
+}
+
+// rasterTemplateImage draws an image template on a raster page placed
+// by t, at scale pixels per point. Decoded images are kept for the
+// conversion's other pages.
+func (c *conversion) rasterTemplateImage(dst *image.RGBA, img *files.TemplateImage, t pageTransform, scale float64) {
+
+	src, ok := c.images[img]
+	if !ok {
+		var err error
+		src, _, err = image.Decode(bytes.NewReader(img.Data))
+		if err != nil {
+			c.debug(fmt.Sprintf("could not decode image template %s: %v", img.Path, err))
+		}
+		c.images[img] = src
+	}
+	if src == nil {
+		return
+	}
+
+	// s2d maps source pixels to the area of the page, in pixels
+	x, y, w, h, rotate := imagePlacement(img, t)
+	sw, sh := float64(src.Bounds().Dx()), float64(src.Bounds().Dy())
+	s2d := f64.Aff3{w * scale / sw, 0, x * scale, 0, h * scale / sh, y * scale}
+	if rotate {
+		s2d = f64.Aff3{0, -w * scale / sh, (x + w) * scale, h * scale / sw, 0, y * scale}
+	}
+	xdraw.BiLinear.Transform(dst, s2d, src, src.Bounds(), draw.Over, nil)
+}
@@ -0,0 +1,107 @@
+/*
+background_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rorycl/rm2pdf/files"
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// TestImagePlacement tests fitting image templates to the tablet
+// display on the page
+func TestImagePlacement(t *testing.T) {
+
+	near := func(a, b float64) bool { return math.Abs(a-b) < 0.01 }
+	portrait := newPageTransform("portrait", 702, 1036)  // display 702 x 936
+	landscape := newPageTransform("landscape", 936, 702) // display 936 x 702
+
+	tests := []struct {
+		name          string
+		t             pageTransform
+		width, height int
+		x, y, w, h    float64
+		rotate        bool
+	}{
+		{"tablet template", portrait, 1404, 1872, 0, 50, 702, 936, false},
+		{"a4 scan", portrait, 1000, 1414, 20.02, 50, 661.95, 936, false},
+		{"wide image", portrait, 2000, 1000, 117, 50, 468, 936, true},
+		{"landscape tablet template", landscape, 1404, 1872, 0, 0, 936, 702, true},
+		{"landscape image", landscape, 1872, 1404, 0, 0, 936, 702, false},
+	}
+	for _, tt := range tests {
+		img := &files.TemplateImage{Width: tt.width, Height: tt.height}
+		x, y, w, h, rotate := imagePlacement(img, tt.t)
+		if !near(x, tt.x) || !near(y, tt.y) || !near(w, tt.w) || !near(h, tt.h) || rotate != tt.rotate {
+			t.Errorf("%s: placed at %.2f,%.2f size %.2f x %.2f rotated %t", tt.name, x, y, w, h, rotate)
+		}
+	}
+}
+
+// TestConvertImageTemplate tests using a png image as the template of
+// a notebook, for pdf and raster output
+func TestConvertImageTemplate(t *testing.T) {
+
+	dir := t.TempDir()
+	template := filepath.Join(dir, "paper.png")
+	paper := color.RGBA{R: 250, G: 240, B: 200, A: 255}
+	img := image.NewRGBA(image.Rect(0, 0, 140, 187))
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = paper.R, paper.G, paper.B, paper.A
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(template, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConverter(WithTemplate(template), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const bundle = "../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7"
+
+	output := filepath.Join(dir, "output.pdf")
+	if err := c.Convert(bundle, output); err != nil {
+		t.Fatal(err)
+	}
+	p, err := pdfutil.NewPDFFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(math.Round(p.Width) == 631 && math.Round(p.Height) == 842) {
+		t.Errorf("page size %f x %f not that of the tablet", p.Width, p.Height)
+	}
+	pdf, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contents := pageContents(t, pdf); !bytes.Contains(contents[0], []byte(" Do")) {
+		t.Error("the image template was not drawn")
+	}
+
+	names, err := c.ConvertImages(bundle, filepath.Join(dir, "output.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raster, _ := readImage(t, names[0])
+	if got := color.RGBAModel.Convert(raster.At(5, 5)).(color.RGBA); got != paper {
+		t.Errorf("raster background %v not %v", got, paper)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Record the state of each document converted from a library, so that
+only changed documents are converted again.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rorycl/rm2pdf/files"
+)
+
+// cacheEntry is the state of a converted document. Failed is set if
+// the last conversion failed, leaving any earlier pdf at Output.
+type cacheEntry struct {
+	Version      int    `json:"version"`
+	LastModified int64  `json:"lastModified"` // unix seconds
+	Hash         string `json:"hash"`         // files.Library InputHash
+	Output       string `json:"output"`       // the path of the pdf
+	Failed       bool   `json:"failed,omitempty"`
+}
+
+// libraryCache records the state of converted documents by uuid
+type libraryCache struct {
+	path      string
+	Documents map[string]cacheEntry `json:"documents"`
+}
+
+// loadLibraryCache loads the cache file at path, returning an empty
+// cache if the file does not exist
+func loadLibraryCache(path string) (*libraryCache, error) {
+	lc := libraryCache{path: path, Documents: map[string]cacheEntry{}}
+	body, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &lc, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read cache file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(body, &lc); err != nil {
+		return nil, fmt.Errorf("could not unmarshal cache file %s: %w", path, err)
+	}
+	if lc.Documents == nil {
+		lc.Documents = map[string]cacheEntry{}
+	}
+	return &lc, nil
+}
+
+// save writes the cache file, replacing the previous file only once the
+// new one is written
+func (lc *libraryCache) save() error {
+	body, err := json.MarshalIndent(lc, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := lc.path + ".tmp"
+	if err := os.WriteFile(tmp, body, 0644); err != nil {
+		return fmt.Errorf("could not write cache file: %w", err)
+	}
+	return os.Rename(tmp, lc.path)
+}
+
+// record records a document converted to output
+func (lc *libraryCache) record(d files.Document, hash, output string) {
+	lc.Documents[d.UUID] = cacheEntry{
+		Version:      d.Version,
+		LastModified: d.LastModified.Unix(),
+		Hash:         hash,
+		Output:       output,
+	}
+}
+
+// fail records that the conversion of a document failed, keeping its
+// earlier output, if any, so that it is still removed if the document
+// is removed
+func (lc *libraryCache) fail(d files.Document) {
+	if e, ok := lc.Documents[d.UUID]; ok {
+		e.Failed = true
+		lc.Documents[d.UUID] = e
+	}
+}
+
+// unchanged reports if a document has been converted to output since
+// its inputs last changed, and the output still exists
+func (lc *libraryCache) unchanged(d files.Document, hash, output string) bool {
+	e, ok := lc.Documents[d.UUID]
+	if !ok || e.Failed || e.Version != d.Version || e.LastModified != d.LastModified.Unix() ||
+		e.Hash != hash || e.Output != output {
+		return false
+	}
+	_, err := os.Stat(output)
+	return err == nil
+}
+
+// removeOutput removes an output file in outdir and any folders left
+// empty by its removal. Files outside outdir are left alone.
+func removeOutput(output, outdir string) error {
+	outdir = filepath.Clean(outdir)
+	if !strings.HasPrefix(filepath.Clean(output), outdir+string(filepath.Separator)) {
+		return nil
+	}
+	err := os.Remove(output)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for dir := filepath.Dir(output); dir != outdir; dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break // not empty
+		}
+	}
+	return nil
+}
@@ -0,0 +1,619 @@
+/*
+A Converter converts reMarkable file bundles to layered PDFs.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register image decoders for raster backgrounds
+	_ "image/png"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"runtime"
+	"sync"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+	"github.com/rorycl/rm2pdf/files"
+	"github.com/rorycl/rm2pdf/pdfutil"
+	"github.com/rorycl/rm2pdf/penconfig"
+)
+
+// Converter converts reMarkable file bundles to layered PDFs with the
+// options set by NewConverter. A Converter holds no state between
+// conversions, so Convert may be called from many goroutines.
+type Converter struct {
+	template      string
+	templateDir   string
+	templatePages files.TemplatePageMode
+	templateMap   map[int]int // 0-indexed template pages by page
+	penConfigs    penconfig.LayerPenConfigs
+	layerColours  []LocalColour
+	logger        *log.Logger
+	verbose       bool
+	noClobber     bool
+	dpi           float64
+	background    image.Image
+	cachePath     string
+	workers       int
+	pageRanges    PageRanges
+	annotatedOnly bool
+	insertedOnly  bool
+	annotations   bool
+}
+
+// Option is a functional option for a Converter
+type Option func(*Converter) error
+
+// WithTemplate sets the path to a single page A4 template to use for
+// bundles without a PDF and for inserted pages. If no template is set
+// the embedded A4 template is used. PNG and JPEG images, such as the
+// tablet's raster templates or scanned paper, may be used in place of
+// a PDF, and are drawn over the area of the tablet display with their
+// aspect kept.
+func WithTemplate(template string) Option {
+	return func(c *Converter) error {
+		c.template = template
+		return nil
+	}
+}
+
+// WithTemplateDir sets a directory of templates, such as a copy of the
+// tablet's /usr/share/remarkable/templates, in which the template for
+// each notebook page or inserted page is found by the name of the
+// template used on the tablet, as a pdf, svg or png file. Pages whose
+// template is not in the directory use the template set by
+// WithTemplate, or the embedded A4 template.
+func WithTemplateDir(dir string) Option {
+	return func(c *Converter) error {
+		if dir == "" {
+			return nil
+		}
+		info, err := os.Stat(dir)
+		if err != nil {
+			return fmt.Errorf("template directory error: %w", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("template directory %s is not a directory", dir)
+		}
+		c.templateDir = dir
+		return nil
+	}
+}
+
+// WithTemplatePages sets the pages of multi-page templates used for
+// notebook pages and inserted pages: "first" uses the first page of the
+// template for every page, as by default, "cycle" uses the pages of the
+// template in turn, and "match" uses the n-th page of the template for
+// the n-th notebook page or inserted page, or the last page of shorter
+// templates.
+func WithTemplatePages(mode string) Option {
+	return func(c *Converter) error {
+		m, ok := templatePageModes[mode]
+		if !ok {
+			return fmt.Errorf("invalid template page mode %q", mode)
+		}
+		c.templatePages = m
+		return nil
+	}
+}
+
+// WithTemplatePageMap sets the template page used for chosen notebook
+// pages or inserted pages, by pairs of page numbers such as "2:3,5:1",
+// in which page 2 of the bundle uses page 3 of its template. Pages are
+// numbered from 1 in the order shown on the tablet. The mapping takes
+// precedence over WithTemplatePages, and it is an error to map pages
+// which are not template pages or to template pages which do not
+// exist.
+func WithTemplatePageMap(spec string) Option {
+	return func(c *Converter) error {
+		m, err := parseTemplatePageMap(spec)
+		if err != nil {
+			return err
+		}
+		c.templateMap = m
+		return nil
+	}
+}
+
+// WithPenConfig sets custom pen settings by layer
+func WithPenConfig(lpc penconfig.LayerPenConfigs) Option {
+	return func(c *Converter) error {
+		c.penConfigs = lpc
+		return nil
+	}
+}
+
+// WithPenConfigFile loads custom pen settings from a yaml file, such as
+// config_example.yaml
+func WithPenConfigFile(settings string) Option {
+	return func(c *Converter) error {
+		lpc, err := penconfig.NewPenConfigFromFile(settings)
+		if err != nil {
+			return fmt.Errorf("settings file load error: %w", err)
+		}
+		c.penConfigs = lpc
+		return nil
+	}
+}
+
+// WithLayerColours sets the colours to use, by layer, for pens with
+// ColourOverride set
+func WithLayerColours(colours []LocalColour) Option {
+	return func(c *Converter) error {
+		c.layerColours = append([]LocalColour{}, colours...)
+		return nil
+	}
+}
+
+// WithLogger sets the logger used to report pages that could not be
+// drawn, unknown pens and, if verbose, debugging information. The
+// default logger writes to stdout.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Converter) error {
+		c.logger = logger
+		return nil
+	}
+}
+
+// WithVerbose turns on debugging output
+func WithVerbose(verbose bool) Option {
+	return func(c *Converter) error {
+		c.verbose = verbose
+		return nil
+	}
+}
+
+// WithNoClobber stops Convert from overwriting existing output files
+func WithNoClobber(noClobber bool) Option {
+	return func(c *Converter) error {
+		c.noClobber = noClobber
+		return nil
+	}
+}
+
+// WithDPI sets the resolution of raster images made by ConvertImages,
+// by default DefaultDPI
+func WithDPI(dpi float64) Option {
+	return func(c *Converter) error {
+		if dpi <= 0 {
+			return fmt.Errorf("invalid dpi %g", dpi)
+		}
+		c.dpi = dpi
+		return nil
+	}
+}
+
+// WithRasterBackground sets a PNG or JPEG image, such as a reMarkable
+// png template, to draw behind the marks in raster images made by
+// ConvertImages. The image is scaled to the size of each page. By
+// default raster images have a white background, or the page's image
+// template if it has one.
+func WithRasterBackground(path string) Option {
+	return func(c *Converter) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("background image load error: %w", err)
+		}
+		defer f.Close()
+		img, _, err := image.Decode(f)
+		if err != nil {
+			return fmt.Errorf("background image %s decode error: %w", path, err)
+		}
+		c.background = img
+		return nil
+	}
+}
+
+// WithLibraryCache sets the path of a cache file recording the state of
+// each document converted by ConvertLibrary, so that only documents
+// whose inputs have changed since they were last converted are
+// converted again. The file is made if it does not exist.
+func WithLibraryCache(path string) Option {
+	return func(c *Converter) error {
+		c.cachePath = path
+		return nil
+	}
+}
+
+// WithWorkers sets the number of pages of a PDF whose .rm files are
+// parsed and placed on the page at once, by default the number of
+// CPUs. Pages are always added to the PDF in order.
+func WithWorkers(workers int) Option {
+	return func(c *Converter) error {
+		if workers < 1 {
+			return fmt.Errorf("invalid number of workers %d", workers)
+		}
+		c.workers = workers
+		return nil
+	}
+}
+
+// WithPageRanges converts only the pages in ranges, such as
+// "1-3,7,10-", as parsed by ParsePageRanges. Pages are numbered from 1
+// in the order shown on the tablet, including any inserted pages.
+func WithPageRanges(ranges string) Option {
+	return func(c *Converter) error {
+		pr, err := ParsePageRanges(ranges)
+		if err != nil {
+			return err
+		}
+		c.pageRanges = pr
+		return nil
+	}
+}
+
+// WithAnnotatedOnly converts only pages with an .rm file, which are
+// those written on with the tablet
+func WithAnnotatedOnly(annotatedOnly bool) Option {
+	return func(c *Converter) error {
+		c.annotatedOnly = annotatedOnly
+		return nil
+	}
+}
+
+// WithInsertedOnly converts only pages inserted into an annotated pdf
+// on the tablet
+func WithInsertedOnly(insertedOnly bool) Option {
+	return func(c *Converter) error {
+		c.insertedOnly = insertedOnly
+		return nil
+	}
+}
+
+// WithAnnotations writes the marks on each page as pdf ink annotations,
+// which reviewers may list, hide or remove in the comment panes of pdf
+// readers, in place of drawing them in layers. If every page of an
+// annotated pdf is converted, without inserted pages, the annotations
+// are added to the original pdf as an incremental update, leaving its
+// content unchanged. Images made by ConvertSVG and ConvertImages are
+// not affected.
+func WithAnnotations(annotations bool) Option {
+	return func(c *Converter) error {
+		c.annotations = annotations
+		return nil
+	}
+}
+
+// NewConverter makes a new Converter with the provided options
+func NewConverter(options ...Option) (*Converter, error) {
+	c := &Converter{
+		penConfigs: make(penconfig.LayerPenConfigs),
+		logger:     log.New(os.Stdout, "", 0),
+		dpi:        DefaultDPI,
+		workers:    runtime.GOMAXPROCS(0),
+	}
+	for _, o := range options {
+		if err := o(c); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// conversion holds the state of a single conversion
+type conversion struct {
+	*Converter
+	rmf           files.RMFileInfo
+	pdf           *gofpdf.Fpdf
+	importer      *gofpdi.Importer
+	layerRegister map[string]int                          // pdf layer ids by name
+	unknownPens   map[int]int                             // counts of unknown pens by pen number
+	skippedPages  map[int]error                           // pages whose marks could not be drawn
+	pageSizes     map[*io.ReadSeeker][]pdfutil.Dimensions // background pdf page sizes
+	pageStreams   map[*io.ReadSeeker][]io.ReadSeeker      // single pages of mixed size background pdfs
+	importBoxes   map[*io.ReadSeeker]string               // page boxes with which to import background pdfs
+	mu            sync.Mutex                              // guards unknownPens
+	pageNumbers   []int                                   // 1-indexed bundle page numbers of the pdf pages
+	images        map[*files.TemplateImage]image.Image    // decoded image templates for raster images
+	inks          map[int][]pdfutil.Ink                   // ink annotations by 1-indexed pdf page
+	original      io.ReadSeeker                           // the annotated pdf, if annotated unchanged
+}
+
+// debug logs a message if the converter is verbose
+func (c *conversion) debug(d string) {
+	if c.verbose {
+		c.logger.Println(d)
+	}
+}
+
+// Convert converts the reMarkable bundle at inputpath, which is either
+// a PDF file, the uuid of a bundle without a PDF or a zip file, to a
+// layered PDF written to outfile. A PDF page is made for each page in
+// the bundle using the original PDF or the template, and each layer of
+// the associated page's .rm file is added on top of that.
+//
+// An existing outfile is overwritten unless the Converter was made
+// with WithNoClobber, in which case an error satisfying
+// errors.Is(err, fs.ErrExist) is returned.
+func (cv *Converter) Convert(inputpath, outfile string) error {
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if cv.noClobber {
+		// fail before converting if the file exists
+		_, err := os.Stat(outfile)
+		if err == nil {
+			return fmt.Errorf("output file %s: %w", outfile, fs.ErrExist)
+		}
+		flags |= os.O_EXCL
+	}
+
+	c, err := cv.convert(inputpath)
+	if err != nil {
+		return err
+	}
+	defer c.rmf.Close()
+
+	f, err := os.OpenFile(outfile, flags, 0644)
+	if err != nil {
+		return err
+	}
+	err = c.output(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	c.report()
+	return nil
+}
+
+// ConvertTo converts the reMarkable bundle at inputpath, as for
+// Convert, writing the PDF to w
+func (cv *Converter) ConvertTo(inputpath string, w io.Writer) error {
+
+	c, err := cv.convert(inputpath)
+	if err != nil {
+		return err
+	}
+	defer c.rmf.Close()
+	err = c.output(w)
+	if err != nil {
+		return err
+	}
+	c.report()
+	return nil
+}
+
+// pageWriter writes an image of the 0-indexed page rmPageNo, sized as
+// the pdfPageNo page of the background pdf read from sourceFH, to w
+type pageWriter func(c *conversion, w io.Writer, rmPageNo, pdfPageNo int, useTemplate bool, sourceFH *io.ReadSeeker) error
+
+// convertPages converts the reMarkable bundle at inputpath, writing an
+// image of each selected page with write to the files named by names,
+// and returning the names of the files written. Files are named by
+// their page number in the bundle. If the converter is set not to
+// clobber files, no files are written if any exist.
+func (cv *Converter) convertPages(inputpath string, names func(pageCount int) []string, write pageWriter) ([]string, error) {
+
+	c, err := cv.newConversion(inputpath)
+	if err != nil {
+		return nil, err
+	}
+	defer c.rmf.Close()
+
+	jobs, err := c.pageJobs()
+	if err != nil {
+		return nil, err
+	}
+	allNames := names(c.rmf.PageCount)
+	files := make([]string, len(jobs))
+	for i, pg := range jobs {
+		files[i] = allNames[pg.rmPageNo]
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if cv.noClobber {
+		for _, name := range files {
+			if _, err := os.Stat(name); err == nil {
+				return nil, fmt.Errorf("output file %s: %w", name, fs.ErrExist)
+			}
+		}
+		flags |= os.O_EXCL
+	}
+
+	for i, pg := range jobs {
+		f, err := os.OpenFile(files[i], flags, 0644)
+		if err != nil {
+			return nil, err
+		}
+		err = write(c, f, pg.rmPageNo, pg.pdfPageNo, pg.isTemplate, pg.sourceFH)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c.report()
+	return files, nil
+}
+
+// pageFileNames returns the names of files for each of pageCount pages,
+// being base followed by "-", the 1-indexed page number and ext
+func pageFileNames(base, ext string, pageCount int) []string {
+	names := make([]string, pageCount)
+	for i := range names {
+		names[i] = fmt.Sprintf("%s-%02d%s", base, i+1, ext)
+	}
+	return names
+}
+
+// newConversion makes a conversion of the reMarkable bundle at
+// inputpath, without an output pdf
+func (cv *Converter) newConversion(inputpath string) (*conversion, error) {
+
+	// initialise struct containing information about the files
+	var debug *log.Logger
+	if cv.verbose {
+		debug = cv.logger
+	}
+	rmfile, err := files.RMFilerWithTemplateDir(inputpath, cv.template, cv.templateDir, debug)
+	if err != nil {
+		return nil, err
+	}
+
+	rmfile.TemplatePages = cv.templatePages
+	rmfile.TemplatePageMap = cv.templateMap
+
+	if (rmfile.OriginalPageCount != rmfile.OriginalPageCount) && cv.template == "" {
+		return nil, fmt.Errorf(
+			"bundle has inserted page/s %s and no template was provided",
+			rmfile.InsertedPages(),
+		)
+	}
+
+	return &conversion{
+		Converter:     cv,
+		rmf:           rmfile,
+		layerRegister: map[string]int{},
+		unknownPens:   map[int]int{},
+		skippedPages:  map[int]error{},
+		pageSizes:     map[*io.ReadSeeker][]pdfutil.Dimensions{},
+		pageStreams:   map[*io.ReadSeeker][]io.ReadSeeker{},
+		importBoxes:   map[*io.ReadSeeker]string{},
+		images:        map[*files.TemplateImage]image.Image{},
+	}, nil
+}
+
+// convert makes a conversion of the reMarkable bundle at inputpath,
+// ready for output. The bundle is closed if the conversion fails,
+// otherwise it is left open for the caller to close.
+func (cv *Converter) convert(inputpath string) (_ *conversion, err error) {
+
+	c, err := cv.newConversion(inputpath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			c.rmf.Close()
+		}
+	}()
+
+	// See fpdf PageSize example
+	var pdf *gofpdf.Fpdf
+	if c.rmf.Orientation == "portrait" {
+		pdf = gofpdf.NewCustom(&gofpdf.InitType{
+			UnitStr: "pt",
+			Size: gofpdf.SizeType{
+				Ht: PDFHeightInMM * MMtoRMPoints,
+				Wd: PDFWidthInMM * MMtoRMPoints,
+			},
+		})
+	} else {
+		pdf = gofpdf.NewCustom(&gofpdf.InitType{
+			UnitStr: "pt",
+			Size: gofpdf.SizeType{
+				Wd: PDFHeightInMM * MMtoRMPoints,
+				Ht: PDFWidthInMM * MMtoRMPoints,
+			},
+		})
+	}
+	c.pdf = pdf
+	c.importer = gofpdi.NewImporter()
+
+	// Make colour (White in CMYK notation) for transparent fill
+	// pdf.AddSpotColor("White", 0, 0, 0, 0)
+
+	// Add general line styles
+	pdf.SetLineCapStyle("round")
+	pdf.SetLineJoinStyle("round")
+
+	// Iterate over pages using the rmfile iterator which provides a
+	// page number and the pdf to use (either the annotated pdf or the
+	// template). For annotated pdfs with inserted pages one might
+	// receive the following output from the iterator:
+	// pageno | inserted | template      | templatepageno
+	// -------+----------+---------------+---------------
+	// 0      | no       | annotated.pdf | 0
+	// 1      | yes      | template.pdf  | 0
+	// 2      | no       | annotated.pdf | 1
+
+	// Draw each selected page in the pdf, recording pages whose marks
+	// could not be drawn, such as those with corrupt .rm files
+	jobs, err := c.pageJobs()
+	if err != nil {
+		return nil, err
+	}
+	for _, pg := range jobs {
+		c.pageNumbers = append(c.pageNumbers, pg.rmPageNo+1)
+	}
+	if c.annotations {
+		c.inks = map[int][]pdfutil.Ink{}
+		c.original = c.unchangedPDF(jobs)
+	}
+	c.drawPages(jobs)
+
+	if err := pdf.Error(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// output writes the pdf to w. If only some pages were selected, the
+// pages are labelled with their page numbers in the bundle. Any ink
+// annotations are added to the pdf, or to the unchanged annotated pdf,
+// as an incremental update.
+func (c *conversion) output(w io.Writer) error {
+
+	if c.original != nil {
+		return pdfutil.WriteInkAnnotations(c.original, w, c.inks)
+	}
+
+	renumbered := false
+	for i, n := range c.pageNumbers {
+		renumbered = renumbered || n != i+1
+	}
+	if !renumbered && !c.annotations {
+		return c.pdf.Output(w)
+	}
+
+	var buf bytes.Buffer
+	if err := c.pdf.Output(&buf); err != nil {
+		return err
+	}
+	if !c.annotations {
+		return pdfutil.WritePageLabels(bytes.NewReader(buf.Bytes()), w, c.pageNumbers)
+	}
+	b := buf.Bytes()
+	if renumbered {
+		var labelled bytes.Buffer
+		if err := pdfutil.WritePageLabels(bytes.NewReader(b), &labelled, c.pageNumbers); err != nil {
+			return err
+		}
+		b = labelled.Bytes()
+	}
+	return pdfutil.WriteInkAnnotations(bytes.NewReader(b), w, c.inks)
+}
+
+// report logs the pages whose marks could not be drawn and any unknown
+// pens
+func (c *conversion) report() {
+
+	if len(c.skippedPages) > 0 {
+		c.logger.Println("The marks on some pages could not be drawn")
+		for i := 0; i < c.rmf.PageCount; i++ {
+			if err, ok := c.skippedPages[i]; ok {
+				c.logger.Printf("page: %02d error: %v\n", i+1, err)
+			}
+		}
+	}
+
+	if len(c.unknownPens) > 0 {
+		c.logger.Println("Some pen types were not found, and were forced to the fineliner style")
+		for k, v := range c.unknownPens {
+			c.logger.Printf("pen: %02d occurrences: %d\n", k, v)
+		}
+	}
+}
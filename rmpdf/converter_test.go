@@ -0,0 +1,225 @@
+/*
+converter_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	colornames "golang.org/x/image/colornames"
+
+	"github.com/rorycl/rm2pdf/pdfutil"
+	"github.com/rorycl/rm2pdf/penconfig"
+)
+
+// TestConverterOptions tests making a Converter with options
+func TestConverterOptions(t *testing.T) {
+
+	lpc, err := penconfig.LoadYaml([]byte(`
+all:
+  - pen:     pen
+    weight:  standard
+    width:   3.0
+    color:   red
+    opacity: 0.7`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	colours := []LocalColour{{Name: "blue", Colour: colornames.Blue}}
+	c, err := NewConverter(
+		WithTemplate("../templates/A4.pdf"),
+		WithPenConfig(lpc),
+		WithLayerColours(colours),
+		WithVerbose(true),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.template != "../templates/A4.pdf" || len(c.penConfigs["all"]) != 1 || !c.verbose {
+		t.Errorf("converter options not set %+v", c)
+	}
+
+	// the converter's colours are not changed by the caller
+	colours[0].Name = "red"
+	if c.layerColours[0].Name != "blue" {
+		t.Errorf("converter colour changed to %s", c.layerColours[0].Name)
+	}
+
+	_, err = NewConverter(WithPenConfigFile("nonexistent.yaml"))
+	if err == nil {
+		t.Error("expected error for missing pen configuration file")
+	}
+}
+
+// TestConverterLogger tests that debugging output is sent to the
+// converter's logger
+func TestConverterLogger(t *testing.T) {
+
+	var buf bytes.Buffer
+	c, err := NewConverter(WithLogger(log.New(&buf, "", 0)), WithVerbose(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tname := filepath.Join(t.TempDir(), "output.pdf")
+	err = c.Convert("../testfiles/version3.zip", tname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "processing page 1") {
+		t.Errorf("logger did not record debugging output, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "content : ") {
+		t.Errorf("logger did not record bundle debugging output, got %q", buf.String())
+	}
+}
+
+// TestConverterConcurrent tests converting several bundles with a
+// single converter at the same time. Run with "go test -race" to detect
+// data races.
+func TestConverterConcurrent(t *testing.T) {
+
+	lpc, err := penconfig.LoadYaml([]byte(`
+all:
+  - pen:      pen
+    weight:   standard
+    width:    2.0
+    color:    blue
+    opacity:  0.8
+    pressure: true`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	c, err := NewConverter(
+		WithPenConfig(lpc),
+		WithLayerColours([]LocalColour{{Name: "darkseagreen", Colour: colornames.Darkseagreen}}),
+		WithLogger(log.New(&buf, "", 0)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file  string
+		pages int
+	}{
+		{"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf", 2},
+		{"../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7", 1},
+		{"../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c", 3},
+		{"../testfiles/horizontal_rmapi.zip", 2},
+		{"../testfiles/version3.zip", 2},
+	}
+
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	errs := make([]error, len(tests)*2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			test := tests[i%len(tests)]
+			errs[i] = c.Convert(test.file, filepath.Join(dir, fmt.Sprintf("%d.pdf", i)))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		test := tests[i%len(tests)]
+		if err != nil {
+			t.Errorf("%s conversion error %v", test.file, err)
+			continue
+		}
+		p, err := pdfutil.NewPDFFile(filepath.Join(dir, fmt.Sprintf("%d.pdf", i)))
+		if err != nil {
+			t.Errorf("%s could not get pdf info %v", test.file, err)
+			continue
+		}
+		if p.Pages != test.pages {
+			t.Errorf("%s pages %d not %d", test.file, p.Pages, test.pages)
+		}
+	}
+}
+
+// TestConvertTo tests writing a converted pdf to an io.Writer
+func TestConvertTo(t *testing.T) {
+
+	c, err := NewConverter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = c.ConvertTo("../testfiles/version3.zip", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) || !bytes.Contains(buf.Bytes(), []byte("%%EOF")) {
+		t.Errorf("output of %d bytes is not a pdf", buf.Len())
+	}
+
+	err = c.ConvertTo("../testfiles/nonexistent.zip", &buf)
+	if err == nil {
+		t.Error("expected error for a missing bundle")
+	}
+}
+
+// TestConvertNoClobber tests that existing files are only overwritten
+// if the converter allows it
+func TestConvertNoClobber(t *testing.T) {
+
+	tname := filepath.Join(t.TempDir(), "output.pdf")
+	err := os.WriteFile(tname, []byte("existing"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConverter(WithNoClobber(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Convert("../testfiles/version3.zip", tname)
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected fs.ErrExist error, got %v", err)
+	}
+	b, err := os.ReadFile(tname)
+	if err != nil || string(b) != "existing" {
+		t.Errorf("existing file changed to %d bytes", len(b))
+	}
+
+	// new files are written
+	newName := filepath.Join(t.TempDir(), "new.pdf")
+	err = c.Convert("../testfiles/version3.zip", newName)
+	if err != nil {
+		t.Errorf("could not write new file %v", err)
+	}
+
+	c, err = NewConverter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = c.Convert("../testfiles/version3.zip", tname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pdfutil.NewPDFFile(tname)
+	if err != nil {
+		t.Fatalf("overwritten file is not a pdf %v", err)
+	}
+	if p.Pages != 2 {
+		t.Errorf("pages %d not 2", p.Pages)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Mask strokes with the eraser strokes drawn after them.
+
+The eraser removes the area under its path, and the area eraser the
+area enclosed by its path, from the strokes made earlier in the same
+layer. The pdf background and other layers are not affected.
+
+gofpdf does not support soft masks, so each erased area is removed by
+clipping to the page less that area using the even-odd rule. A single
+clipping path cannot exclude overlapping areas, so each segment of an
+eraser stroke is excluded separately; successive clipping paths
+intersect, so only the area outside all of the erased areas is drawn.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// bbox is a rectangle bounding a set of points
+type bbox struct {
+	minX, minY, maxX, maxY float64
+}
+
+// pointsBox returns the bbox of points, expanded by margin
+func pointsBox(points []gofpdf.PointType, margin float64) bbox {
+	b := bbox{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range points {
+		b.minX, b.maxX = math.Min(b.minX, p.X), math.Max(b.maxX, p.X)
+		b.minY, b.maxY = math.Min(b.minY, p.Y), math.Max(b.maxY, p.Y)
+	}
+	b.minX, b.minY = b.minX-margin, b.minY-margin
+	b.maxX, b.maxY = b.maxX+margin, b.maxY+margin
+	return b
+}
+
+// overlaps reports if two bboxes overlap
+func (b bbox) overlaps(o bbox) bool {
+	return b.minX <= o.maxX && o.minX <= b.maxX && b.minY <= o.maxY && o.minY <= b.maxY
+}
+
+// isEraser reports if the pen is an eraser
+func isEraser(penName string) bool {
+	return penName == "eraser" || penName == "erase area"
+}
+
+// erasedArea is an area removed by an eraser stroke
+type erasedArea struct {
+	strokeNo int // the index of the eraser stroke in its layer
+	box      bbox
+	polygon  []gofpdf.PointType
+}
+
+// eraserAreas returns the areas erased by an eraser stroke through
+// points. The eraser removes each segment of its path at the given
+// width and the area eraser removes the area enclosed by its path.
+func eraserAreas(strokeNo int, penName string, points []gofpdf.PointType, width float64) []erasedArea {
+
+	areas := []erasedArea{}
+	add := func(polygon []gofpdf.PointType) {
+		if len(polygon) < 3 {
+			return
+		}
+		areas = append(areas, erasedArea{strokeNo, pointsBox(polygon, 0), polygon})
+	}
+
+	if penName == "erase area" {
+		add(points)
+		return areas
+	}
+
+	if len(points) == 1 {
+		add(strokeOutline(points, []float64{width}))
+	}
+	for i := 1; i < len(points); i++ {
+		add(strokeOutline(points[i-1:i+1], []float64{width, width}))
+	}
+	return areas
+}
+
+// clipErasedAreas starts clipping out the areas erased after stroke
+// strokeNo that overlap box, reporting if any clipping was started. Each
+// clipping must be ended with endClip after drawing the stroke.
+func clipErasedAreas(pdf *gofpdf.Fpdf, areas []erasedArea, strokeNo int, box bbox) bool {
+
+	w, h := pdf.GetPageSize()
+	k := pdf.GetConversionRatio()
+
+	var s strings.Builder
+	for _, a := range areas {
+		if a.strokeNo <= strokeNo || !a.box.overlaps(box) {
+			continue
+		}
+		if s.Len() == 0 {
+			s.WriteString("q\n")
+		}
+		fmt.Fprintf(&s, "0 0 %.5f %.5f re ", w*k, h*k)
+		for j, pt := range a.polygon {
+			op := "l"
+			if j == 0 {
+				op = "m"
+			}
+			fmt.Fprintf(&s, "%.5f %.5f %s ", pt.X*k, (h-pt.Y)*k, op)
+		}
+		s.WriteString("h W* n\n")
+	}
+	if s.Len() == 0 {
+		return false
+	}
+	pdf.RawWriteStr(strings.TrimSuffix(s.String(), "\n"))
+	return true
+}
+
+// endClip ends clipping started by clipErasedAreas
+func endClip(pdf *gofpdf.Fpdf) {
+	pdf.RawWriteStr("Q")
+}
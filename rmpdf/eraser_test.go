@@ -0,0 +1,98 @@
+/*
+eraser_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// TestEraserAreas tests the areas erased by eraser strokes
+func TestEraserAreas(t *testing.T) {
+
+	points := []gofpdf.PointType{{X: 10, Y: 10}, {X: 20, Y: 10}, {X: 20, Y: 20}}
+
+	// each segment of an eraser stroke is erased separately
+	areas := eraserAreas(3, "eraser", points, 4)
+	if len(areas) != 2 {
+		t.Fatalf("eraser areas %d not 2", len(areas))
+	}
+	expected := bbox{8, 8, 22, 12}
+	if areas[0].strokeNo != 3 || areas[0].box != expected {
+		t.Errorf("first area stroke %d box %+v not 3 %+v", areas[0].strokeNo, areas[0].box, expected)
+	}
+
+	// the area eraser erases the area enclosed by its path
+	areas = eraserAreas(3, "erase area", points, 4)
+	if len(areas) != 1 || len(areas[0].polygon) != 3 {
+		t.Fatalf("erase area areas %v not a single triangle", areas)
+	}
+	if areas[0].box != (bbox{10, 10, 20, 20}) {
+		t.Errorf("erase area box %+v not 10/10/20/20", areas[0].box)
+	}
+
+	if areas = eraserAreas(3, "erase area", points[:2], 4); len(areas) != 0 {
+		t.Errorf("an erase area line should not erase anything, got %v", areas)
+	}
+	if areas = eraserAreas(3, "eraser", points[:1], 4); len(areas) != 1 {
+		t.Errorf("an eraser dot should erase a circle, got %v", areas)
+	}
+}
+
+// TestClipErasedAreas tests that only areas erased after a stroke, which
+// overlap it, are clipped
+func TestClipErasedAreas(t *testing.T) {
+
+	pdf := gofpdf.NewCustom(&gofpdf.InitType{
+		UnitStr: "pt",
+		Size:    gofpdf.SizeType{Wd: 200, Ht: 100},
+	})
+	pdf.SetCompression(false)
+	pdf.AddPage()
+
+	areas := append(
+		eraserAreas(1, "eraser", []gofpdf.PointType{{X: 10, Y: 10}, {X: 20, Y: 10}}, 2),
+		eraserAreas(3, "eraser", []gofpdf.PointType{{X: 50, Y: 50}, {X: 60, Y: 50}}, 2)...,
+	)
+	stroke := []gofpdf.PointType{{X: 0, Y: 10}, {X: 100, Y: 50}}
+	box := pointsBox(stroke, 1)
+
+	for _, test := range []struct {
+		strokeNo int
+		box      bbox
+		clipped  bool
+	}{
+		{0, box, true},
+		{2, box, true},
+		{3, box, false},
+		{0, pointsBox([]gofpdf.PointType{{X: 150, Y: 80}}, 1), false},
+	} {
+		clipped := clipErasedAreas(pdf, areas, test.strokeNo, test.box)
+		if clipped != test.clipped {
+			t.Errorf("stroke %d box %+v clipped %t not %t", test.strokeNo, test.box, clipped, test.clipped)
+		}
+		if clipped {
+			endClip(pdf)
+		}
+	}
+
+	var buf bytes.Buffer
+	err := pdf.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// stroke 0 is clipped by both erasers, and stroke 2 by the last
+	if n := strings.Count(buf.String(), "W* n"); n != 3 {
+		t.Errorf("clipping paths %d not 3", n)
+	}
+	if n := strings.Count(buf.String(), "0 0 200.00000 100.00000 re"); n != 3 {
+		t.Errorf("page rectangles %d not 3", n)
+	}
+}
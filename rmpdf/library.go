@@ -0,0 +1,174 @@
+/*
+Convert every document in a library of reMarkable documents, such as a
+copy of a tablet's xochitl directory.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/rorycl/rm2pdf/files"
+)
+
+// LibraryResult records the conversion of a document in a library
+type LibraryResult struct {
+	Document files.Document
+	Output   string // the path of the pdf
+	Err      error  // the conversion error, if any
+	Skipped  bool   // unchanged since the last conversion
+	Removed  bool   // the output of a removed or trashed document was deleted
+}
+
+// ConvertLibrary converts each document in the library at dir to a pdf
+// in outdir, at the document's path in the folder hierarchy of the
+// tablet, such as "outdir/Work/Projects/Meeting notes.pdf". Folders are
+// made as needed.
+//
+// Documents which cannot be converted, such as those without marks or
+// with unreadable .metadata files, are logged and recorded in the
+// results, and the remaining documents are converted. An error is only
+// returned if the library cannot be scanned, or the cache file read or
+// written.
+//
+// If the Converter was made with WithLibraryCache, documents whose
+// metadata version, last modified time, input files and output path are
+// unchanged since they were last converted are skipped. The outputs of
+// documents which have since been removed, trashed or moved are
+// deleted, together with any folders left empty. Changes to the
+// Converter's options are not recorded, so the cache file should be
+// removed when they change.
+func (cv *Converter) ConvertLibrary(dir, outdir string) ([]LibraryResult, error) {
+
+	lib, err := files.ScanLibrary(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var cache *libraryCache
+	if cv.cachePath != "" {
+		cache, err = loadLibraryCache(cv.cachePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	results := []LibraryResult{}
+	current := map[string]bool{} // documents by uuid
+	kept := map[string]bool{}    // outputs written or skipped
+
+	// documents with unreadable metadata are kept in the cache, so that
+	// their outputs are not removed
+	unreadable := []string{}
+	for id := range lib.Unreadable {
+		unreadable = append(unreadable, id)
+	}
+	sort.Strings(unreadable)
+	for _, id := range unreadable {
+		current[id] = true
+		r := LibraryResult{Document: files.Document{UUID: id}, Err: lib.Unreadable[id]}
+		cv.logger.Printf("%s could not be converted: %v", id, r.Err)
+		results = append(results, r)
+	}
+	for _, d := range lib.Documents {
+		current[d.UUID] = true
+		r := LibraryResult{
+			Document: d,
+			Output:   filepath.Join(outdir, d.Path()+".pdf"),
+		}
+
+		var hash string
+		if cache != nil {
+			hash, r.Err = lib.InputHash(d)
+			if r.Err == nil && cache.unchanged(d, hash, r.Output) {
+				r.Skipped = true
+				kept[r.Output] = true
+				if cv.verbose {
+					cv.logger.Printf("skipping unchanged %s", d.UUID)
+				}
+				results = append(results, r)
+				continue
+			}
+		}
+
+		if r.Err == nil {
+			if cv.verbose {
+				cv.logger.Printf("converting %s to %s", d.UUID, r.Output)
+			}
+			r.Err = os.MkdirAll(filepath.Dir(r.Output), 0755)
+		}
+		if r.Err == nil {
+			r.Err = cv.Convert(lib.BundlePath(d), r.Output)
+		}
+		if r.Err != nil {
+			cv.logger.Printf("%s could not be converted: %v", d.Path(), r.Err)
+		} else {
+			kept[r.Output] = true
+		}
+
+		if cache != nil {
+			// remove the output of a moved or renamed document once it
+			// has been converted, unless another document has taken its
+			// place. If the conversion failed, the earlier output is
+			// kept and any part written at the new path is removed.
+			e, ok := cache.Documents[d.UUID]
+			switch {
+			case !ok || e.Output == r.Output:
+			case r.Err == nil && !kept[e.Output]:
+				if err := removeOutput(e.Output, outdir); err != nil {
+					cv.logger.Printf("%s could not be removed: %v", e.Output, err)
+				}
+			case r.Err != nil && !kept[r.Output]:
+				if err := removeOutput(r.Output, outdir); err != nil {
+					cv.logger.Printf("%s could not be removed: %v", r.Output, err)
+				}
+			}
+			// record failures so that they are retried
+			if r.Err == nil {
+				cache.record(d, hash, r.Output)
+			} else {
+				cache.fail(d)
+			}
+		}
+		results = append(results, r)
+	}
+
+	if cache == nil {
+		return results, nil
+	}
+
+	// remove the outputs of removed and trashed documents
+	removed := []string{}
+	for id := range cache.Documents {
+		if !current[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		r := LibraryResult{
+			Document: files.Document{UUID: id},
+			Output:   cache.Documents[id].Output,
+			Removed:  true,
+		}
+		if cv.verbose {
+			cv.logger.Printf("removing %s", r.Output)
+		}
+		if !kept[r.Output] {
+			r.Err = removeOutput(r.Output, outdir)
+		}
+		if r.Err != nil {
+			cv.logger.Printf("%s could not be removed: %v", r.Output, r.Err)
+		} else {
+			delete(cache.Documents, id)
+		}
+		results = append(results, r)
+	}
+
+	return results, cache.save()
+}
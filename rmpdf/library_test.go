@@ -0,0 +1,273 @@
+/*
+library_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// copyBundle copies the files of a test bundle to dir
+func copyBundle(t *testing.T, id, dir string) {
+	t.Helper()
+
+	err := filepath.WalkDir("../testfiles", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel("../testfiles", path)
+		if !strings.HasPrefix(rel, id) {
+			if d.IsDir() && rel != "." {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, rel), 0755)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, rel), b, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestConvertLibrary tests converting each document in a library to a
+// pdf in a folder hierarchy
+func TestConvertLibrary(t *testing.T) {
+
+	const (
+		withPDF = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+		sketch  = "d34df12d-e72b-4939-a791-5b34b3a810e7"
+		folder  = "00000000-0000-0000-0000-000000000001"
+		noMarks = "10000000-0000-0000-0000-000000000001"
+		corrupt = "10000000-0000-0000-0000-000000000002"
+	)
+
+	dir := t.TempDir()
+	copyBundle(t, withPDF, dir)
+	copyBundle(t, sketch, dir)
+	metadata := map[string]string{
+		folder:  `{"type": "CollectionType", "parent": "", "visibleName": "Work"}`,
+		withPDF: `{"type": "DocumentType", "parent": "` + folder + `", "visibleName": "Meeting notes"}`,
+		noMarks: `{"type": "DocumentType", "parent": "", "visibleName": "Empty"}`,
+		corrupt: `{"type": "DocumentType", "parent": `,
+	}
+	for id, body := range metadata {
+		err := os.WriteFile(filepath.Join(dir, id+".metadata"), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outdir := filepath.Join(t.TempDir(), "output")
+	var logs bytes.Buffer
+	c, err := NewConverter(WithLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := c.ConvertLibrary(dir, outdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]bool{
+		filepath.Join(outdir, "Empty.pdf"):                 false,
+		filepath.Join(outdir, "Work", "Meeting notes.pdf"): true,
+		filepath.Join(outdir, "toolbox.pdf"):               true,
+		"":                                                 false, // corrupt metadata
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("results %d not %d: %+v", len(results), len(expected), results)
+	}
+	for _, r := range results {
+		ok, found := expected[r.Output]
+		if !found {
+			t.Errorf("unexpected output %s", r.Output)
+			continue
+		}
+		if ok != (r.Err == nil) {
+			t.Errorf("%s error %v", r.Output, r.Err)
+		}
+		if !ok {
+			continue
+		}
+		if _, err := pdfutil.NewPDFFile(r.Output); err != nil {
+			t.Errorf("%s is not a pdf: %v", r.Output, err)
+		}
+	}
+	for _, name := range []string{"Empty", corrupt} {
+		if !strings.Contains(logs.String(), name+" could not be converted") {
+			t.Errorf("conversion error for %s not logged: %s", name, logs.String())
+		}
+	}
+}
+
+// TestConvertLibraryCache tests that only changed documents are
+// converted again, and that the outputs of moved and trashed documents
+// are removed
+func TestConvertLibraryCache(t *testing.T) {
+
+	const (
+		withPDF = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+		sketch  = "d34df12d-e72b-4939-a791-5b34b3a810e7"
+		folder  = "00000000-0000-0000-0000-000000000001"
+	)
+
+	dir := t.TempDir()
+	copyBundle(t, withPDF, dir)
+	copyBundle(t, sketch, dir)
+	writeMetadata := func(id, body string) {
+		t.Helper()
+		err := os.WriteFile(filepath.Join(dir, id+".metadata"), []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeMetadata(folder, `{"type": "CollectionType", "parent": "", "visibleName": "Work"}`)
+	writeMetadata(withPDF, `{"type": "DocumentType", "parent": "`+folder+`", "visibleName": "Meeting notes"}`)
+
+	outdir := t.TempDir()
+	c, err := NewConverter(
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithLibraryCache(filepath.Join(t.TempDir(), "cache.json")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// states summarises the results by output path relative to outdir
+	states := func() map[string]string {
+		t.Helper()
+		results, err := c.ConvertLibrary(dir, outdir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := map[string]string{}
+		for _, r := range results {
+			rel, _ := filepath.Rel(outdir, r.Output)
+			switch {
+			case r.Err != nil:
+				s[rel] = "error"
+			case r.Skipped:
+				s[rel] = "skipped"
+			case r.Removed:
+				s[rel] = "removed"
+			default:
+				s[rel] = "converted"
+			}
+		}
+		return s
+	}
+	exists := func(rel string) bool {
+		_, err := os.Stat(filepath.Join(outdir, rel))
+		return err == nil
+	}
+
+	notes := filepath.Join("Work", "Meeting notes.pdf")
+	tests := []struct {
+		desc      string
+		change    func()
+		expected  map[string]string
+		remaining []string // earlier pdfs left in place
+	}{
+		{
+			desc:     "first run",
+			change:   func() {},
+			expected: map[string]string{notes: "converted", "toolbox.pdf": "converted"},
+		},
+		{
+			desc:     "unchanged",
+			change:   func() {},
+			expected: map[string]string{notes: "skipped", "toolbox.pdf": "skipped"},
+		},
+		{
+			desc: "new version and moved",
+			change: func() {
+				writeMetadata(sketch, `{"type": "DocumentType", "parent": "", "visibleName": "toolbox", "version": 1}`)
+				writeMetadata(withPDF, `{"type": "DocumentType", "parent": "", "visibleName": "Meeting notes"}`)
+			},
+			expected: map[string]string{"Meeting notes.pdf": "converted", "toolbox.pdf": "converted"},
+		},
+		{
+			desc: "changed page and trashed",
+			change: func() {
+				rm := filepath.Join(dir, withPDF, "da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224-metadata.json")
+				if err := os.WriteFile(rm, []byte(`{"layers": [{"name": "Changed"}]}`), 0644); err != nil {
+					t.Fatal(err)
+				}
+				writeMetadata(sketch, `{"type": "DocumentType", "parent": "trash", "visibleName": "toolbox"}`)
+			},
+			expected: map[string]string{"Meeting notes.pdf": "converted", "toolbox.pdf": "removed"},
+		},
+		{
+			desc: "failed",
+			change: func() {
+				if err := os.WriteFile(filepath.Join(dir, withPDF+".content"), []byte(`{`), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			// the earlier pdf is left in place
+			expected:  map[string]string{"Meeting notes.pdf": "error"},
+			remaining: []string{"Meeting notes.pdf"},
+		},
+		{
+			desc: "failed after moving",
+			change: func() {
+				writeMetadata(withPDF, `{"type": "DocumentType", "parent": "`+folder+`", "visibleName": "Meeting notes"}`)
+			},
+			// the pdf at the earlier path is left in place
+			expected:  map[string]string{notes: "error"},
+			remaining: []string{"Meeting notes.pdf"},
+		},
+		{
+			desc: "failed and trashed",
+			change: func() {
+				writeMetadata(withPDF, `{"type": "DocumentType", "parent": "trash", "visibleName": "Meeting notes"}`)
+			},
+			expected: map[string]string{"Meeting notes.pdf": "removed"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt.change()
+		got := states()
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: got %v wanted %v", tt.desc, got, tt.expected)
+			continue
+		}
+		for rel, state := range tt.expected {
+			if got[rel] != state {
+				t.Errorf("%s: %s got %s wanted %s", tt.desc, rel, got[rel], state)
+			}
+			if state != "error" && exists(rel) != (state != "removed") {
+				t.Errorf("%s: %s exists %t", tt.desc, rel, exists(rel))
+			}
+		}
+		for _, rel := range tt.remaining {
+			if !exists(rel) {
+				t.Errorf("%s: %s should be left in place", tt.desc, rel)
+			}
+		}
+	}
+
+	if exists("Work") {
+		t.Error("empty Work folder should be removed")
+	}
+}
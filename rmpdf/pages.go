@@ -0,0 +1,142 @@
+/*
+Select the pages of a bundle to convert, by page range, by whether a
+page has marks or by whether it was inserted on the tablet, and the
+pages of multi-page templates to use for template pages.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/rorycl/rm2pdf/files"
+)
+
+// ErrNoPages is returned when no pages of a bundle are selected
+var ErrNoPages = errors.New("no pages selected")
+
+// pageRange is an inclusive range of 1-indexed pages; a last page of 0
+// is open-ended
+type pageRange struct {
+	first, last int
+}
+
+// PageRanges are ranges of 1-indexed pages, such as those parsed from
+// "1-3,7,10-"
+type PageRanges []pageRange
+
+// ParsePageRanges parses comma separated pages and ranges of pages,
+// numbered from 1, such as "1-3,7,10-", in which "10-" selects page 10
+// and those after it and "-3" pages 1 to 3
+func ParsePageRanges(spec string) (PageRanges, error) {
+
+	var ranges PageRanges
+	number := func(s string, missing int) (int, error) {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			return missing, nil
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("invalid page number %q", s)
+		}
+		return n, nil
+	}
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		if !isRange {
+			to = from
+		}
+		first, err := number(from, 1)
+		if err != nil {
+			return nil, err
+		}
+		last, err := number(to, 0)
+		if err != nil {
+			return nil, err
+		}
+		if last != 0 && last < first {
+			return nil, fmt.Errorf("invalid page range %q", part)
+		}
+		ranges = append(ranges, pageRange{first, last})
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no pages in %q", spec)
+	}
+	return ranges, nil
+}
+
+// Contains reports if the 1-indexed page is in the ranges
+func (pr PageRanges) Contains(page int) bool {
+	for _, r := range pr {
+		if page >= r.first && (r.last == 0 || page <= r.last) {
+			return true
+		}
+	}
+	return false
+}
+
+// selected reports if a page is selected for conversion
+func (c *conversion) selected(pg pageJob) bool {
+	if len(c.pageRanges) > 0 && !c.pageRanges.Contains(pg.rmPageNo+1) {
+		return false
+	}
+	if c.annotatedOnly {
+		if pg.rmPageNo >= len(c.rmf.Pages) || !c.rmf.Pages[pg.rmPageNo].Exists {
+			return false
+		}
+	}
+	if c.insertedOnly && !pg.inserted {
+		return false
+	}
+	return true
+}
+
+// templatePageModes are the names of the files.TemplatePageMode values
+var templatePageModes = map[string]files.TemplatePageMode{
+	"first": files.TemplateFirstPage,
+	"cycle": files.TemplateCyclePages,
+	"match": files.TemplateMatchPages,
+}
+
+// parseTemplatePageMap parses comma separated pairs of page numbers,
+// numbered from 1, such as "2:3,5:1", mapping pages of a bundle to
+// pages of its template. The map is 0-indexed.
+func parseTemplatePageMap(spec string) (map[int]int, error) {
+
+	pages := map[int]int{}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(part, ":")
+		page, err := strconv.Atoi(strings.TrimSpace(from))
+		if !ok || err != nil || page < 1 {
+			return nil, fmt.Errorf("invalid template page mapping %q", part)
+		}
+		templatePage, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil || templatePage < 1 {
+			return nil, fmt.Errorf("invalid template page mapping %q", part)
+		}
+		if _, ok := pages[page-1]; ok {
+			return nil, fmt.Errorf("page %d is mapped more than once", page)
+		}
+		pages[page-1] = templatePage - 1
+	}
+	if len(pages) == 0 {
+		return nil, fmt.Errorf("no template pages in %q", spec)
+	}
+	return pages, nil
+}
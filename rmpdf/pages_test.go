@@ -0,0 +1,129 @@
+/*
+pages_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// TestParsePageRanges tests parsing page ranges
+func TestParsePageRanges(t *testing.T) {
+
+	tests := []struct {
+		spec     string
+		ok       bool
+		contains []int
+		excludes []int
+	}{
+		{"1-3,7,10-", true, []int{1, 2, 3, 7, 10, 99}, []int{4, 6, 8, 9}},
+		{" 2 , 4-4 ", true, []int{2, 4}, []int{1, 3, 5}},
+		{"-2", true, []int{1, 2}, []int{3}},
+		{"3-1", false, nil, nil},
+		{"0", false, nil, nil},
+		{"a-b", false, nil, nil},
+		{",", false, nil, nil},
+	}
+
+	for _, tt := range tests {
+		pr, err := ParsePageRanges(tt.spec)
+		if (err == nil) != tt.ok {
+			t.Errorf("%q error %v", tt.spec, err)
+			continue
+		}
+		for _, p := range tt.contains {
+			if !pr.Contains(p) {
+				t.Errorf("%q should contain %d", tt.spec, p)
+			}
+		}
+		for _, p := range tt.excludes {
+			if pr.Contains(p) {
+				t.Errorf("%q should not contain %d", tt.spec, p)
+			}
+		}
+	}
+}
+
+// TestConvertSelectedPages tests converting some pages of a bundle,
+// labelled with their page numbers in the bundle
+func TestConvertSelectedPages(t *testing.T) {
+
+	const (
+		inserted  = "../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c"
+		landscape = "../testfiles/e724bba2-266f-434d-aaf2-935d2b405aee"
+	)
+
+	tests := []struct {
+		desc    string
+		bundle  string
+		options []Option
+		labels  []string
+		err     error
+	}{
+		{"all pages", inserted, nil, []string{"", "", ""}, nil},
+		{"ranges", inserted, []Option{WithPageRanges("2-")}, []string{"2", "3"}, nil},
+		{"inserted", inserted, []Option{WithInsertedOnly(true)}, []string{"2"}, nil},
+		{"ranges and inserted", inserted, []Option{WithPageRanges("3"), WithInsertedOnly(true)}, nil, ErrNoPages},
+		// the second page has no .rm file
+		{"annotated", landscape, []Option{WithAnnotatedOnly(true)}, []string{""}, nil},
+		{"not annotated", landscape, []Option{WithPageRanges("2"), WithAnnotatedOnly(true)}, nil, ErrNoPages},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			options := append([]Option{WithLogger(log.New(io.Discard, "", 0))}, tt.options...)
+			c, err := NewConverter(options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outfile := filepath.Join(t.TempDir(), "selected.pdf")
+			err = c.Convert(tt.bundle, outfile)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v not %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			p, err := pdfutil.NewPDFFile(outfile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.PageInfo) != len(tt.labels) {
+				t.Fatalf("pages %d not %d", len(p.PageInfo), len(tt.labels))
+			}
+			for i, label := range tt.labels {
+				if p.PageInfo[i].Label != label {
+					t.Errorf("page %d label %q not %q", i+1, p.PageInfo[i].Label, label)
+				}
+			}
+		})
+	}
+}
+
+// TestConvertSVGSelectedPages tests that images of selected pages are
+// named by their page numbers in the bundle
+func TestConvertSVGSelectedPages(t *testing.T) {
+
+	c, err := NewConverter(WithPageRanges("1,3"), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outfile := filepath.Join(t.TempDir(), "selected.svg")
+	names, err := c.ConvertSVG("../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c", outfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := SVGFileNames(outfile, 3)
+	if len(names) != 2 || names[0] != all[0] || names[1] != all[2] {
+		t.Errorf("unexpected names %v", names)
+	}
+}
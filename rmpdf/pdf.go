@@ -10,13 +10,11 @@ package rmpdf
 
 import (
 	"fmt"
-	"io"
+	"math"
 
 	"github.com/jung-kurt/gofpdf"
-	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
 	"github.com/rorycl/rm2pdf/files"
-	"github.com/rorycl/rm2pdf/penconfig"
-	"github.com/rorycl/rm2pdf/rmparse"
+	"github.com/rorycl/rm2pdf/rmlines"
 )
 
 // reMarkable png templates (in /usr/share/remarkable/templates) are
@@ -38,312 +36,380 @@ const MMtoRMPoints = 2.83465
 // 2.2253
 const Pts2RMPoints = 2.222 // eyeballed conversion
 
-// LayerRegister is a Layer names
-var LayerRegister = map[string]int{}
-
-// UnknownPens is an unknown pen register
-var UnknownPens = make(map[int]int)
-
-// List of colours to use, by layer, for pens with ColourOverride ==
-// true
-var layerColours = []LocalColour{}
-
-// penConfigs are the set of custom pen settings by layer
-var penConfigs = make(penconfig.LayerPenConfigs)
-
-// Extract layer id from register by name, first initialising the PDF
-// layerid for that name if necessary
-func layerIDFromRegister(name string, pdf *gofpdf.Fpdf) int {
-	if _, ok := LayerRegister[name]; !ok {
-		LayerRegister[name] = pdf.AddLayer(name, true) // true == visible
+// Extract layer id from the conversion's register by name, first
+// initialising the PDF layerid for that name if necessary
+func (c *conversion) layerID(name string, visible bool) int {
+	if _, ok := c.layerRegister[name]; !ok {
+		c.layerRegister[name] = c.pdf.AddLayer(name, visible)
 	}
-	return LayerRegister[name]
+	return c.layerRegister[name]
 }
 
-// Construct a pdf page with layers from rm files described by rmf
-// RMFileInfo at 0-indexed page number, to be added to pdf. The existing
-// pdf (annotated pdf, template pdf, or embedded template), described by
-// sourceFH, is put in a "Background" layer, and the other .rm file
-// layers are put into subsequent layers with a default PDF visibility
-// of "true".
+// Construct a pdf page with layers from rm files described by the
+// conversion's RMFileInfo, to be added to the conversion's pdf. The
+// existing pdf (annotated pdf, template pdf, or embedded template),
+// described by the page's sourceFH, or an image template, is put in a
+// "Background" layer, together with the lines and dots of any stock
+// template, and the marks of each .rm file layer, made by pageMarks,
+// are put into subsequent layers with a default PDF visibility of
+// "true". The page is the size of the background pdf page, and marks
+// are placed on the page as the tablet displays it.
 //
-// Note that eraser types are presently skipped.
-func constructPageWithLayers(rmf files.RMFileInfo, rmPageNo, pdfPageNo int, useTemplate bool, sourceFH *io.ReadSeeker, pdf *gofpdf.Fpdf) error {
+// Eraser strokes are not drawn, but remove the areas they erase from
+// the strokes drawn before them in the same layer.
+func (c *conversion) constructPageWithLayers(pg pageJob, marks []layerMarks) {
 
-	// add a new page
-	pdf.AddPage()
+	rmf, pdf := c.rmf, c.pdf
 
-	// add the base PDF within a PDF layer named "Background". Only A4
-	// import files are presently supported, but it should be possible
-	// to use base PDFs of other sizes although I can't find a
-	// convenient way of determining the size of an imported page in go.
-	layerID := layerIDFromRegister("Background", pdf)
-	pdf.BeginLayer(layerID)
+	// add a new page the size of the background page
+	pdf.AddPageFormat("P", gofpdf.SizeType{Wd: pg.width, Ht: pg.height})
 
-	rmf.Debug(fmt.Sprintf("%s rm page %d pdf page %d", rmf.IdentifyPDF(useTemplate), rmPageNo+1, pdfPageNo+1))
+	// add the base PDF within a PDF layer named "Background"
+	layerID := c.layerID("Background", true)
+	pdf.BeginLayer(layerID)
 
-	// if an annotated pdf is provided, use the next page from that
-	// if using the A4 template, recycle page use, based on output from
-	// rmf.PageIterate from caller, whose pagenumbers are 0-indexed
-	pdfImportPage := pdfPageNo + 1
+	source := rmf.IdentifyPDF(false)
+	if pg.isTemplate {
+		source, _ = rmf.TemplatePath(pg.rmPageNo)
+	}
+	c.debug(fmt.Sprintf("%s rm page %d pdf page %d", source, pg.rmPageNo+1, pg.pdfPageNo+1))
 
-	bgpdf := gofpdi.ImportPageFromStream(pdf, sourceFH, pdfImportPage, "/MediaBox")
-	rmf.Debug(fmt.Sprintf("orientation %s", rmf.Orientation))
-	if rmf.Orientation == "portrait" {
-		gofpdi.UseImportedTemplate(pdf, bgpdf, 0, 0, 210*MMtoRMPoints, 297*MMtoRMPoints)
+	c.debug(fmt.Sprintf("orientation %s page size %.2f x %.2f", rmf.Orientation, pg.width, pg.height))
+	t := newPageTransform(rmf.Orientation, pg.width, pg.height)
+	if pg.image != nil {
+		drawTemplateImage(pdf, pg.image, t)
 	} else {
-		gofpdi.UseImportedTemplate(pdf, bgpdf, 0, 0, 297*MMtoRMPoints, 210*MMtoRMPoints)
+		// if an annotated pdf is provided, use the next page from that
+		// if using the A4 template, recycle page use, based on output from
+		// rmf.PageIterate from caller, whose pagenumbers are 0-indexed
+		sourceFH, pdfImportPage, box := c.importSource(pg)
+		bgpdf := c.importer.ImportPageFromStream(pdf, sourceFH, pdfImportPage, box)
+		c.importer.UseImportedTemplate(pdf, bgpdf, 0, 0, pg.width, pg.height)
+	}
+	if pg.template != nil {
+		drawTemplate(pdf, pg.template.marks(t))
 	}
 	pdf.EndLayer()
 
-	// Initialise the .rm file parser if the .rm file exists, else return
-	if rmPageNo > len(rmf.Pages)-1 {
-		rmf.Debug(fmt.Sprintf("no rm file for page %d ...skipping", rmPageNo+1))
-		return nil
-	}
-	rmPage := rmf.Pages[rmPageNo]
-	rmf.Debug(fmt.Sprintf("rmfile %s", rmPage.RMFilePath()))
-	rm, err := rmparse.RMParse(rmPage.RMFile())
-	if err != nil {
-		return err
-	}
+	// pdflayers are dealt with sequentially, and strokes within each
+	// layer are dealt with on a per-pen basis
+	for _, layer := range marks {
 
-	// set custom colours for layers, if provided
-	pageLayerColours := map[int]LocalColour{}
-	for c := 0; c < len(rmPage.LayerNames); c++ {
-		if c <= len(layerColours)-1 {
-			pageLayerColours[c] = layerColours[c]
-		}
-	}
+		layerID = c.layerID(layer.name, layer.visible)
+		pdf.BeginLayer(layerID)
 
-	// layer setup
-	// note that layers recorded in RMParse are 1-indexed, while the
-	// LayerNames are 0 indexed
-	layerNo := 1
-	layerName := rmPage.LayerNames[layerNo-1]
-	layerID = layerIDFromRegister(layerName, pdf)
-	pdf.BeginLayer(layerID)
-	rmf.Debug(fmt.Sprintf("Beginning layer %d", layerNo))
+		for _, s := range layer.strokes {
 
-	// start parsing; note that pdflayers are dealt with sequentially
-	// rm.Parse works on a per-path basis, implicitly therefore on a
-	// per-pen basis
-	pathNum := 0
-	for rm.Parse() {
-
-		// start a new PDF layer if necessary
-		if rm.Path.Layer != uint32(layerNo) {
-			pdf.EndLayer()
-			layerNo++
-			rmf.Debug(fmt.Sprintf("Beginning layer %d", layerNo))
-			layerName := rmPage.LayerNames[layerNo-1]
-			layerID = layerIDFromRegister(layerName, pdf)
-			pdf.BeginLayer(layerID)
-		}
+			// set stroke colour, transparent fill color and line width
+			// if opacity is not 1.0, set the alpha blending channel to the
+			// required fraction of 1.0
+			p := s.pen
+			pdf.SetDrawColor(p.r, p.g, p.b)
+			pdf.SetLineWidth(s.width)
 
-		path := rm.Path.Path
+			// set opacity
+			if p.opacity != 1.0 {
+				pdf.SetAlpha(p.opacity, "Normal")
+			}
 
-		// Skip eraser types
-		penName := StrokeMap[int(path.Pen)]
-		if penName == "eraser" || penName == "erase area" {
-			continue
-		}
+			// variable width strokes are drawn as filled outlines,
+			// varying in width from point to point with the pen
+			// pressure or the width recorded by the tablet
+			if p.setting.Variable() {
+				pdf.SetFillColor(pdf.GetDrawColor())
+			}
 
-		// set stroke colour, transparent fill color and line width
-		// if opacity is not 1.0, set the alpha blending channel to the
-		// required fraction of 1.0
-		// Also record if an pen type is not found.
-		penName, ok := StrokeMap[int(path.Pen)]
-		if !ok {
-			UnknownPens[int(path.Pen)]++
-			penName = "fineliner"
-		}
-		ss := StrokeSettings[penName]
-
-		width := ss.Width(path.Width)
-		opacity := ss.Opacity // inclusive range [0,1]
-
-		// load custom pen settings if any exist
-		penWidthName := ss.NaturalWidth(path.Width)
-		customPen, ok := penConfigs.GetPen(layerNo-1, penName, penWidthName)
-		if ok {
-			rmf.Debug(fmt.Sprintf("  path %4d : using custom pen %+v", pathNum, customPen))
-			width = customPen.Width
-			opacity = customPen.Opacity
-		}
+			// clip out the areas erased after this stroke was drawn
+			clipped := clipErasedAreas(pdf, layer.erased, s.strokeNo, s.box)
 
-		// set colours, first checking to see if there is a custom pen
-		// defined in the configuration file, then setting a colour
-		// override if set
-		//
-		// pdf.SetFillSpotColor("White", 100) // 0% tint
-		var layerCustomColour LocalColour
-
-		layerCustomColour, ok = pageLayerColours[layerNo-1]
-		if ok {
-			rmf.Debug(fmt.Sprintf("  path %4d : using general layer colour %s", pathNum, layerCustomColour.Name))
-			pdf.SetDrawColor(ss.selectColour(&layerCustomColour, false))
-		} else if customPen != nil {
-			// force
-			pdf.SetDrawColor(ss.selectColour(
-				&LocalColour{customPen.Colour.Name, customPen.Colour.Colour},
-				true,
-			))
-		}
+			if p.setting.Variable() {
+				if len(s.outline) > 0 {
+					pdf.Polygon(s.outline, "F")
+				}
+			} else {
+				for i, point := range s.points {
+					if i == 0 {
+						pdf.MoveTo(point.X, point.Y)
+					} else {
+						pdf.LineTo(point.X, point.Y)
+					}
+				}
+				pdf.DrawPath("D") // outlined only; use FD for filled and outlined
+			}
 
-		// set width
-		pdf.SetLineWidth(width)
+			if clipped {
+				endClip(pdf)
+			}
 
-		// set opacity
-		if opacity != 1.0 {
-			pdf.SetAlpha(opacity, "Normal")
+			// reset opacity
+			if p.opacity != 1.0 {
+				pdf.SetAlpha(1.0, "Normal")
+			}
 		}
 
-		// rmf.Debug(fmt.Sprintf("Pen : %s Width : %f, calcwidth %f, opacity %f", penName, path.Width, ss.Width(path.Width), ss.Opacity))
+		// close the layer
+		pdf.EndLayer()
+	}
+}
 
-		for s := 1; s <= int(path.NumSegments); s++ {
-			segment := rm.Path.Segments[s-1]
+// strokeMarks is a stroke placed on a page, ready to be drawn
+type strokeMarks struct {
+	strokeNo int // the index of the stroke in its layer
+	pen      pen
+	width    float64 // the line width, scaled to the page
+	points   []gofpdf.PointType
+	outline  []gofpdf.PointType // the outline of variable width strokes
+	box      bbox               // the area covered by the stroke
+}
 
-			// write rm segment to pdf path
-			if rmf.Orientation == "portrait" {
-				// portrait
-				if s == 1 {
-					pdf.MoveTo(float64(segment.X/Pts2RMPoints),
-						float64(segment.Y/Pts2RMPoints))
-				} else {
-					pdf.LineTo(float64(segment.X/Pts2RMPoints),
-						float64(segment.Y/Pts2RMPoints))
-				}
-			} else {
-				// landscape format files need to be flipped
-				yBasis := (297 * MMtoRMPoints)
-				if s == 1 {
-					pdf.MoveTo(yBasis-float64(segment.Y/Pts2RMPoints),
-						float64(segment.X/Pts2RMPoints))
-				} else {
-					pdf.LineTo(yBasis-float64(segment.Y/Pts2RMPoints),
-						float64(segment.X/Pts2RMPoints))
-				}
-			}
-		}
-		pdf.DrawPath("D") // outlined only; use FD for filled and outlined
+// layerMarks are the strokes of a layer placed on a page, with the
+// areas erased in the layer. Eraser strokes are not included.
+type layerMarks struct {
+	name    string
+	visible bool
+	erased  []erasedArea
+	strokes []strokeMarks
+}
 
-		// reset opacity
-		if opacity != 1.0 {
-			pdf.SetAlpha(1.0, "Normal")
-		}
+// pageMarks parses the .rm file of the 0-indexed page rmPageNo and
+// places the strokes of each layer on a page by t, returning no layers
+// for pages without an .rm file. pageMarks does not use the pdf, so
+// the marks of several pages may be made at once.
+func (c *conversion) pageMarks(rmPageNo int, t pageTransform) ([]layerMarks, error) {
 
-		pathNum++
+	// Initialise the .rm file parser if the .rm file exists, else return
+	rmPage, page, err := c.parsePage(rmPageNo)
+	if page == nil || err != nil {
+		return nil, err
 	}
 
-	// close the layer
-	pdf.EndLayer()
+	marks := make([]layerMarks, len(page.Layers))
+	pathNum := 0
+	for layerNo, layer := range page.Layers {
+
+		c.debug(fmt.Sprintf("Beginning layer %d", layerNo+1))
+		lm := layerMarks{
+			name:    layerName(*rmPage, layer, layerNo),
+			visible: layer.Visible,
+			// collect the areas erased in this layer
+			erased: c.erasedAreas(layerNo, layer, t),
+		}
+
+		for strokeNo, stroke := range layer.Strokes {
 
-	rmf.Debug(fmt.Sprintf("Maximum coordinates : %+v\n", rm.MaxCoordinates))
+			// Skip eraser types, which have been collected above
+			if isEraser(StrokeMap[stroke.Pen]) {
+				continue
+			}
 
-	return nil
+			p := c.strokePen(layerNo, stroke, pathNum)
+			width := t.width(p.width) // scaled to the page
+			points := t.points(stroke.Points)
+			widths, maxWidth := pointWidths(p.setting, width, stroke.Points)
+
+			sm := strokeMarks{
+				strokeNo: strokeNo,
+				pen:      p,
+				width:    width,
+				points:   points,
+				box:      pointsBox(points, maxWidth/2),
+			}
+			if p.setting.Variable() {
+				sm.outline = strokeOutline(points, widths)
+			}
+			lm.strokes = append(lm.strokes, sm)
+			pathNum++
+		}
+		marks[layerNo] = lm
+	}
+
+	return marks, nil
 }
 
-// RM2PDF is the main entry point for the programme. It takes a single
-// string pointing to a valid PDF file (or the replacement A4 template)
-// with an associated set of reMarkable metadata and .rm files. It then
-// makes a PDF page for each page in the original PDF (although the
-// template file's first page is recycled) and then adds each layer of
-// the associated page's .rm file on top of that, finally writing the
-// resulting pdf to outfile. Custom colours may be specified for each
-// layer. Settings may also be supplied from a settings configuration
-// file.
-func RM2PDF(inputpath, outfile, template, settings string, verbose bool, colours []LocalColour) error {
+// parsePage parses the .rm file of the 0-indexed page, returning a nil
+// page if the page has no .rm file
+func (c *conversion) parsePage(rmPageNo int) (*files.RMPage, *rmlines.Page, error) {
 
-	// initialise struct containing information about the files
-	rmfile, err := files.RMFiler(inputpath, template)
+	if rmPageNo > len(c.rmf.Pages)-1 || !c.rmf.Pages[rmPageNo].Exists {
+		c.debug(fmt.Sprintf("no rm file for page %d ...skipping", rmPageNo+1))
+		return nil, nil, nil
+	}
+	rmPage := &c.rmf.Pages[rmPageNo]
+	c.debug(fmt.Sprintf("rmfile %s", rmPage.RMFilePath()))
+	page, err := rmlines.Parse(rmPage.RMFile())
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	c.debug(fmt.Sprintf("rm file version %d", page.Version))
+	return rmPage, page, nil
+}
 
-	if verbose {
-		rmfile.Debugging = true
+// pen is the drawing style of a stroke, with its width before scaling
+// to the page
+type pen struct {
+	name    string
+	setting StrokeSetting
+	width   float64
+	opacity float64
+	r, g, b int
+}
+
+// strokePen determines the drawing style of a stroke in a layer from
+// the StrokeSettings, any custom pen settings and layer colours. Pens
+// which are not found are recorded and drawn as fineliners.
+func (c *conversion) strokePen(layerNo int, stroke rmlines.Stroke, pathNum int) pen {
+
+	penName, ok := StrokeMap[stroke.Pen]
+	if !ok {
+		c.mu.Lock()
+		c.unknownPens[stroke.Pen]++
+		c.mu.Unlock()
+		penName = "fineliner"
 	}
+	ss := StrokeSettings[penName]
 
-	if (rmfile.OriginalPageCount != rmfile.OriginalPageCount) && template == "" {
-		return fmt.Errorf(
-			"bundle has inserted page/s %s and no template was provided",
-			rmfile.InsertedPages(),
+	p := pen{
+		name:    penName,
+		width:   ss.Width(stroke.Width),
+		opacity: ss.Opacity, // inclusive range [0,1]
+	}
+
+	// load custom pen settings if any exist
+	penWidthName := ss.NaturalWidth(stroke.Width)
+	customPen, ok := c.penConfigs.GetPen(layerNo, penName, penWidthName)
+	if ok {
+		c.debugPath(penName, fmt.Sprintf("  path %4d : using custom pen %+v", pathNum, customPen))
+		p.width = customPen.Width
+		p.opacity = customPen.Opacity
+		if customPen.Pressure != nil {
+			ss.Pressure = *customPen.Pressure
+		}
+		if customPen.Tilt != nil {
+			ss.Tilt = *customPen.Tilt
+		}
+	}
+
+	// set colours, first checking to see if there is a general layer
+	// colour, then a custom pen defined in the configuration file
+	//
+	// pdf.SetFillSpotColor("White", 100) // 0% tint
+	if layerCustomColour, ok := c.layerColour(layerNo); ok {
+		c.debugPath(penName, fmt.Sprintf("  path %4d : using general layer colour %s", pathNum, layerCustomColour.Name))
+		p.r, p.g, p.b = ss.selectColour(&layerCustomColour, false)
+	} else if customPen != nil {
+		// force
+		p.r, p.g, p.b = ss.selectColour(
+			&LocalColour{customPen.Colour.Name, customPen.Colour.Colour},
+			true,
 		)
 	}
 
-	// See fpdf PageSize example
-	var pdf *gofpdf.Fpdf
-	if rmfile.Orientation == "portrait" {
-		pdf = gofpdf.NewCustom(&gofpdf.InitType{
-			UnitStr: "pt",
-			Size: gofpdf.SizeType{
-				Ht: PDFHeightInMM * MMtoRMPoints,
-				Wd: PDFWidthInMM * MMtoRMPoints,
-			},
-		})
-	} else {
-		pdf = gofpdf.NewCustom(&gofpdf.InitType{
-			UnitStr: "pt",
-			Size: gofpdf.SizeType{
-				Wd: PDFHeightInMM * MMtoRMPoints,
-				Ht: PDFWidthInMM * MMtoRMPoints,
-			},
-		})
+	p.setting = ss
+	return p
+}
+
+// debugPath logs a message about a drawn path; erasers are not drawn
+func (c *conversion) debugPath(penName, d string) {
+	if !isEraser(penName) {
+		c.debug(d)
 	}
+}
 
-	// set custom layer colours if provided
-	if len(colours) > 0 {
-		layerColours = colours
+// layerColour returns the custom colour for a layer, if provided
+func (c *conversion) layerColour(layerNo int) (LocalColour, bool) {
+	if layerNo < len(c.layerColours) {
+		return c.layerColours[layerNo], true
 	}
+	return LocalColour{}, false
+}
 
-	// pen configuration file
-	if settings != "" {
-		var err error
-		penConfigs, err = penconfig.NewPenConfigFromFile(settings)
-		if err != nil {
-			return fmt.Errorf("settings file load error: %w", err)
+// erasedAreas collects the areas erased by the eraser strokes in a
+// layer, placed on the page by t
+func (c *conversion) erasedAreas(layerNo int, layer rmlines.Layer, t pageTransform) []erasedArea {
+
+	erased := []erasedArea{}
+	for strokeNo, stroke := range layer.Strokes {
+		penName := StrokeMap[stroke.Pen]
+		if !isEraser(penName) {
+			continue
 		}
+		p := c.strokePen(layerNo, stroke, 0)
+		points := t.points(stroke.Points)
+		erased = append(erased, eraserAreas(strokeNo, penName, points, t.width(p.width))...)
 	}
+	return erased
+}
+
+// pointWidths returns the widths of a variable width stroke of the
+// given width at each point, and the widest of these, which is width
+// for strokes of a single width
+func pointWidths(ss StrokeSetting, width float64, points []rmlines.Point) ([]float64, float64) {
 
-	// Make colour (White in CMYK notation) for transparent fill
-	// pdf.AddSpotColor("White", 0, 0, 0, 0)
-
-	// Add general line styles
-	pdf.SetLineCapStyle("round")
-	pdf.SetLineJoinStyle("round")
-
-	// Iterate over pages using the rmfile iterator which provides a
-	// page number and the pdf to use (either the annotated pdf or the
-	// template). For annotated pdfs with inserted pages one might
-	// receive the following output from the iterator:
-	// pageno | inserted | template      | templatepageno
-	// -------+----------+---------------+---------------
-	// 0      | no       | annotated.pdf | 0
-	// 1      | yes      | template.pdf  | 0
-	// 2      | no       | annotated.pdf | 1
-
-	// Iterate over each page in the pdf
-	for i := 0; i < rmfile.PageCount; i++ {
-		pageNo, pdfPageNo, inserted, isTemplate, pdfFH := rmfile.PageIterate()
-		rmfile.Debug(fmt.Sprintf(
-			"processing page %d %d inserted %t template %t",
-			pageNo, pdfPageNo, inserted, isTemplate,
-		))
-		constructPageWithLayers(rmfile, pageNo, pdfPageNo, isTemplate, pdfFH, pdf)
+	if !ss.Variable() {
+		return nil, width
 	}
 
-	err = pdf.OutputFileAndClose(outfile)
-	if err != nil {
-		return err
+	// the mean width recorded by the tablet, to which the width at each
+	// point is compared
+	mean := 0.0
+	for _, point := range points {
+		mean += float64(point.Width) / float64(len(points))
 	}
 
-	if len(UnknownPens) > 0 {
-		fmt.Println("Some pen types were not found, and were forced to the fineliner style")
-		for k, v := range UnknownPens {
-			fmt.Printf("pen: %02d occurrences: %d\n", k, v)
+	widths := make([]float64, len(points))
+	maxWidth := width
+	for s, point := range points {
+		recorded := 0.0
+		if mean > 0 {
+			recorded = float64(point.Width) / mean
 		}
+		widths[s] = ss.PointWidth(width, point.Pressure, recorded)
+		maxWidth = math.Max(maxWidth, widths[s])
 	}
+	return widths, maxWidth
+}
 
-	return nil
+// layerName determines the name of a layer, preferring the name in the
+// rm file's metadata file, then the name in the .rm file (version 6
+// files) and finally a name made from the layer number
+func layerName(rmPage files.RMPage, layer rmlines.Layer, layerNo int) string {
+	if layerNo < len(rmPage.LayerNames) && rmPage.LayerNames[layerNo] != "" {
+		return rmPage.LayerNames[layerNo]
+	}
+	if layer.Name != "" {
+		return layer.Name
+	}
+	return fmt.Sprintf("Layer %d", layerNo+1)
+}
+
+// RM2PDF is the main entry point for the programme. It takes a single
+// string pointing to a valid PDF file (or the replacement A4 template)
+// with an associated set of reMarkable metadata and .rm files. It then
+// makes a PDF page for each page in the original PDF (although the
+// template file's first page is recycled) and then adds each layer of
+// the associated page's .rm file on top of that, finally writing the
+// resulting pdf to outfile. Custom colours may be specified for each
+// layer. Settings may also be supplied from a settings configuration
+// file.
+//
+// Further options, such as WithPageRanges, WithAnnotatedOnly and
+// WithInsertedOnly to convert only some pages, may be provided.
+//
+// RM2PDF makes a Converter for a single conversion; use a Converter
+// directly to convert several files with the same options.
+func RM2PDF(inputpath, outfile, template, settings string, verbose bool, colours []LocalColour, extra ...Option) error {
+
+	options := []Option{
+		WithTemplate(template),
+		WithLayerColours(colours),
+		WithVerbose(verbose),
+	}
+	if settings != "" {
+		options = append(options, WithPenConfigFile(settings))
+	}
+	converter, err := NewConverter(append(options, extra...)...)
+	if err != nil {
+		return err
+	}
+	return converter.Convert(inputpath, outfile)
 }
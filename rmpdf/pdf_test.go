@@ -16,6 +16,8 @@ import (
 	"testing"
 
 	"github.com/rorycl/rm2pdf/pdfutil"
+	"github.com/rorycl/rm2pdf/penconfig"
+	"github.com/rorycl/rm2pdf/rmlines"
 )
 
 // Test converting a PDF and associated files
@@ -228,3 +230,127 @@ func TestConvertZipNoMetadata(t *testing.T) {
 		t.Errorf("pdf pages should be 1, got %d", thisPDF.Pages)
 	}
 }
+
+// TestConvertZipVersion6 tests converting an rm zip file bundle made by
+// reMarkable software version 3, which writes version 6 .rm files
+func TestConvertZipVersion6(t *testing.T) {
+
+	file := "../testfiles/version3.zip"
+	template := ""
+
+	// make temporary file
+	tmpfile, err := ioutil.TempFile("", "example")
+	if err != nil {
+		t.Error(err)
+	}
+	tname := tmpfile.Name()
+	tname = tname + ".pdf"
+	defer os.Remove(tname)
+
+	err = RM2PDF(file, tname, template, "", false, []LocalColour{})
+	if err != nil {
+		t.Errorf("An rm2pdf error occurred: %v", err)
+	}
+
+	thisPDF, err := pdfutil.NewPDFFile(tname)
+	if err != nil {
+		t.Fatalf("could not get pdf info %s", err)
+	}
+	if thisPDF.Pages != 2 {
+		t.Errorf("pdf pages should be 2, got %d", thisPDF.Pages)
+	}
+}
+
+// TestConvertVariableWidth tests drawing strokes which vary in width
+// with pen pressure and recorded width, turned on for the pen and fineliner by
+// a settings file
+func TestConvertVariableWidth(t *testing.T) {
+
+	configFile, err := ioutil.TempFile("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(configFile.Name())
+	_, err = configFile.Write([]byte(`
+---
+all:
+  - pen:      pen
+    weight:   standard
+    width:    2.0
+    color:    black
+    opacity:  0.8
+    pressure: true
+    tilt:     true
+  - pen:      fineliner
+    weight:   standard
+    width:    1.0
+    color:    blue
+    opacity:  1
+    pressure: true
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile.Close()
+
+	for _, file := range []string{
+		"../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7",
+		"../testfiles/version3.zip",
+	} {
+		tmpfile, err := ioutil.TempFile("", "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tname := tmpfile.Name() + ".pdf"
+		os.Remove(tmpfile.Name())
+		defer os.Remove(tname)
+
+		err = RM2PDF(file, tname, "", configFile.Name(), false, []LocalColour{})
+		if err != nil {
+			t.Errorf("An rm2pdf error occurred: %v", err)
+		}
+		_, err = pdfutil.NewPDFFile(tname)
+		if err != nil {
+			t.Errorf("could not get pdf info %s", err)
+		}
+	}
+}
+
+// TestStrokePenVersion6 tests that the narrow pens of a version 6 file
+// use the custom settings for narrow pens
+func TestStrokePenVersion6(t *testing.T) {
+
+	f, err := os.Open("../testfiles/version6.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	page, err := rmlines.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lpc, err := penconfig.LoadYaml([]byte(`
+all:
+  - pen:     pen
+    weight:  narrow
+    width:   0.5
+    color:   red
+    opacity: 1
+  - pen:     pen
+    weight:  broad
+    width:   5.0
+    color:   blue
+    opacity: 1`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &conversion{Converter: &Converter{penConfigs: lpc}, unknownPens: map[int]int{}}
+
+	// the last stroke is a pen with a thickness scale of 1.338
+	strokes := page.Layers[1].Strokes
+	p := c.strokePen(1, strokes[len(strokes)-1], 0)
+	if p.name != "pen" || p.width != 0.5 {
+		t.Errorf("pen %s width %f not the narrow pen width 0.5", p.name, p.width)
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Draw the pages of a conversion, making the marks of each page with a
+pool of workers.
+
+Parsing .rm files and placing their strokes on the page does not use
+the pdf, so the marks of several pages are made at once, while the
+pages are added to the pdf in order as their marks are ready.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/rorycl/rm2pdf/files"
+)
+
+// pageJob describes a page of a conversion and its background
+type pageJob struct {
+	rmPageNo      int // 0-indexed page of the bundle
+	pdfPageNo     int // 0-indexed page of the background pdf
+	inserted      bool
+	isTemplate    bool
+	sourceFH      *io.ReadSeeker       // the background pdf
+	width, height float64              // the page size in points
+	template      *templateStyle       // a stock template to draw, if any
+	image         *files.TemplateImage // an image template, in place of sourceFH
+}
+
+// pageResult holds the marks made for a page
+type pageResult struct {
+	marks []layerMarks
+	err   error
+}
+
+// pageJobs lists the pages of the conversion selected by the
+// Converter's options, reading the background page sizes, or returns
+// ErrNoPages if no pages are selected. An error is returned if the
+// template page map names pages which are not template pages, or
+// template pages which do not exist.
+func (c *conversion) pageJobs() ([]pageJob, error) {
+
+	for pageNo := range c.templateMap {
+		if pageNo >= c.rmf.PageCount {
+			return nil, fmt.Errorf("template page map: no page %d in the bundle", pageNo+1)
+		}
+	}
+
+	jobs := []pageJob{}
+	for i := 0; i < c.rmf.PageCount; i++ {
+		pageNo, pdfPageNo, inserted, isTemplate, pdfFH := c.rmf.PageIterate()
+		c.debug(fmt.Sprintf(
+			"processing page %d %d inserted %t template %t",
+			pageNo, pdfPageNo, inserted, isTemplate,
+		))
+		if tp, ok := c.templateMap[pageNo]; ok {
+			switch {
+			case !isTemplate:
+				return nil, fmt.Errorf("template page map: page %d is not a template page", pageNo+1)
+			case tp != pdfPageNo:
+				return nil, fmt.Errorf("template page map: page %d of the template for page %d does not exist", tp+1, pageNo+1)
+			}
+		}
+		pg := pageJob{
+			rmPageNo:   pageNo,
+			pdfPageNo:  pdfPageNo,
+			inserted:   inserted,
+			isTemplate: isTemplate,
+			sourceFH:   pdfFH,
+		}
+		if !c.selected(pg) {
+			c.debug(fmt.Sprintf("page %d not selected", pageNo+1))
+			continue
+		}
+		pg.width, pg.height = c.pageSize(pdfFH, pdfPageNo, isTemplate)
+		if isTemplate {
+			if ts, ok := c.pageTemplate(pageNo); ok {
+				pg.template = &ts
+			}
+			if img, ok := c.rmf.TemplateImage(pageNo); ok {
+				pg.image = img
+			}
+		}
+		jobs = append(jobs, pg)
+	}
+	if len(jobs) == 0 {
+		return nil, ErrNoPages
+	}
+	return jobs, nil
+}
+
+// drawPages adds each page to the pdf in order, with the marks of the
+// pages made by the conversion's workers. At most two pages per worker
+// are made ahead of the page being added, to bound the memory used by
+// long documents. Pages whose marks could not be made are recorded and
+// added without marks. If writing annotations, the marks are kept as
+// ink annotations, and pages are not added to the pdf if the annotated
+// pdf is used unchanged.
+func (c *conversion) drawPages(jobs []pageJob) {
+
+	workers := c.workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]chan pageResult, len(jobs))
+	for i := range results {
+		results[i] = make(chan pageResult, 1)
+	}
+
+	window := make(chan struct{}, 2*workers)
+	queue := make(chan int)
+	go func() {
+		for i := range jobs {
+			window <- struct{}{}
+			queue <- i
+		}
+		close(queue)
+	}()
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			for i := range queue {
+				pg := jobs[i]
+				t := newPageTransform(c.rmf.Orientation, pg.width, pg.height)
+				marks, err := c.pageMarks(pg.rmPageNo, t)
+				results[i] <- pageResult{marks, err}
+			}
+		}()
+	}
+
+	for i, pg := range jobs {
+		r := <-results[i]
+		<-window
+		if r.err != nil {
+			c.skippedPages[pg.rmPageNo] = r.err
+		}
+		if !c.annotations {
+			c.constructPageWithLayers(pg, r.marks)
+			continue
+		}
+		if c.original == nil {
+			c.constructPageWithLayers(pg, nil)
+		}
+		if inks := inkAnnotations(r.marks); len(inks) > 0 {
+			c.inks[i+1] = inks
+		}
+	}
+}
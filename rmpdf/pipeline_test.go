@@ -0,0 +1,168 @@
+/*
+pipeline_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// notebook makes a notebook bundle of pageCount pages, using the .rm
+// files of the test bundles in turn, returning its path
+func notebook(tb testing.TB, pageCount int) string {
+	tb.Helper()
+
+	sources := []string{
+		"../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7/2c277cdb-79a5-4f69-b583-4901d944e77e",
+		"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224",
+		"../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/7794dbce-2506-4fb0-99fd-9ec031426d57",
+	}
+
+	const id = "20000000-0000-0000-0000-000000000001"
+	dir := tb.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, id), 0755); err != nil {
+		tb.Fatal(err)
+	}
+	copyFile := func(from, to string) {
+		b, err := os.ReadFile(from)
+		if err == nil {
+			err = os.WriteFile(to, b, 0644)
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+
+	pages := make([]string, pageCount)
+	for i := range pages {
+		pages[i] = fmt.Sprintf("30000000-0000-0000-0000-%012d", i)
+		source := sources[i%len(sources)]
+		copyFile(source+".rm", filepath.Join(dir, id, pages[i]+".rm"))
+		copyFile(source+"-metadata.json", filepath.Join(dir, id, pages[i]+"-metadata.json"))
+	}
+	content, err := json.Marshal(map[string]any{
+		"fileType":    "notebook",
+		"orientation": "portrait",
+		"pageCount":   pageCount,
+		"pages":       pages,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, id+".content"), content, 0644); err != nil {
+		tb.Fatal(err)
+	}
+	return filepath.Join(dir, id)
+}
+
+// pageContents returns the content stream of each page of a pdf
+func pageContents(t *testing.T, pdf []byte) [][]byte {
+	t.Helper()
+	ctx, err := api.ReadContext(bytes.NewReader(pdf), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		t.Fatal(err)
+	}
+	contents := [][]byte{}
+	for i := 1; i <= ctx.PageCount; i++ {
+		r, err := pdfcpu.ExtractPageContent(ctx, i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contents = append(contents, b)
+	}
+	return contents
+}
+
+// TestDrawPagesInOrder tests that pages made by several workers are
+// the same, and in the same order, as pages made by one
+func TestDrawPagesInOrder(t *testing.T) {
+
+	const pageCount = 12
+	bundle := notebook(t, pageCount)
+
+	convert := func(workers int) [][]byte {
+		t.Helper()
+		c, err := NewConverter(WithWorkers(workers), WithLogger(log.New(io.Discard, "", 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := c.ConvertTo(bundle, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return pageContents(t, buf.Bytes())
+	}
+
+	sequential, concurrent := convert(1), convert(4)
+	if len(sequential) != pageCount || len(concurrent) != pageCount {
+		t.Fatalf("pages %d and %d not %d", len(sequential), len(concurrent), pageCount)
+	}
+	for i := range sequential {
+		if !bytes.Equal(sequential[i], concurrent[i]) {
+			t.Errorf("page %d differs", i+1)
+		}
+	}
+	if bytes.Equal(sequential[0], sequential[1]) {
+		t.Error("pages from different .rm files should differ")
+	}
+
+	if _, err := NewConverter(WithWorkers(0)); err == nil {
+		t.Error("expected an error for 0 workers")
+	}
+}
+
+// BenchmarkConvert compares converting the test bundles, and a long
+// notebook, with one worker and with four. Any speedup depends on the
+// number of CPUs, and none is expected with a single CPU, so run with
+// several, for example
+//
+//	go test -run xxx -bench Convert -cpu 4 ./rmpdf
+func BenchmarkConvert(b *testing.B) {
+
+	bundles := []struct {
+		name string
+		path string
+	}{
+		{"pdf", "../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3.pdf"},
+		{"inserted", "../testfiles/fbe9f971-03ba-4c21-a0e8-78dd921f9c4c"},
+		{"landscape", "../testfiles/e724bba2-266f-434d-aaf2-935d2b405aee"},
+		{"notebook", "../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7"},
+		{"notebook-30", notebook(b, 30)},
+	}
+
+	for _, bundle := range bundles {
+		for _, workers := range []int{1, 4} {
+			b.Run(fmt.Sprintf("%s/workers-%d", bundle.name, workers), func(b *testing.B) {
+				c, err := NewConverter(WithWorkers(workers), WithLogger(log.New(io.Discard, "", 0)))
+				if err != nil {
+					b.Fatal(err)
+				}
+				for i := 0; i < b.N; i++ {
+					if err := c.ConvertTo(bundle.path, io.Discard); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
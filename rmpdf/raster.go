@@ -0,0 +1,242 @@
+/*
+Render the marks on each page of a reMarkable bundle as PNG or JPEG
+images.
+
+Strokes are styled as they are for PDF output and drawn with
+anti-aliasing, at a chosen resolution, over a white background, a
+chosen background image or the page's image template. The background
+PDF cannot be rasterised, but sets the size of each image. Each visible
+layer is drawn separately so that eraser strokes only remove the marks
+made before them in the same layer.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/vector"
+)
+
+// DefaultDPI is the default resolution of raster images
+const DefaultDPI = 100
+
+// JPEGQuality is the quality of JPEG images
+const JPEGQuality = 90
+
+// ErrImageFormat is returned for raster image file extensions other
+// than .png, .jpg and .jpeg
+var ErrImageFormat = errors.New("unsupported image format")
+
+// imageFormat returns the base and extension of a raster image file
+// name, adding a .png extension if there is none
+func imageFormat(outfile string) (string, string, error) {
+	ext := filepath.Ext(outfile)
+	switch strings.ToLower(ext) {
+	case ".png", ".jpg", ".jpeg":
+		return strings.TrimSuffix(outfile, ext), ext, nil
+	case "":
+		return outfile, ".png", nil
+	}
+	return "", "", fmt.Errorf("%w %s", ErrImageFormat, ext)
+}
+
+// ImageFileNames returns the names of the image files written for a
+// bundle of pageCount pages by ConvertImages to outfile, being outfile,
+// less its extension, followed by "-", the 1-indexed page number and
+// the extension
+func ImageFileNames(outfile string, pageCount int) ([]string, error) {
+	base, ext, err := imageFormat(outfile)
+	if err != nil {
+		return nil, err
+	}
+	return pageFileNames(base, ext, pageCount), nil
+}
+
+// ConvertImages converts the reMarkable bundle at inputpath, as for
+// Convert, to a raster image of each page named as set out by
+// ImageFileNames, returning the names of the files written. Images are
+// written as JPEG files if outfile has a .jpg or .jpeg extension, and
+// otherwise as PNG files. The resolution and background of the images
+// are set by WithDPI and WithRasterBackground.
+//
+// Existing files are overwritten unless the Converter was made with
+// WithNoClobber, in which case an error satisfying
+// errors.Is(err, fs.ErrExist) is returned before any file is written.
+func (cv *Converter) ConvertImages(inputpath, outfile string) ([]string, error) {
+
+	_, ext, err := imageFormat(outfile)
+	if err != nil {
+		return nil, err
+	}
+	encode := png.Encode
+	if ext = strings.ToLower(ext); ext == ".jpg" || ext == ".jpeg" {
+		encode = func(w io.Writer, img image.Image) error {
+			return jpeg.Encode(w, img, &jpeg.Options{Quality: JPEGQuality})
+		}
+	}
+
+	names := func(pageCount int) []string {
+		n, _ := ImageFileNames(outfile, pageCount)
+		return n
+	}
+	write := func(c *conversion, w io.Writer, rmPageNo, pdfPageNo int, useTemplate bool, sourceFH *io.ReadSeeker) error {
+		return encode(w, c.rasterPage(rmPageNo, pdfPageNo, useTemplate, sourceFH))
+	}
+	return cv.convertPages(inputpath, names, write)
+}
+
+// rasterPage draws the marks on the 0-indexed page rmPageNo, sized as
+// the pdfPageNo page of the background pdf. Pages whose .rm files
+// cannot be parsed are recorded and drawn without marks.
+func (c *conversion) rasterPage(rmPageNo, pdfPageNo int, useTemplate bool, sourceFH *io.ReadSeeker) *image.RGBA {
+
+	pageWidth, pageHeight := c.pageSize(sourceFH, pdfPageNo, useTemplate)
+	t := newPageTransform(c.rmf.Orientation, pageWidth, pageHeight)
+	scale := c.dpi / 72 // pixels per point
+
+	bounds := image.Rect(0, 0, int(math.Round(pageWidth*scale)), int(math.Round(pageHeight*scale)))
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, image.White, image.Point{}, draw.Src)
+	if c.background != nil {
+		xdraw.BiLinear.Scale(dst, bounds, c.background, c.background.Bounds(), draw.Over, nil)
+	} else if img, ok := c.rmf.TemplateImage(rmPageNo); ok && useTemplate {
+		c.rasterTemplateImage(dst, img, t, scale)
+	}
+
+	// pixels scales points on the page to pixels
+	pixels := func(points []gofpdf.PointType) []gofpdf.PointType {
+		for i := range points {
+			points[i].X *= scale
+			points[i].Y *= scale
+		}
+		return points
+	}
+
+	if ts, ok := c.pageTemplate(rmPageNo); ok && useTemplate {
+		tm := ts.marks(t)
+		polygons := [][]gofpdf.PointType{}
+		for _, l := range tm.lines {
+			polygons = append(polygons, strokeOutline(pixels(l[:]), []float64{tm.lineWidth * scale, tm.lineWidth * scale}))
+		}
+		for _, d := range pixels(tm.dots) {
+			polygons = append(polygons, strokeOutline([]gofpdf.PointType{d}, []float64{2 * tm.dotRadius * scale}))
+		}
+		grey := image.NewUniform(color.Gray{templateGrey})
+		r, mask := rasterMask(polygons, bbox{0, 0, float64(bounds.Dx()), float64(bounds.Dy())}, bounds)
+		draw.DrawMask(dst, r, grey, image.Point{}, mask, image.Point{}, draw.Over)
+	}
+
+	_, page, err := c.parsePage(rmPageNo)
+	if err != nil {
+		c.skippedPages[rmPageNo] = err
+	}
+	if page == nil {
+		return dst
+	}
+
+	pathNum := 0
+	layerImage := image.NewRGBA(bounds)
+	for layerNo, layer := range page.Layers {
+
+		if !layer.Visible {
+			c.debug(fmt.Sprintf("Skipping hidden layer %d", layerNo+1))
+			continue
+		}
+		c.debug(fmt.Sprintf("Beginning layer %d", layerNo+1))
+		draw.Draw(layerImage, bounds, image.Transparent, image.Point{}, draw.Src)
+
+		for strokeNo, stroke := range layer.Strokes {
+
+			p := c.strokePen(layerNo, stroke, pathNum)
+			ss := p.setting
+			width := t.width(p.width) * scale
+			points := pixels(t.points(stroke.Points))
+
+			// erasers remove the marks already drawn in the layer
+			if isEraser(p.name) {
+				polygons := [][]gofpdf.PointType{}
+				for _, a := range eraserAreas(strokeNo, p.name, points, width) {
+					polygons = append(polygons, a.polygon)
+				}
+				r, mask := rasterMask(polygons, pointsBox(points, width), bounds)
+				draw.DrawMask(layerImage, r, image.Transparent, image.Point{}, mask, image.Point{}, draw.Src)
+				continue
+			}
+
+			widths, maxWidth := pointWidths(ss, width, stroke.Points)
+			polygons := [][]gofpdf.PointType{}
+			if ss.Variable() {
+				polygons = append(polygons, strokeOutline(points, widths))
+			} else {
+				// lines of a single width are drawn as round ended
+				// segments, which also makes round joins
+				if len(points) == 1 {
+					polygons = append(polygons, strokeOutline(points, []float64{width}))
+				}
+				for i := 1; i < len(points); i++ {
+					polygons = append(polygons, strokeOutline(points[i-1:i+1], []float64{width, width}))
+				}
+			}
+
+			colour := color.NRGBA{
+				uint8(p.r), uint8(p.g), uint8(p.b),
+				uint8(math.Round(math.Max(0, math.Min(1, p.opacity)) * 255)),
+			}
+			r, mask := rasterMask(polygons, pointsBox(points, maxWidth/2), bounds)
+			draw.DrawMask(layerImage, r, image.NewUniform(colour), image.Point{}, mask, image.Point{}, draw.Over)
+			pathNum++
+		}
+
+		draw.Draw(dst, bounds, layerImage, image.Point{}, draw.Over)
+	}
+
+	return dst
+}
+
+// rasterMask fills polygons, in pixels, with anti-aliasing, returning
+// the part of bounds covered by box and the mask for that part. Filled
+// areas which overlap are covered once.
+func rasterMask(polygons [][]gofpdf.PointType, box bbox, bounds image.Rectangle) (image.Rectangle, *image.Alpha) {
+
+	r := image.Rect(
+		int(math.Floor(box.minX))-1, int(math.Floor(box.minY))-1,
+		int(math.Ceil(box.maxX))+1, int(math.Ceil(box.maxY))+1,
+	).Intersect(bounds)
+	mask := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	if r.Empty() {
+		return r, mask
+	}
+
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	for _, polygon := range polygons {
+		for i, p := range polygon {
+			x, y := float32(p.X-float64(r.Min.X)), float32(p.Y-float64(r.Min.Y))
+			if i == 0 {
+				z.MoveTo(x, y)
+			} else {
+				z.LineTo(x, y)
+			}
+		}
+		if len(polygon) > 0 {
+			z.ClosePath()
+		}
+	}
+	z.Draw(mask, mask.Bounds(), image.Opaque, image.Point{})
+	return r, mask
+}
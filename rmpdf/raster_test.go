@@ -0,0 +1,187 @@
+/*
+raster_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	colornames "golang.org/x/image/colornames"
+)
+
+// readImage decodes an image file
+func readImage(t *testing.T, name string) (image.Image, string) {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	img, format, err := image.Decode(f)
+	if err != nil {
+		t.Fatalf("%s could not be decoded: %v", name, err)
+	}
+	return img, format
+}
+
+// countColour counts the pixels in an image close to a colour
+func countColour(img image.Image, c color.Color) int {
+	n := 0
+	r0, g0, b0, _ := c.RGBA()
+	near := func(a, b uint32) bool {
+		return a-b < 0x1000 || b-a < 0x1000
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if near(r, r0) && near(g, g0) && near(b, b0) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// TestConvertImages tests writing raster images of each page
+func TestConvertImages(t *testing.T) {
+
+	dir := t.TempDir()
+
+	for _, test := range []struct {
+		outfile string
+		dpi     float64
+		format  string
+		name    string
+		width   int
+		height  int
+	}{
+		{"output.png", 72, "png", "output-01.png", 595, 842},
+		{"output.jpg", 144, "jpeg", "output-01.jpg", 1191, 1684},
+		{"output", 36, "png", "output-01.png", 298, 421},
+	} {
+		c, err := NewConverter(
+			WithDPI(test.dpi),
+			WithLayerColours([]LocalColour{{"red", colornames.Red}}),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names, err := c.ConvertImages("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3", filepath.Join(dir, test.outfile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 || filepath.Base(names[0]) != test.name {
+			t.Fatalf("unexpected file names %v", names)
+		}
+		img, format := readImage(t, names[0])
+		if format != test.format {
+			t.Errorf("%s format %s not %s", test.outfile, format, test.format)
+		}
+		if b := img.Bounds(); b.Dx() != test.width || b.Dy() != test.height {
+			t.Errorf("%s size %d x %d not %d x %d", test.outfile, b.Dx(), b.Dy(), test.width, test.height)
+		}
+		// the first layer is coloured red
+		if n := countColour(img, colornames.Red); n == 0 {
+			t.Errorf("%s has no red marks", test.outfile)
+		}
+	}
+
+	c, err := NewConverter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ConvertImages("../testfiles/version3.zip", filepath.Join(dir, "output.gif"))
+	if !errors.Is(err, ErrImageFormat) {
+		t.Errorf("expected ErrImageFormat, got %v", err)
+	}
+	if _, err := NewConverter(WithDPI(0)); err == nil {
+		t.Error("expected an error for a dpi of 0")
+	}
+}
+
+// TestRasterBackground tests drawing marks over a background image
+func TestRasterBackground(t *testing.T) {
+
+	dir := t.TempDir()
+	bgName := filepath.Join(dir, "background.png")
+	bg := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	for i := range bg.Pix {
+		bg.Pix[i] = 0xff
+		if i%4 == 2 {
+			bg.Pix[i] = 0 // yellow
+		}
+	}
+	f, err := os.Create(bgName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = png.Encode(f, bg); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	c, err := NewConverter(WithRasterBackground(bgName), WithDPI(36))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := c.ConvertImages("../testfiles/version3.zip", filepath.Join(dir, "output.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := readImage(t, names[0])
+	b := img.Bounds()
+	if n := countColour(img, colornames.Yellow); n < b.Dx()*b.Dy()/2 {
+		t.Errorf("only %d pixels of the background are yellow", n)
+	}
+
+	if _, err := NewConverter(WithRasterBackground(filepath.Join(dir, "missing.png"))); err == nil {
+		t.Error("expected an error for a missing background image")
+	}
+}
+
+// TestRasterMask tests filling overlapping and clipped polygons
+func TestRasterMask(t *testing.T) {
+
+	bounds := image.Rect(0, 0, 20, 20)
+	square := func(x, y float64) []gofpdf.PointType {
+		return []gofpdf.PointType{{X: x, Y: y}, {X: x + 10, Y: y}, {X: x + 10, Y: y + 10}, {X: x, Y: y + 10}}
+	}
+	// the second square runs off the image
+	polygons := [][]gofpdf.PointType{square(0, 0), square(5, 5), square(15, 15)}
+	box := bbox{0, 0, 25, 25}
+
+	r, mask := rasterMask(polygons, box, bounds)
+	if r != bounds {
+		t.Errorf("mask rectangle %v not %v", r, bounds)
+	}
+	for _, test := range []struct {
+		x, y  int
+		alpha uint8
+	}{
+		{2, 2, 0xff},
+		{7, 7, 0xff}, // overlapping squares are covered once
+		{12, 12, 0xff},
+		{17, 17, 0xff},
+		{17, 2, 0},
+	} {
+		if a := mask.AlphaAt(test.x-r.Min.X, test.y-r.Min.Y).A; a != test.alpha {
+			t.Errorf("alpha at %d/%d %d not %d", test.x, test.y, a, test.alpha)
+		}
+	}
+
+	if r, _ := rasterMask(polygons, bbox{30, 30, 40, 40}, bounds); !r.Empty() {
+		t.Errorf("a box outside the image should be empty, got %v", r)
+	}
+}
@@ -12,9 +12,22 @@ import (
 	"image/color"
 	"math"
 
+	"github.com/jung-kurt/gofpdf"
 	colornames "golang.org/x/image/colornames"
 )
 
+// Stroke width multipliers for variable width strokes
+const (
+	minPressureWidth = 0.4  // at no pressure
+	maxPressureWidth = 1.6  // at full pressure
+	minTiltWidth     = 0.25 // of the recorded width to its stroke mean
+	maxTiltWidth     = 2.5  // of the recorded width to its stroke mean
+)
+
+// capSteps is the number of segments in each round end of a variable
+// width stroke outline
+const capSteps = 8
+
 // StrokeSetting describes strokes from an .rm file in a pdf document.
 // Although Colours are defined as RGBA values, they all have solid
 // (255) Alpha values. The width of each stroke is a value representing
@@ -25,11 +38,20 @@ import (
 // The Alpha value is set separately using the Opacity value. The
 // ColourOverride property determines if the colour of the stroke may be
 // manually overridden by command-line options.
+//
+// Strokes with Pressure or Tilt set are drawn as filled outlines whose
+// width varies at each point, otherwise strokes are drawn as lines of a
+// single width. Pressure varies the width with the pressure of the pen.
+// Tilt varies the width with the width the tablet records at each
+// point, which includes the effect of the pen's pressure and tilt for
+// the pencils and paintbrush; the tilt itself is not recorded.
 type StrokeSetting struct {
 	Colour         color.RGBA
 	StdWidth       float32
 	Opacity        float64
 	ColourOverride bool
+	Pressure       bool
+	Tilt           bool
 }
 
 // StrokeMap is a Map of pen numbers in a reMarkable binary .rm file
@@ -82,21 +104,28 @@ var StrokeSettings = map[string]StrokeSetting{
 		Colour:   colornames.Slategray,
 		StdWidth: 1.75,
 		Opacity:  0.8,
+		Pressure: true,
 	},
 	"pencil": {
 		Colour:   colornames.Black,
 		StdWidth: 1.9,
 		Opacity:  1,
+		Pressure: true,
+		Tilt:     true,
 	},
 	"mechanical pencil": {
 		Colour:   colornames.Black,
 		StdWidth: 1.2,
 		Opacity:  0.7,
+		Pressure: true,
+		Tilt:     true,
 	},
 	"paint": {
 		Colour:   color.RGBA{55, 55, 55, 220}, // dark grey
 		StdWidth: 4.8,
 		Opacity:  0.8,
+		Pressure: true,
+		Tilt:     true,
 	},
 	"eraser": {
 		Colour:   colornames.White,
@@ -130,6 +159,32 @@ func (s *StrokeSetting) Width(penwidth float32) float64 {
 	return float64(r)
 }
 
+// Variable reports if strokes are drawn with a variable width
+func (s *StrokeSetting) Variable() bool {
+	return s.Pressure || s.Tilt
+}
+
+// PointWidth adjusts the width of a stroke at a point for the pressure
+// of the pen or the width recorded by the tablet, if set. If Tilt is
+// set, the width is scaled by recorded, the width recorded at the point
+// relative to the mean recorded width of the stroke, between
+// minTiltWidth and maxTiltWidth times; as the recorded width already
+// follows the pen pressure, pressure is then not used. Otherwise, if
+// Pressure is set, pressure, from 0 to 1, scales the width from
+// minPressureWidth to maxPressureWidth times the stroke width, with a
+// pressure of 0.5 giving the stroke width. A recorded width of 0 means
+// no width was recorded.
+func (s *StrokeSetting) PointWidth(width float64, pressure float32, recorded float64) float64 {
+	if s.Tilt && recorded > 0 {
+		return width * math.Max(minTiltWidth, math.Min(maxTiltWidth, recorded))
+	}
+	if s.Pressure {
+		p := math.Max(0, math.Min(1, float64(pressure)))
+		width *= minPressureWidth + p*(maxPressureWidth-minPressureWidth)
+	}
+	return width
+}
+
 // NaturalWidth reports pen widths as "narrow", "standard" or "broad"
 func (s *StrokeSetting) NaturalWidth(penwidth float32) string {
 
@@ -170,6 +225,87 @@ func (s *StrokeSetting) selectColour(lc *LocalColour, force bool) (int, int, int
 	return int(r), int(g), int(b)
 }
 
+// strokeOutline returns a polygon outlining a stroke through points,
+// with the given width at each point and round ends. Each side of the
+// stroke is offset from the points along the normal to the average
+// direction of the adjoining segments.
+func strokeOutline(points []gofpdf.PointType, widths []float64) []gofpdf.PointType {
+
+	// drop repeated points, which have no direction
+	pts := []gofpdf.PointType{}
+	radii := []float64{}
+	for i, p := range points {
+		if len(pts) > 0 && p == pts[len(pts)-1] {
+			continue
+		}
+		pts = append(pts, p)
+		radii = append(radii, widths[i]/2)
+	}
+	switch len(pts) {
+	case 0:
+		return nil
+	case 1:
+		return arc(pts[0], radii[0], 0, -2*math.Pi)
+	}
+
+	// unit direction of the segment from a to b
+	unit := func(a, b gofpdf.PointType) (float64, float64) {
+		l := math.Hypot(b.X-a.X, b.Y-a.Y)
+		return (b.X - a.X) / l, (b.Y - a.Y) / l
+	}
+
+	left := make([]gofpdf.PointType, len(pts))
+	right := make([]gofpdf.PointType, len(pts))
+	for i, p := range pts {
+		var dx, dy float64
+		if i > 0 {
+			ux, uy := unit(pts[i-1], p)
+			dx, dy = dx+ux, dy+uy
+		}
+		if i < len(pts)-1 {
+			ux, uy := unit(p, pts[i+1])
+			dx, dy = dx+ux, dy+uy
+		}
+		l := math.Hypot(dx, dy)
+		if l < 1e-9 {
+			// the stroke doubles back on itself
+			dx, dy = unit(pts[i-1], p)
+			l = 1
+		}
+		nx, ny := -dy/l*radii[i], dx/l*radii[i]
+		left[i] = gofpdf.PointType{X: p.X + nx, Y: p.Y + ny}
+		right[i] = gofpdf.PointType{X: p.X - nx, Y: p.Y - ny}
+	}
+
+	last := len(pts) - 1
+	ex, ey := unit(pts[last-1], pts[last])
+	sx, sy := unit(pts[0], pts[1])
+	endAngle := math.Atan2(ey, ex)
+	startAngle := math.Atan2(sy, sx)
+
+	outline := append([]gofpdf.PointType{}, left...)
+	outline = append(outline, arc(pts[last], radii[last], endAngle+math.Pi/2, endAngle-math.Pi/2)...)
+	for i := last; i >= 0; i-- {
+		outline = append(outline, right[i])
+	}
+	outline = append(outline, arc(pts[0], radii[0], startAngle-math.Pi/2, startAngle-3*math.Pi/2)...)
+	return outline
+}
+
+// arc returns the points on an arc of a circle from one angle to
+// another, in radians
+func arc(centre gofpdf.PointType, radius, from, to float64) []gofpdf.PointType {
+	points := make([]gofpdf.PointType, capSteps+1)
+	for i := range points {
+		a := from + (to-from)*float64(i)/capSteps
+		points[i] = gofpdf.PointType{
+			X: centre.X + radius*math.Cos(a),
+			Y: centre.Y + radius*math.Sin(a),
+		}
+	}
+	return points
+}
+
 // Return the cmyk components of the stroke's colour
 // func (s *StrokeSetting) toCMYK() color.CMYK {
 // 	return color.CMYKModel.Convert(s.Colour)
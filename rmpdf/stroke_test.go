@@ -0,0 +1,159 @@
+/*
+stroke_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"math"
+	"os"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/rmlines"
+)
+
+// TestPointWidth tests adjusting stroke widths for pressure and the
+// width recorded by the tablet
+func TestPointWidth(t *testing.T) {
+
+	for _, test := range []struct {
+		setting  StrokeSetting
+		pressure float32
+		recorded float64
+		expected float64
+	}{
+		{StrokeSetting{}, 0.9, 1.5, 2.0},
+		{StrokeSetting{Pressure: true}, 0.5, 1.5, 2.0},
+		{StrokeSetting{Pressure: true}, 0, 0, 2.0 * minPressureWidth},
+		{StrokeSetting{Pressure: true}, 1.5, 0, 2.0 * maxPressureWidth},
+		{StrokeSetting{Tilt: true}, 0.2, 1, 2.0},
+		{StrokeSetting{Tilt: true}, 0.2, 1.5, 3.0},
+		{StrokeSetting{Tilt: true}, 0.2, 10, 2.0 * maxTiltWidth},
+		{StrokeSetting{Tilt: true}, 0.2, 0.01, 2.0 * minTiltWidth},
+		{StrokeSetting{Tilt: true}, 0.2, 0, 2.0},
+		// the recorded width is used in place of pressure
+		{StrokeSetting{Pressure: true, Tilt: true}, 1, 0.5, 1.0},
+		{StrokeSetting{Pressure: true, Tilt: true}, 1, 0, 2.0 * maxPressureWidth},
+	} {
+		w := test.setting.PointWidth(2.0, test.pressure, test.recorded)
+		if math.Abs(w-test.expected) > 1e-6 {
+			t.Errorf("%+v pressure %f recorded %f width %f not %f", test.setting, test.pressure, test.recorded, w, test.expected)
+		}
+	}
+
+	if (&StrokeSetting{}).Variable() {
+		t.Error("settings without pressure or tilt should not be variable")
+	}
+	for _, pen := range []string{"pencil", "mechanical pencil", "paint"} {
+		ss := StrokeSettings[pen]
+		if !ss.Variable() || !ss.Tilt {
+			t.Errorf("%s should vary with the recorded width", pen)
+		}
+	}
+}
+
+// TestPointWidths tests that strokes with Tilt set follow the widths
+// recorded at each point relative to their mean
+func TestPointWidths(t *testing.T) {
+
+	points := []rmlines.Point{{Width: 1}, {Width: 2}, {Width: 3}}
+	widths, max := pointWidths(StrokeSetting{Tilt: true}, 2.0, points)
+	for i, expected := range []float64{1, 2, 3} {
+		if math.Abs(widths[i]-expected) > 1e-6 {
+			t.Errorf("point %d width %f not %f", i, widths[i], expected)
+		}
+	}
+	if max != 3 {
+		t.Errorf("max width %f not 3", max)
+	}
+
+	// strokes without recorded widths fall back to pressure
+	points = []rmlines.Point{{Pressure: 0}, {Pressure: 1}}
+	widths, _ = pointWidths(StrokeSetting{Pressure: true, Tilt: true}, 2.0, points)
+	if widths[0] != 2.0*minPressureWidth || widths[1] != 2.0*maxPressureWidth {
+		t.Errorf("widths %v do not follow pressure", widths)
+	}
+}
+
+// TestPointWidthRMFile tests adjusting stroke widths for the pressure
+// and widths recorded in a version 5 .rm file
+func TestPointWidthRMFile(t *testing.T) {
+
+	f, err := os.Open("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3/da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224.rm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	page, err := rmlines.Parse(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ss := StrokeSetting{Pressure: true}
+	strokes := page.Layers[0].Strokes
+	points := strokes[len(strokes)-1].Points
+	for i, p := range points {
+		w := ss.PointWidth(2.0, p.Pressure, 0)
+		if w < 2.0*minPressureWidth || w > 2.0*maxPressureWidth {
+			t.Errorf("point %d %+v width %f out of range", i, p, w)
+		}
+	}
+
+	// the last point has a pressure of 0.472628
+	last := points[len(points)-1]
+	expected := 2.0 * (minPressureWidth + 0.472628*(maxPressureWidth-minPressureWidth))
+	if w := ss.PointWidth(2.0, last.Pressure, 0); math.Abs(w-expected) > 1e-6 {
+		t.Errorf("last point width %f not %f", w, expected)
+	}
+
+	// the stroke records an even width of 4 at each point
+	widths, _ := pointWidths(StrokeSetting{Tilt: true}, 2.0, points)
+	for i, w := range widths {
+		if points[i].Width != 4 || math.Abs(w-2.0) > 1e-6 {
+			t.Errorf("point %d recorded width %f width %f not 2.0", i, points[i].Width, w)
+		}
+	}
+}
+
+// TestStrokeOutline tests the outline of a straight stroke
+func TestStrokeOutline(t *testing.T) {
+
+	points := []gofpdf.PointType{{X: 0, Y: 0}, {X: 10, Y: 0}, {X: 10, Y: 0}, {X: 20, Y: 0}}
+	widths := []float64{2, 4, 4, 6}
+
+	outline := strokeOutline(points, widths)
+
+	// 3 points on each side and two round ends, the repeated point
+	// being dropped
+	if len(outline) != 3*2+2*(capSteps+1) {
+		t.Fatalf("outline has %d points", len(outline))
+	}
+
+	// the outline is within the widest part of the stroke, and reaches
+	// the width of the stroke at each point
+	minX, maxX, minY, maxY := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, p := range outline {
+		minX, maxX = math.Min(minX, p.X), math.Max(maxX, p.X)
+		minY, maxY = math.Min(minY, p.Y), math.Max(maxY, p.Y)
+	}
+	expected := []float64{-1, 23, -3, 3}
+	for i, v := range []float64{minX, maxX, minY, maxY} {
+		if math.Abs(v-expected[i]) > 1e-9 {
+			t.Errorf("outline bounds %v not %v", []float64{minX, maxX, minY, maxY}, expected)
+			break
+		}
+	}
+	if outline[1] != (gofpdf.PointType{X: 10, Y: 2}) {
+		t.Errorf("left side of the second point %+v not 10/2", outline[1])
+	}
+
+	if o := strokeOutline(nil, nil); o != nil {
+		t.Errorf("an empty stroke should have no outline, got %v", o)
+	}
+	if o := strokeOutline(points[1:3], widths[1:3]); len(o) != capSteps+1 {
+		t.Errorf("a stroke at a single point should be a circle, got %v", o)
+	}
+}
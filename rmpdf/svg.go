@@ -0,0 +1,202 @@
+/*
+Render the marks on each page of a reMarkable bundle as a layered SVG
+image.
+
+Each .rm file layer is put in an Inkscape layer group, with strokes
+styled as they are for PDF output. The background PDF is not drawn,
+but sets the size of each image. Areas erased by eraser strokes are
+masked out of the strokes drawn before them in the same layer.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// SVGFileNames returns the names of the SVG files written for a bundle
+// of pageCount pages by ConvertSVG to outfile, being outfile, less any
+// ".svg" extension, followed by "-" and the 1-indexed page number
+func SVGFileNames(outfile string, pageCount int) []string {
+	return pageFileNames(strings.TrimSuffix(outfile, ".svg"), ".svg", pageCount)
+}
+
+// ConvertSVG converts the reMarkable bundle at inputpath, as for
+// Convert, to an SVG image of each page named as set out by
+// SVGFileNames, returning the names of the files written.
+//
+// Existing files are overwritten unless the Converter was made with
+// WithNoClobber, in which case an error satisfying
+// errors.Is(err, fs.ErrExist) is returned before any file is written.
+func (cv *Converter) ConvertSVG(inputpath, outfile string) ([]string, error) {
+	names := func(pageCount int) []string {
+		return SVGFileNames(outfile, pageCount)
+	}
+	return cv.convertPages(inputpath, names, (*conversion).writeSVGPage)
+}
+
+// writeSVGPage writes an SVG image of the marks on the 0-indexed page
+// rmPageNo to w, sized as the pdfPageNo page of the background pdf.
+// Pages whose .rm files cannot be parsed are recorded and written
+// without marks.
+func (c *conversion) writeSVGPage(w io.Writer, rmPageNo, pdfPageNo int, useTemplate bool, sourceFH *io.ReadSeeker) error {
+
+	pageWidth, pageHeight := c.pageSize(sourceFH, pdfPageNo, useTemplate)
+	t := newPageTransform(c.rmf.Orientation, pageWidth, pageHeight)
+
+	var s strings.Builder
+	s.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="no"?>` + "\n")
+	fmt.Fprintf(&s,
+		`<svg xmlns="http://www.w3.org/2000/svg" `+
+			`xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" `+
+			`xmlns:sodipodi="http://sodipodi.sourceforge.net/DTD/sodipodi-0.dtd" `+
+			`width="%.2fpt" height="%.2fpt" viewBox="0 0 %.2f %.2f">`+"\n",
+		pageWidth, pageHeight, pageWidth, pageHeight,
+	)
+
+	if ts, ok := c.pageTemplate(rmPageNo); ok && useTemplate {
+		svgTemplate(&s, ts.marks(t))
+	}
+
+	rmPage, page, err := c.parsePage(rmPageNo)
+	if err != nil {
+		c.skippedPages[rmPageNo] = err
+	}
+
+	pathNum := 0
+	for layerNo := 0; page != nil && layerNo < len(page.Layers); layerNo++ {
+
+		layer := page.Layers[layerNo]
+		c.debug(fmt.Sprintf("Beginning layer %d", layerNo+1))
+		display := ""
+		if !layer.Visible {
+			display = ` style="display:none"`
+		}
+		fmt.Fprintf(&s, `<g inkscape:groupmode="layer" inkscape:label="%s" id="layer%d"%s>`+"\n",
+			xmlEscape(layerName(*rmPage, layer, layerNo)), layerNo+1, display)
+
+		erased := c.erasedAreas(layerNo, layer, t)
+
+		for strokeNo, stroke := range layer.Strokes {
+
+			if isEraser(StrokeMap[stroke.Pen]) {
+				continue
+			}
+
+			p := c.strokePen(layerNo, stroke, pathNum)
+			ss := p.setting
+			width := t.width(p.width)
+			points := t.points(stroke.Points)
+			widths, maxWidth := pointWidths(ss, width, stroke.Points)
+
+			colour := fmt.Sprintf("rgb(%d,%d,%d)", p.r, p.g, p.b)
+
+			attrs := ""
+			if p.opacity != 1.0 {
+				attrs += fmt.Sprintf(` opacity="%.3f"`, p.opacity)
+			}
+			maskID := fmt.Sprintf("erase-%d-%d", layerNo+1, strokeNo)
+			if svgMask(&s, maskID, erased, strokeNo, pointsBox(points, maxWidth/2), pageWidth, pageHeight) {
+				attrs += fmt.Sprintf(` mask="url(#%s)"`, maskID)
+			}
+
+			if ss.Variable() {
+				if outline := strokeOutline(points, widths); len(outline) > 0 {
+					fmt.Fprintf(&s, `<path d="%s" fill="%s"%s/>`+"\n", svgPathData(outline, true), colour, attrs)
+				}
+			} else if len(points) > 0 {
+				fmt.Fprintf(&s,
+					`<path d="%s" fill="none" stroke="%s" stroke-width="%.3f" `+
+						`stroke-linecap="round" stroke-linejoin="round"%s/>`+"\n",
+					svgPathData(points, false), colour, width, attrs,
+				)
+			}
+			pathNum++
+		}
+
+		s.WriteString("</g>\n")
+	}
+
+	s.WriteString("</svg>\n")
+	_, err = io.WriteString(w, s.String())
+	return err
+}
+
+// svgTemplate writes the lines and dots of a stock template in a
+// locked "Template" layer
+func svgTemplate(s *strings.Builder, tm templateMarks) {
+	colour := fmt.Sprintf("rgb(%d,%d,%d)", templateGrey, templateGrey, templateGrey)
+	fmt.Fprintf(s, `<g inkscape:groupmode="layer" inkscape:label="Template" id="template" sodipodi:insensitive="true">`+"\n")
+	for _, l := range tm.lines {
+		fmt.Fprintf(s, `<line x1="%.2f" y1="%.2f" x2="%.2f" y2="%.2f" stroke="%s" stroke-width="%.3f"/>`+"\n",
+			l[0].X, l[0].Y, l[1].X, l[1].Y, colour, tm.lineWidth)
+	}
+	for _, d := range tm.dots {
+		fmt.Fprintf(s, `<circle cx="%.2f" cy="%.2f" r="%.3f" fill="%s"/>`+"\n", d.X, d.Y, tm.dotRadius, colour)
+	}
+	s.WriteString("</g>\n")
+}
+
+// svgMask writes a mask removing the areas erased after stroke strokeNo
+// that overlap box, reporting if a mask was written. The erased areas
+// are filled together, so overlapping areas are all removed.
+func svgMask(s *strings.Builder, id string, areas []erasedArea, strokeNo int, box bbox, width, height float64) bool {
+
+	var d strings.Builder
+	for _, a := range areas {
+		if a.strokeNo <= strokeNo || !a.box.overlaps(box) {
+			continue
+		}
+		if d.Len() > 0 {
+			d.WriteString(" ")
+		}
+		d.WriteString(svgPathData(a.polygon, true))
+	}
+	if d.Len() == 0 {
+		return false
+	}
+	fmt.Fprintf(s,
+		`<mask id="%s" maskUnits="userSpaceOnUse" x="0" y="0" width="%.2f" height="%.2f">`+
+			`<rect width="%.2f" height="%.2f" fill="white"/><path d="%s" fill="black"/></mask>`+"\n",
+		id, width, height, width, height, d.String(),
+	)
+	return true
+}
+
+// svgPathData returns the SVG path data for a line through points,
+// closed if close is set. A single point is drawn as a zero length line
+// so that its round cap is shown.
+func svgPathData(points []gofpdf.PointType, close bool) string {
+	var d strings.Builder
+	for i, p := range points {
+		op := "L"
+		if i == 0 {
+			op = "M"
+		} else {
+			d.WriteString(" ")
+		}
+		fmt.Fprintf(&d, "%s%.2f %.2f", op, p.X, p.Y)
+	}
+	if len(points) == 1 {
+		fmt.Fprintf(&d, " L%.2f %.2f", points[0].X, points[0].Y)
+	}
+	if close {
+		d.WriteString(" Z")
+	}
+	return d.String()
+}
+
+// xmlEscape escapes text for use in an XML attribute
+func xmlEscape(text string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(text))
+	return b.String()
+}
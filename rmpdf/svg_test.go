@@ -0,0 +1,177 @@
+/*
+svg_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	colornames "golang.org/x/image/colornames"
+)
+
+// svgSummary summarises an SVG image
+type svgSummary struct {
+	width, height string
+	layers        []string
+	paths         int
+	colours       map[string]int
+}
+
+// readSVG checks that an SVG image is well formed and summarises it
+func readSVG(t *testing.T, name string) svgSummary {
+	t.Helper()
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	s := svgSummary{colours: map[string]int{}}
+	d := xml.NewDecoder(f)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s is not well formed: %v", name, err)
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		attr := map[string]string{}
+		for _, a := range se.Attr {
+			attr[a.Name.Local] = a.Value
+		}
+		switch se.Name.Local {
+		case "svg":
+			s.width, s.height = attr["width"], attr["height"]
+		case "g":
+			if attr["groupmode"] == "layer" {
+				s.layers = append(s.layers, attr["label"])
+			}
+		case "path":
+			if attr["fill"] == "black" {
+				continue // mask path
+			}
+			s.paths++
+			s.colours[attr["stroke"]+attr["fill"]]++
+		}
+	}
+	return s
+}
+
+// TestConvertSVG tests writing an SVG image of each page
+func TestConvertSVG(t *testing.T) {
+
+	dir := t.TempDir()
+	c, err := NewConverter(WithLayerColours([]LocalColour{{"red", colornames.Red}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := c.ConvertSVG("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3", filepath.Join(dir, "output.svg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || filepath.Base(names[1]) != "output-02.svg" {
+		t.Fatalf("unexpected file names %v", names)
+	}
+
+	for i, name := range names {
+		s := readSVG(t, name)
+		if s.width != "595.28pt" || s.height != "841.89pt" {
+			t.Errorf("page %d size %s x %s not the pdf page size", i+1, s.width, s.height)
+		}
+		if len(s.layers) == 0 || s.paths == 0 {
+			t.Errorf("page %d has %d layers and %d paths", i+1, len(s.layers), s.paths)
+		}
+	}
+
+	// the first layer of the first page is coloured red
+	s := readSVG(t, names[0])
+	if s.colours["rgb(255,0,0)none"] == 0 {
+		t.Errorf("no red strokes found in %v", s.colours)
+	}
+}
+
+// TestSVGMask tests that only areas erased after a stroke, which
+// overlap it, are masked
+func TestSVGMask(t *testing.T) {
+
+	areas := append(
+		eraserAreas(1, "eraser", []gofpdf.PointType{{X: 10, Y: 10}, {X: 20, Y: 10}}, 2),
+		eraserAreas(3, "eraser", []gofpdf.PointType{{X: 50, Y: 50}, {X: 60, Y: 50}}, 2)...,
+	)
+	box := pointsBox([]gofpdf.PointType{{X: 0, Y: 10}, {X: 100, Y: 50}}, 1)
+
+	for _, test := range []struct {
+		strokeNo int
+		box      bbox
+		masked   bool
+		paths    int
+	}{
+		{0, box, true, 2},
+		{2, box, true, 1},
+		{3, box, false, 0},
+		{0, pointsBox([]gofpdf.PointType{{X: 150, Y: 80}}, 1), false, 0},
+	} {
+		var s strings.Builder
+		masked := svgMask(&s, "m", areas, test.strokeNo, test.box, 200, 100)
+		if masked != test.masked {
+			t.Errorf("stroke %d box %+v masked %t not %t", test.strokeNo, test.box, masked, test.masked)
+		}
+		if n := strings.Count(s.String(), "Z"); n != test.paths {
+			t.Errorf("stroke %d masked areas %d not %d", test.strokeNo, n, test.paths)
+		}
+	}
+}
+
+// TestSVGFileNames tests naming SVG files by page
+func TestSVGFileNames(t *testing.T) {
+	names := SVGFileNames("/tmp/sketch.svg", 10)
+	if names[0] != "/tmp/sketch-01.svg" || names[9] != "/tmp/sketch-10.svg" {
+		t.Errorf("unexpected names %v", names)
+	}
+	if names = SVGFileNames("sketch", 1); names[0] != "sketch-01.svg" {
+		t.Errorf("unexpected names %v", names)
+	}
+}
+
+// TestConvertSVGNoClobber tests that no files are written if any exist
+func TestConvertSVGNoClobber(t *testing.T) {
+
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "output-02.svg")
+	err := os.WriteFile(existing, []byte("existing"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConverter(WithNoClobber(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.ConvertSVG("../testfiles/version3.zip", filepath.Join(dir, "output"))
+	if !errors.Is(err, fs.ErrExist) {
+		t.Errorf("expected fs.ErrExist error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "output-01.svg")); err == nil {
+		t.Error("the first page should not have been written")
+	}
+	if b, _ := os.ReadFile(existing); !strings.HasPrefix(string(b), "existing") {
+		t.Error("existing file changed")
+	}
+}
@@ -0,0 +1,170 @@
+/*
+Vector re-creations of the stock reMarkable page templates.
+
+The tablet records the name of the template used by each page of a
+notebook, such as "P Lines medium" or "LS Grid small". Lined, grid and
+dotted templates, with or without a margin, are drawn from lines and
+dots, so that notebook pages look much as they did on the tablet.
+Other templates, including "Blank", are drawn as blank pages.
+
+The spacing of the lines and dots is an approximation of the tablet
+templates, in .rm file units of the display as it is held, which are
+about 226 to the inch.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// Template spacings in .rm file units
+const (
+	templateSmall  = 52
+	templateMedium = 70
+	templateLarge  = 92
+)
+
+// templateGrey is the grey level of template lines and dots
+const templateGrey = 180
+
+// templateLineWidth and templateDotRadius are the sizes of template
+// lines and dots in .rm file units
+const (
+	templateLineWidth = 2
+	templateDotRadius = 3
+)
+
+// templateStyle describes a stock template
+type templateStyle struct {
+	kind    string // "lines", "grid" or "dots"
+	spacing float64
+	margin  bool // a vertical margin line
+}
+
+// builtinTemplate returns the style of the stock template with the
+// given name, reporting if the name is of a lined, grid or dotted
+// template. Names are matched by their words, ignoring the "P" and
+// "LS" orientation prefixes, so "P Lines small", "Lined" and
+// "LS Grid margin large" are all recognised.
+func builtinTemplate(name string) (templateStyle, bool) {
+
+	words := strings.Fields(strings.ToLower(name))
+	if len(words) > 0 && (words[0] == "p" || words[0] == "ls") {
+		words = words[1:]
+	}
+
+	ts := templateStyle{spacing: templateMedium}
+	for _, w := range words {
+		switch w {
+		case "lines", "lined", "line":
+			ts.kind = "lines"
+		case "margin":
+			ts.margin = true
+			if ts.kind == "" {
+				ts.kind = "lines"
+			}
+		case "grid", "squared":
+			ts.kind = "grid"
+		case "dots", "dotted", "dot":
+			ts.kind = "dots"
+		case "small", "s":
+			ts.spacing = templateSmall
+		case "medium", "m":
+			ts.spacing = templateMedium
+		case "large", "l":
+			ts.spacing = templateLarge
+		}
+	}
+	return ts, ts.kind != ""
+}
+
+// templateMarks are the lines and dots of a template placed on a page
+type templateMarks struct {
+	lines     [][2]gofpdf.PointType
+	dots      []gofpdf.PointType
+	lineWidth float64
+	dotRadius float64
+}
+
+// marks places the lines and dots of a template on a page by t
+func (ts templateStyle) marks(t pageTransform) templateMarks {
+
+	width, height := t.viewSize()
+	tm := templateMarks{
+		lineWidth: templateLineWidth * t.scale,
+		dotRadius: templateDotRadius * t.scale,
+	}
+	line := func(x1, y1, x2, y2 float64) {
+		tm.lines = append(tm.lines, [2]gofpdf.PointType{t.viewPoint(x1, y1), t.viewPoint(x2, y2)})
+	}
+
+	// lined templates leave space for a heading
+	top := ts.spacing
+	if ts.kind == "lines" {
+		top = 2 * ts.spacing
+	}
+
+	switch ts.kind {
+	case "lines":
+		for y := top; y < height; y += ts.spacing {
+			line(0, y, width, y)
+		}
+	case "grid":
+		for y := top; y < height; y += ts.spacing {
+			line(0, y, width, y)
+		}
+		for x := ts.spacing; x < width; x += ts.spacing {
+			line(x, 0, x, height)
+		}
+	case "dots":
+		for y := top; y < height; y += ts.spacing {
+			for x := ts.spacing; x < width; x += ts.spacing {
+				tm.dots = append(tm.dots, t.viewPoint(x, y))
+			}
+		}
+	}
+	if ts.margin {
+		line(2*ts.spacing, 0, 2*ts.spacing, height)
+	}
+	return tm
+}
+
+// pageTemplate returns the stock template to draw on a template page
+// of the 0-indexed page rmPageNo, if the page used a lined, grid or
+// dotted template on the tablet. Templates set with WithTemplate, or
+// found in the directory set with WithTemplateDir, are used in place of
+// the stock templates.
+func (c *conversion) pageTemplate(rmPageNo int) (templateStyle, bool) {
+	if c.template != "" || rmPageNo >= len(c.rmf.Pages) {
+		return templateStyle{}, false
+	}
+	if _, ok := c.rmf.TemplatePath(rmPageNo); ok {
+		return templateStyle{}, false
+	}
+	name := c.rmf.Pages[rmPageNo].Template
+	ts, ok := builtinTemplate(name)
+	if ok {
+		c.debug(fmt.Sprintf("page %d template %s", rmPageNo+1, name))
+	}
+	return ts, ok
+}
+
+// drawTemplate draws the lines and dots of a template on the pdf
+func drawTemplate(pdf *gofpdf.Fpdf, tm templateMarks) {
+	pdf.SetDrawColor(templateGrey, templateGrey, templateGrey)
+	pdf.SetFillColor(templateGrey, templateGrey, templateGrey)
+	pdf.SetLineWidth(tm.lineWidth)
+	for _, l := range tm.lines {
+		pdf.Line(l[0].X, l[0].Y, l[1].X, l[1].Y)
+	}
+	for _, d := range tm.dots {
+		pdf.Circle(d.X, d.Y, tm.dotRadius, "F")
+	}
+}
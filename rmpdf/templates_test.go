@@ -0,0 +1,300 @@
+/*
+templates_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// TestBuiltinTemplate tests recognising stock template names
+func TestBuiltinTemplate(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		ok     bool
+		kind   string
+		margin bool
+		size   float64
+	}{
+		{"P Lines small", true, "lines", false, templateSmall},
+		{"Lined", true, "lines", false, templateMedium},
+		{"LS Grid margin large", true, "grid", true, templateLarge},
+		{"P Dots S", true, "dots", false, templateSmall},
+		{"P Margin medium", true, "lines", true, templateMedium},
+		{"Blank", false, "", false, 0},
+		{"P Calligraphy large", false, "", false, 0},
+		{"", false, "", false, 0},
+	}
+
+	for _, tt := range tests {
+		ts, ok := builtinTemplate(tt.name)
+		if ok != tt.ok {
+			t.Errorf("%q recognised %t not %t", tt.name, ok, tt.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if ts.kind != tt.kind || ts.margin != tt.margin || ts.spacing != tt.size {
+			t.Errorf("%q got %+v", tt.name, ts)
+		}
+	}
+}
+
+// TestTemplateMarks tests placing template lines and dots on a page
+func TestTemplateMarks(t *testing.T) {
+
+	tr := newPageTransform("portrait", RMWidth/Pts2RMPoints, RMHeight/Pts2RMPoints)
+	width, height := tr.viewSize()
+
+	lines := templateStyle{kind: "lines", spacing: 100}.marks(tr)
+	if n := int((height - 1) / 100); len(lines.lines) != n-1 || len(lines.dots) != 0 {
+		t.Errorf("lines %d and dots %d, expected %d lines", len(lines.lines), len(lines.dots), n-1)
+	}
+	for _, l := range lines.lines {
+		if l[0].Y != l[1].Y || l[0].X != 0 {
+			t.Errorf("line %v is not horizontal from the page edge", l)
+			break
+		}
+	}
+
+	grid := templateStyle{kind: "grid", spacing: 100, margin: true}.marks(tr)
+	n := int((height-1)/100) + int((width-1)/100) + 1
+	if len(grid.lines) != n {
+		t.Errorf("grid lines %d not %d", len(grid.lines), n)
+	}
+
+	dots := templateStyle{kind: "dots", spacing: 100}.marks(tr)
+	n = int((height-1)/100) * int((width-1)/100)
+	if len(dots.lines) != 0 || len(dots.dots) != n {
+		t.Errorf("dots %d not %d", len(dots.dots), n)
+	}
+}
+
+// TestConvertPageTemplate tests drawing the stock template recorded in
+// a bundle's .pagedata file, unless a template is set
+func TestConvertPageTemplate(t *testing.T) {
+
+	const id = "d34df12d-e72b-4939-a791-5b34b3a810e7"
+
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	bundle := filepath.Join(dir, id)
+
+	convert := func(options ...Option) []byte {
+		t.Helper()
+		options = append(options, WithLogger(log.New(io.Discard, "", 0)))
+		c, err := NewConverter(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := c.ConvertTo(bundle, &buf); err != nil {
+			t.Fatal(err)
+		}
+		return pageContents(t, buf.Bytes())[0]
+	}
+
+	blank := convert()
+	err := os.WriteFile(bundle+".pagedata", []byte("P Grid medium\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grid := convert()
+	if len(grid) <= len(blank) {
+		t.Errorf("grid page content %d bytes should be longer than blank %d", len(grid), len(blank))
+	}
+
+	if withTemplate := convert(WithTemplate("../templates/A4.pdf")); !bytes.Equal(withTemplate, blank) {
+		t.Error("a template set by WithTemplate should be used in place of the stock template")
+	}
+}
+
+// TestConvertAnnotatedPageTemplate tests that stock templates are not
+// looked up for the pages of an annotated pdf
+func TestConvertAnnotatedPageTemplate(t *testing.T) {
+
+	const id = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	bundle := filepath.Join(dir, id)
+	err := os.WriteFile(bundle+".pagedata", []byte("P Grid medium\nP Grid medium\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	c, err := NewConverter(WithLogger(log.New(&logs, "", 0)), WithVerbose(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConvertTo(bundle+".pdf", io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(logs.Bytes(), []byte("template P Grid medium")) {
+		t.Errorf("stock template used for an annotated pdf page: %s", logs.String())
+	}
+}
+
+// TestConvertTemplateDir tests using the template named in a bundle's
+// .pagedata file from a directory of templates in place of the stock
+// template
+func TestConvertTemplateDir(t *testing.T) {
+
+	const id = "d34df12d-e72b-4939-a791-5b34b3a810e7"
+
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	bundle := filepath.Join(dir, id)
+	err := os.WriteFile(bundle+".pagedata", []byte("P Grid medium\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	templates := filepath.Join(dir, "templates")
+	if err := os.Mkdir(templates, 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Create(filepath.Join(templates, "P Grid medium.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, image.NewGray(image.Rect(0, 0, 300, 400)))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "output.pdf")
+	convert := func(options ...Option) []byte {
+		t.Helper()
+		options = append(options, WithLogger(log.New(io.Discard, "", 0)))
+		c, err := NewConverter(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = os.Remove(output)
+		if err := c.Convert(bundle, output); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pageContents(t, b)[0]
+	}
+
+	stock := convert()
+	fromDir := convert(WithTemplateDir(templates))
+	if len(fromDir) >= len(stock) {
+		t.Errorf("the stock grid should not be drawn over the template from the directory")
+	}
+	p, err := pdfutil.NewPDFFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Round(p.Width) != 631 || math.Round(p.Height) != 842 {
+		t.Errorf("page size %f x %f not that of the png template", p.Width, p.Height)
+	}
+
+	if _, err := NewConverter(WithTemplateDir(filepath.Join(dir, "missing"))); err == nil {
+		t.Error("expected an error for a missing template directory")
+	}
+}
+
+// TestConvertTemplatePages tests choosing the pages of a multi-page
+// template, whose pages here differ in size
+func TestConvertTemplatePages(t *testing.T) {
+
+	dir := t.TempDir()
+	template := filepath.Join(dir, "planner.pdf")
+	planner := gofpdf.New("P", "pt", "A4", "")
+	planner.AddPage()
+	planner.AddPageFormat("P", gofpdf.SizeType{Wd: 612, Ht: 792})
+	if err := planner.OutputFileAndClose(template); err != nil {
+		t.Fatal(err)
+	}
+	bundle := notebook(t, 4)
+
+	widths := func(options ...Option) ([]float64, error) {
+		t.Helper()
+		options = append(options, WithTemplate(template), WithLogger(log.New(io.Discard, "", 0)))
+		c, err := NewConverter(options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output := filepath.Join(dir, "output.pdf")
+		_ = os.Remove(output)
+		if err := c.Convert(bundle, output); err != nil {
+			return nil, err
+		}
+		p, err := pdfutil.NewPDFFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := []float64{}
+		for _, pi := range p.PageInfo {
+			w = append(w, math.Round(pi.Width))
+		}
+		return w, nil
+	}
+
+	tests := []struct {
+		options  []Option
+		expected []float64
+	}{
+		{nil, []float64{595, 595, 595, 595}},
+		{[]Option{WithTemplatePages("cycle")}, []float64{595, 612, 595, 612}},
+		{[]Option{WithTemplatePages("match")}, []float64{595, 612, 612, 612}},
+		{[]Option{WithTemplatePageMap("1:2,3:2")}, []float64{612, 595, 612, 595}},
+	}
+	for i, tt := range tests {
+		got, err := widths(tt.options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(tt.expected) {
+			t.Errorf("test %d page widths %v not %v", i, got, tt.expected)
+		}
+	}
+
+	for _, spec := range []string{"5:1", "1:3"} {
+		if _, err := widths(WithTemplatePageMap(spec)); err == nil {
+			t.Errorf("expected an error for template page map %q", spec)
+		}
+	}
+	for _, spec := range []string{"1", "1:0", "a:1", "1:2,1:1", ""} {
+		if _, err := NewConverter(WithTemplatePageMap(spec)); err == nil {
+			t.Errorf("expected a parse error for template page map %q", spec)
+		}
+	}
+	c, err := NewConverter(WithTemplatePageMap("1:1"), WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ConvertTo("../testfiles/cc8313bb-5fab-4ab5-af39-46e6d4160df3", io.Discard); err == nil {
+		t.Error("expected an error mapping a page of an annotated pdf to a template page")
+	}
+	if _, err := NewConverter(WithTemplatePages("spread")); err == nil {
+		t.Error("expected an error for an unknown template page mode")
+	}
+}
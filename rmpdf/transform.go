@@ -0,0 +1,180 @@
+/*
+Transform tablet coordinates to the coordinates of pdf pages of any
+size.
+
+The tablet shows a pdf page fitted to the width of its display and
+centred vertically, so marks are placed on a page at the same scale.
+Landscape format bundles are shown on the display turned on its side.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/rorycl/rm2pdf/pdfutil"
+	"github.com/rorycl/rm2pdf/rmlines"
+)
+
+// RMWidth is the width of the tablet display in .rm file units
+const RMWidth = 1404
+
+// RMHeight is the height of the tablet display in .rm file units
+const RMHeight = 1872
+
+// pageTransform transforms tablet coordinates to pdf page coordinates
+type pageTransform struct {
+	landscape bool
+	scale     float64
+	offsetY   float64
+}
+
+// newPageTransform makes a pageTransform for a pdf page of the given
+// width and height in points, fitting the tablet display to the width
+// of the page and centring it vertically
+func newPageTransform(orientation string, width, height float64) pageTransform {
+	t := pageTransform{landscape: orientation != "portrait"}
+	viewWidth, viewHeight := t.viewSize()
+	t.scale = width / viewWidth
+	t.offsetY = (height - viewHeight*t.scale) / 2
+	return t
+}
+
+// point converts an rm point to a point on the pdf page. Landscape
+// format files need to be flipped.
+func (t pageTransform) point(p rmlines.Point) gofpdf.PointType {
+	x, y := float64(p.X), float64(p.Y)
+	if t.landscape {
+		x, y = RMHeight-y, x
+	}
+	return t.viewPoint(x, y)
+}
+
+// viewSize returns the size of the tablet display in .rm file units as
+// it is held, turned on its side for landscape format files
+func (t pageTransform) viewSize() (float64, float64) {
+	if t.landscape {
+		return RMHeight, RMWidth
+	}
+	return RMWidth, RMHeight
+}
+
+// viewPoint converts a point on the tablet display as it is held, in
+// .rm file units, to a point on the pdf page
+func (t pageTransform) viewPoint(x, y float64) gofpdf.PointType {
+	return gofpdf.PointType{X: x * t.scale, Y: y*t.scale + t.offsetY}
+}
+
+// points converts rm points to points on the pdf page
+func (t pageTransform) points(points []rmlines.Point) []gofpdf.PointType {
+	p := make([]gofpdf.PointType, len(points))
+	for i, point := range points {
+		p[i] = t.point(point)
+	}
+	return p
+}
+
+// width scales a stroke width, set for the standard 1/Pts2RMPoints
+// scale, to the page
+func (t pageTransform) width(w float64) float64 {
+	return w * t.scale * Pts2RMPoints
+}
+
+// pageSize returns the size in points of the 0-indexed page pdfPageNo
+// of the background pdf read from sourceFH. Templates, and pdfs whose
+// page sizes cannot be read, are turned to the orientation of the
+// bundle. Image templates have no pdf, and so take the size of the
+// reMarkable output pdfs.
+func (c *conversion) pageSize(sourceFH *io.ReadSeeker, pdfPageNo int, isTemplate bool) (float64, float64) {
+
+	dims, ok := c.pageSizes[sourceFH]
+	if !ok && *sourceFH != nil {
+		var err error
+		dims, err = pdfutil.PageDimensions(*sourceFH)
+		if err != nil {
+			c.debug(fmt.Sprintf("could not read page sizes: %v", err))
+		}
+		_, _ = (*sourceFH).Seek(0, io.SeekStart)
+		c.pageSizes[sourceFH] = dims
+		if mixedSizes(dims) {
+			c.debug("background pdf has pages of mixed sizes")
+		}
+	}
+
+	width, height := PDFWidthInMM*MMtoRMPoints, PDFHeightInMM*MMtoRMPoints
+	known := pdfPageNo < len(dims)
+	if known {
+		width, height = dims[pdfPageNo].Width, dims[pdfPageNo].Height
+	}
+	landscape := c.rmf.Orientation != "portrait"
+	if (isTemplate || !known) && (width > height) != landscape {
+		width, height = height, width
+	}
+	return width, height
+}
+
+// mixedSizes reports if the pages of a pdf are not all the same size
+func mixedSizes(dims []pdfutil.Dimensions) bool {
+	for _, d := range dims {
+		if d != dims[0] {
+			return true
+		}
+	}
+	return false
+}
+
+// importSource returns the stream, the 1-indexed page in that stream
+// and the page box from which to import the background pdf page of pg.
+// gofpdi sizes every page imported from a stream using the boxes of its
+// first page, so the pages of pdfs with pages of mixed sizes are
+// imported from single page pdfs split from the background pdf.
+func (c *conversion) importSource(pg pageJob) (*io.ReadSeeker, int, string) {
+
+	if !mixedSizes(c.pageSizes[pg.sourceFH]) {
+		return pg.sourceFH, pg.pdfPageNo + 1, c.importBox(pg.sourceFH)
+	}
+	pages, ok := c.pageStreams[pg.sourceFH]
+	if !ok {
+		var err error
+		pages, err = pdfutil.SplitPages(*pg.sourceFH)
+		if err != nil {
+			c.logger.Printf("background pdf pages of mixed sizes may not fill the page: %v", err)
+		}
+		_, _ = (*pg.sourceFH).Seek(0, io.SeekStart)
+		c.pageStreams[pg.sourceFH] = pages
+	}
+	if pg.pdfPageNo >= len(pages) {
+		return pg.sourceFH, pg.pdfPageNo + 1, c.importBox(pg.sourceFH)
+	}
+	return &pages[pg.pdfPageNo], 1, "/CropBox"
+}
+
+// importBox returns the page box with which to import pages from
+// sourceFH. gofpdi does not fall back to the MediaBox for pages without
+// a CropBox, so the MediaBox is used unless the first page has a
+// CropBox.
+func (c *conversion) importBox(sourceFH *io.ReadSeeker) string {
+
+	box, ok := c.importBoxes[sourceFH]
+	if ok {
+		return box
+	}
+	box = "/MediaBox"
+	if *sourceFH != nil {
+		cropped, err := pdfutil.HasCropBox(*sourceFH)
+		if err != nil {
+			c.debug(fmt.Sprintf("could not read page boxes: %v", err))
+		}
+		_, _ = (*sourceFH).Seek(0, io.SeekStart)
+		if cropped {
+			box = "/CropBox"
+		}
+	}
+	c.importBoxes[sourceFH] = box
+	return box
+}
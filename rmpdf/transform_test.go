@@ -0,0 +1,190 @@
+/*
+transform_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+	"github.com/jung-kurt/gofpdf/contrib/gofpdi"
+	"github.com/rorycl/rm2pdf/pdfutil"
+	"github.com/rorycl/rm2pdf/rmlines"
+)
+
+// TestPageTransform tests fitting the tablet display to pages
+func TestPageTransform(t *testing.T) {
+
+	near := func(a, b gofpdf.PointType) bool {
+		return math.Abs(a.X-b.X) < 1e-6 && math.Abs(a.Y-b.Y) < 1e-6
+	}
+
+	for _, test := range []struct {
+		name        string
+		orientation string
+		width       float64
+		height      float64
+		in          rmlines.Point
+		out         gofpdf.PointType
+	}{
+		// the display is 1404 x 1872, or 3:4
+		{"3:4 origin", "portrait", 702, 936, rmlines.Point{X: 0, Y: 0}, gofpdf.PointType{X: 0, Y: 0}},
+		{"3:4 corner", "portrait", 702, 936, rmlines.Point{X: 1404, Y: 1872}, gofpdf.PointType{X: 702, Y: 936}},
+		// a taller page has a margin above and below the display
+		{"tall", "portrait", 702, 1036, rmlines.Point{X: 0, Y: 0}, gofpdf.PointType{X: 0, Y: 50}},
+		// a slide is shown in the middle of the display
+		{"slide", "portrait", 1404, 790, rmlines.Point{X: 702, Y: 936}, gofpdf.PointType{X: 702, Y: 395}},
+		// landscape bundles turn the display on its side
+		{"landscape origin", "landscape", 936, 702, rmlines.Point{X: 0, Y: 1872}, gofpdf.PointType{X: 0, Y: 0}},
+		{"landscape corner", "landscape", 936, 702, rmlines.Point{X: 1404, Y: 0}, gofpdf.PointType{X: 936, Y: 702}},
+	} {
+		tr := newPageTransform(test.orientation, test.width, test.height)
+		if got := tr.point(test.in); !near(got, test.out) {
+			t.Errorf("%s: point %+v transformed to %+v not %+v", test.name, test.in, got, test.out)
+		}
+	}
+
+	// widths are scaled with the page
+	tr := newPageTransform("portrait", RMWidth/Pts2RMPoints*2, RMHeight/Pts2RMPoints*2)
+	if w := tr.width(1.5); math.Abs(w-3) > 1e-9 {
+		t.Errorf("width %f not 3", w)
+	}
+}
+
+// TestConvertLetterTemplate tests that pages take the size of a
+// non-A4 background
+func TestConvertLetterTemplate(t *testing.T) {
+
+	dir := t.TempDir()
+	template := filepath.Join(dir, "letter.pdf")
+	letter := gofpdf.New("P", "pt", "Letter", "")
+	letter.AddPage()
+	err := letter.OutputFileAndClose(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := NewConverter(WithTemplate(template))
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "output.pdf")
+	err = c.Convert("../testfiles/d34df12d-e72b-4939-a791-5b34b3a810e7", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := pdfutil.NewPDFFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Round(p.Width) != 612 || math.Round(p.Height) != 792 {
+		t.Errorf("page size %f x %f not 612 x 792", p.Width, p.Height)
+	}
+}
+
+// TestPageSize tests the orientation of page sizes
+func TestPageSize(t *testing.T) {
+
+	var buf bytes.Buffer
+	letter := gofpdf.New("P", "pt", "Letter", "")
+	letter.AddPage()
+	err := letter.Output(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rs io.ReadSeeker = bytes.NewReader(buf.Bytes())
+
+	for _, test := range []struct {
+		orientation string
+		isTemplate  bool
+		pageNo      int
+		width       float64
+		height      float64
+	}{
+		{"portrait", true, 0, 612, 792},
+		{"portrait", false, 0, 612, 792},
+		// templates are turned to the orientation of the bundle
+		{"landscape", true, 0, 792, 612},
+		{"landscape", false, 0, 612, 792},
+		// unknown pages use the default size
+		{"portrait", false, 1, PDFWidthInMM * MMtoRMPoints, PDFHeightInMM * MMtoRMPoints},
+		{"landscape", false, 1, PDFHeightInMM * MMtoRMPoints, PDFWidthInMM * MMtoRMPoints},
+	} {
+		c := &conversion{
+			Converter: &Converter{},
+			pageSizes: map[*io.ReadSeeker][]pdfutil.Dimensions{},
+		}
+		c.rmf.Orientation = test.orientation
+		w, h := c.pageSize(&rs, test.pageNo, test.isTemplate)
+		if math.Round(w) != math.Round(test.width) || math.Round(h) != math.Round(test.height) {
+			t.Errorf("%+v page size %f x %f", test, w, h)
+		}
+	}
+}
+
+// TestImportSource tests that the pages of background pdfs, including
+// those without a CropBox and those with pages of mixed sizes, are each
+// imported at their own size, and so are drawn without scaling
+func TestImportSource(t *testing.T) {
+
+	for _, test := range []struct {
+		name  string
+		sizes []gofpdf.SizeType
+	}{
+		{"same sizes", []gofpdf.SizeType{{Wd: 595.28, Ht: 841.89}, {Wd: 595.28, Ht: 841.89}}},
+		{"mixed sizes", []gofpdf.SizeType{{Wd: 595.28, Ht: 841.89}, {Wd: 612, Ht: 792}}},
+	} {
+		var buf bytes.Buffer
+		background := gofpdf.New("P", "pt", "A4", "")
+		for _, size := range test.sizes {
+			background.AddPageFormat("L", size)
+		}
+		err := background.Output(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rs io.ReadSeeker = bytes.NewReader(buf.Bytes())
+
+		c := &conversion{
+			Converter:   &Converter{},
+			pdf:         gofpdf.New("P", "pt", "A4", ""),
+			importer:    gofpdi.NewImporter(),
+			pageSizes:   map[*io.ReadSeeker][]pdfutil.Dimensions{},
+			pageStreams: map[*io.ReadSeeker][]io.ReadSeeker{},
+			importBoxes: map[*io.ReadSeeker]string{},
+		}
+		c.rmf.Orientation = "landscape"
+		c.pdf.SetCompression(false)
+		for i := range test.sizes {
+			pg := pageJob{pdfPageNo: i, sourceFH: &rs}
+			pg.width, pg.height = c.pageSize(&rs, i, false)
+			c.pdf.AddPageFormat("P", gofpdf.SizeType{Wd: pg.width, Ht: pg.height})
+			sourceFH, pageNo, box := c.importSource(pg)
+			tpl := c.importer.ImportPageFromStream(c.pdf, sourceFH, pageNo, box)
+			c.importer.UseImportedTemplate(c.pdf, tpl, 0, 0, pg.width, pg.height)
+		}
+		var out bytes.Buffer
+		if err := c.pdf.Output(&out); err != nil {
+			t.Fatal(err)
+		}
+
+		// the scale of each background is in its transformation matrix
+		scales := regexp.MustCompile(`q (\S+) 0 0 (\S+) \S+ \S+ cm /\S+ Do`).FindAllSubmatch(out.Bytes(), -1)
+		if len(scales) != len(test.sizes) {
+			t.Fatalf("%s: backgrounds should equal %d, got %d", test.name, len(test.sizes), len(scales))
+		}
+		for i, s := range scales {
+			if string(s[1]) != "1.0000" || string(s[2]) != "1.0000" {
+				t.Errorf("%s: page %d background scaled by %s x %s", test.name, i+1, s[1], s[2])
+			}
+		}
+	}
+}
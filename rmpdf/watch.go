@@ -0,0 +1,143 @@
+/*
+Watch a reMarkable bundle, converting it again each time it changes.
+
+Changes are found by polling the files of the bundle, so that bundles
+on network file systems such as sshfs, which do not report changes,
+may be watched.
+
+MIT licensed, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/rorycl/rm2pdf/files"
+)
+
+// maxWatchRetry is the longest delay before a failed conversion of a
+// watched bundle is tried again
+const maxWatchRetry = time.Minute
+
+// WatchEvent reports a conversion made while watching a bundle
+type WatchEvent struct {
+	Time     time.Time     // the time the conversion finished
+	Duration time.Duration // the time taken by the conversion
+	Err      error         // the conversion error, if any
+}
+
+// Watch converts the reMarkable bundle at inputpath to outfile, as for
+// Convert, and then polls the bundle's files every interval, converting
+// it again once changes to the files have stopped for debounce. The
+// pdf is written to a temporary file which replaces outfile, so that
+// readers of outfile never see a partly written pdf. Each conversion
+// is passed to report, if not nil.
+//
+// Watch returns when ctx is done, or with an error if the bundle cannot
+// be found or, for a Converter made with WithNoClobber, if outfile
+// exists before the first conversion. Conversion errors are reported
+// and do not stop Watch, since the tablet may be part way through
+// writing the bundle. A failed conversion is tried again after
+// interval, and then after twice as long each time it fails again, up
+// to maxWatchRetry, until it succeeds or the bundle changes.
+func (cv *Converter) Watch(ctx context.Context, inputpath, outfile string, interval, debounce time.Duration, report func(WatchEvent)) error {
+
+	if interval <= 0 {
+		return fmt.Errorf("invalid interval %s", interval)
+	}
+	if cv.noClobber {
+		if _, err := os.Stat(outfile); err == nil {
+			return fmt.Errorf("output file %s: %w", outfile, fs.ErrExist)
+		}
+	}
+
+	built, err := files.NewSnapshot(inputpath)
+	if err != nil {
+		return err
+	}
+	rebuild := func() error {
+		start := time.Now()
+		err := cv.convertReplace(inputpath, outfile)
+		if report != nil {
+			report(WatchEvent{Time: time.Now(), Duration: time.Since(start), Err: err})
+		}
+		return err
+	}
+	failed := rebuild() != nil
+	retry := interval // the delay before retrying a failed conversion
+	retryAt := time.Now().Add(retry)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := built         // the snapshot at the last poll
+	var changed time.Time // when last was first seen
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case now := <-ticker.C:
+			s, err := files.NewSnapshot(inputpath)
+			if err != nil {
+				// files may be missing while being rewritten
+				if cv.verbose {
+					cv.logger.Printf("could not poll %s: %v", inputpath, err)
+				}
+				continue
+			}
+			if !s.Equal(last) {
+				last, changed = s, now
+				continue
+			}
+			// convert again once changes have settled
+			if now.Sub(changed) < debounce {
+				continue
+			}
+			if s.Equal(built) {
+				// retry a failed conversion, backing off
+				if !failed || now.Before(retryAt) {
+					continue
+				}
+				retry *= 2
+				if retry > maxWatchRetry {
+					retry = maxWatchRetry
+				}
+			} else {
+				retry = interval
+			}
+			built = s
+			failed = rebuild() != nil
+			retryAt = time.Now().Add(retry)
+		}
+	}
+}
+
+// convertReplace converts the bundle at inputpath to a temporary file
+// which then replaces outfile
+func (cv *Converter) convertReplace(inputpath, outfile string) error {
+
+	f, err := os.CreateTemp(filepath.Dir(outfile), ".rm2pdf-*.pdf")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	err = cv.ConvertTo(inputpath, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), outfile)
+}
@@ -0,0 +1,180 @@
+/*
+watch_test.go
+MIT licenced, please see LICENCE
+RCL January 2020
+*/
+
+package rmpdf
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rorycl/rm2pdf/pdfutil"
+)
+
+// TestWatch tests that a watched bundle is converted again once it
+// changes
+func TestWatch(t *testing.T) {
+
+	const id = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	outfile := filepath.Join(t.TempDir(), "watched.pdf")
+
+	c, err := NewConverter(WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan WatchEvent, 10)
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(ctx, filepath.Join(dir, id+".pdf"), outfile, 10*time.Millisecond, 30*time.Millisecond,
+			func(e WatchEvent) { events <- e })
+	}()
+
+	wait := func(desc string) {
+		t.Helper()
+		select {
+		case e := <-events:
+			if e.Err != nil {
+				t.Fatalf("%s: %v", desc, e.Err)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: no conversion", desc)
+		}
+		if _, err := pdfutil.NewPDFFile(outfile); err != nil {
+			t.Fatalf("%s: %v", desc, err)
+		}
+	}
+	wait("first conversion")
+
+	// change a page's layer names
+	metadata := filepath.Join(dir, id, "da7f9a41-c2b2-4cbc-9c1b-5a20b5d54224-metadata.json")
+	err = os.WriteFile(metadata, []byte(`{"layers": [{"name": "Changed"}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(metadata, future, future); err != nil {
+		t.Fatal(err)
+	}
+	wait("conversion after change")
+
+	// no further conversions are made without changes
+	select {
+	case <-events:
+		t.Error("unexpected conversion")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("watch error %v", err)
+	}
+}
+
+// TestWatchRetry tests that a failed conversion is tried again although
+// the bundle has not changed
+func TestWatchRetry(t *testing.T) {
+
+	const id = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	outdir := filepath.Join(t.TempDir(), "missing")
+	outfile := filepath.Join(outdir, "watched.pdf")
+
+	c, err := NewConverter(WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan WatchEvent, 100)
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(ctx, filepath.Join(dir, id+".pdf"), outfile, 10*time.Millisecond, 30*time.Millisecond,
+			func(e WatchEvent) { events <- e })
+	}()
+
+	next := func(desc string) WatchEvent {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: no conversion", desc)
+		}
+		return WatchEvent{}
+	}
+
+	// the output directory is missing
+	if e := next("first conversion"); e.Err == nil {
+		t.Fatal("expected the first conversion to fail")
+	}
+	if err := os.Mkdir(outdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for e := next("retry"); e.Err != nil; e = next("retry") {
+	}
+	if _, err := pdfutil.NewPDFFile(outfile); err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("watch error %v", err)
+	}
+}
+
+// TestWatchRetryBackoff tests that a conversion which keeps failing is
+// tried again less and less often
+func TestWatchRetryBackoff(t *testing.T) {
+
+	const id = "cc8313bb-5fab-4ab5-af39-46e6d4160df3"
+	dir := t.TempDir()
+	copyBundle(t, id, dir)
+	outfile := filepath.Join(t.TempDir(), "missing", "watched.pdf")
+
+	c, err := NewConverter(WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := make(chan WatchEvent, 100)
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(ctx, filepath.Join(dir, id+".pdf"), outfile, 10*time.Millisecond, 30*time.Millisecond,
+			func(e WatchEvent) { events <- e })
+	}()
+
+	// retried after 10, 20, 40, 80, 160 and 320ms, rather than every
+	// 10ms
+	time.Sleep(500 * time.Millisecond)
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("watch error %v", err)
+	}
+	close(events)
+	n := 0
+	for e := range events {
+		if e.Err == nil {
+			t.Fatal("expected the conversion to fail")
+		}
+		n++
+	}
+	if n < 2 || n > 8 {
+		t.Errorf("got %d conversions, expected about 6", n)
+	}
+}